@@ -0,0 +1,98 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	"github.com/kubelix/deployer/pkg/importer"
+)
+
+// runImport implements "deployer import", which converts the manifests of an existing app into a Service. It returns
+// the exit code.
+func runImport(args []string) int {
+	flags := pflag.NewFlagSet("import", pflag.ContinueOnError)
+	filenames := flags.StringArrayP("filename", "f", nil, "files containing the manifests, - or none reads from stdin")
+	namespace := flags.StringP("namespace", "n", "default", "namespace of the Service if the Deployment does not set one")
+	adopt := flags.Bool("adopt", false, "also print the existing Deployment and Service annotated to be taken over in place")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if len(*filenames) == 0 {
+		*filenames = []string{"-"}
+	}
+
+	if err := importManifests(*filenames, importer.Options{Namespace: *namespace, Adopt: *adopt}, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func importManifests(filenames []string, opts importer.Options, out io.Writer) error {
+	objects := make([]runtime.Object, 0)
+	warnings := make([]string, 0)
+
+	for _, filename := range filenames {
+		content, err := readInput(filename)
+		if err != nil {
+			return err
+		}
+
+		decoded, decodeWarnings, err := importer.Decode(content)
+		if err != nil {
+			return fmt.Errorf("%s: %v", filename, err)
+		}
+		objects = append(objects, decoded...)
+		warnings = append(warnings, decodeWarnings...)
+	}
+
+	result, err := importer.Import(objects, opts)
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range append(warnings, result.Warnings...) {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	return writeManifests(out, append([]runtime.Object{result.Service}, result.Adopted...))
+}
+
+// writeManifests prints the objects as multi-document yaml without status and the fields set by the API server, so
+// they can be applied or committed as they are
+func writeManifests(out io.Writer, objects []runtime.Object) error {
+	for _, obj := range objects {
+		b, err := json.Marshal(obj)
+		if err != nil {
+			return err
+		}
+
+		fields := make(map[string]interface{})
+		if err := json.Unmarshal(b, &fields); err != nil {
+			return err
+		}
+		delete(fields, "status")
+		if metadata, ok := fields["metadata"].(map[string]interface{}); ok {
+			delete(metadata, "creationTimestamp")
+		}
+
+		y, err := yaml.Marshal(fields)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "---\n%s", y); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -17,6 +17,7 @@ import (
 	"github.com/kubelix/deployer/pkg/controller"
 	"github.com/kubelix/deployer/version"
 
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
 	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
 	kubemetrics "github.com/operator-framework/operator-sdk/pkg/kube-metrics"
 	"github.com/operator-framework/operator-sdk/pkg/leader"
@@ -48,6 +49,16 @@ func printVersion() {
 }
 
 func main() {
+	// subcommands run without a cluster, everything else starts the operator
+	if len(os.Args) > 1 {
+		switch os.Args[1] {
+		case "render":
+			os.Exit(runRender(os.Args[2:]))
+		case "import":
+			os.Exit(runImport(os.Args[2:]))
+		}
+	}
+
 	// Add the zap logger flag set to the CLI. The flag set must
 	// be added before calling pflag.Parse().
 	pflag.CommandLine.AddFlagSet(zap.FlagSet())
@@ -56,6 +67,8 @@ func main() {
 	// controller-runtime)
 	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
 
+	pflag.CommandLine.BoolVar(&deployerConfig.DryRun, "dry-run", false, "report the changes to managed objects in the service status instead of applying them")
+
 	pflag.Parse()
 
 	// Use a zap logr.Logger implementation. If none of the zap
@@ -95,6 +108,7 @@ func main() {
 	mgr, err := manager.New(cfg, manager.Options{
 		Namespace:          namespace,
 		MetricsBindAddress: fmt.Sprintf("%s:%d", metricsHost, metricsPort),
+		NewClient:          controller.NewClient,
 	})
 	if err != nil {
 		log.Error(err, "")
@@ -112,6 +126,12 @@ func main() {
 		os.Exit(1)
 	}
 
+	// ServiceMonitors are generated for apps exposing metrics if the prometheus operator is installed
+	if err := monitoringv1.AddToScheme(mgr.GetScheme()); err != nil {
+		log.Error(err, "")
+		os.Exit(1)
+	}
+
 	// Setup all Controllers
 	if err := controller.AddToManager(mgr); err != nil {
 		log.Error(err, "")
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/ghodss/yaml"
+	"github.com/spf13/pflag"
+	"k8s.io/apimachinery/pkg/runtime"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+
+	"github.com/kubelix/deployer/pkg/apis"
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	deployerConfig "github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/controller/service"
+)
+
+// runRender implements "deployer render", which prints the objects generated for a service as multi-document yaml
+// without connecting to a cluster. It returns the exit code.
+func runRender(args []string) int {
+	flags := pflag.NewFlagSet("render", pflag.ContinueOnError)
+	filename := flags.StringP("filename", "f", "", "file containing the Service, - reads from stdin")
+	configFile := flags.StringP("config", "c", "", "config file of the deployer, the defaults are used if omitted")
+	namespace := flags.StringP("namespace", "n", "default", "namespace of the Service if it does not set one")
+	serviceMonitors := flags.Bool("service-monitors", false, "render ServiceMonitors instead of scrape annotations, as in clusters running the prometheus operator")
+
+	if err := flags.Parse(args); err != nil {
+		return 2
+	}
+	if *filename == "" {
+		fmt.Fprintln(os.Stderr, "error: --filename is required")
+		return 2
+	}
+
+	if err := render(*filename, *configFile, *namespace, *serviceMonitors, os.Stdout); err != nil {
+		fmt.Fprintf(os.Stderr, "error: %v\n", err)
+		return 1
+	}
+
+	return 0
+}
+
+func render(filename, configFile, namespace string, serviceMonitors bool, out io.Writer) error {
+	if err := loadConfig(configFile); err != nil {
+		return err
+	}
+
+	content, err := readInput(filename)
+	if err != nil {
+		return err
+	}
+
+	svc := &appsv1alpha1.Service{}
+	if err := yaml.UnmarshalStrict(content, svc); err != nil {
+		return fmt.Errorf("failed to parse service: %v", err)
+	}
+	if svc.Namespace == "" {
+		svc.Namespace = namespace
+	}
+
+	s, err := newScheme()
+	if err != nil {
+		return err
+	}
+
+	objects, warnings, err := service.Render(svc, service.RenderOptions{Scheme: s, ServiceMonitors: serviceMonitors})
+	if err != nil {
+		return err
+	}
+
+	for _, warning := range warnings {
+		fmt.Fprintf(os.Stderr, "warning: %s\n", warning)
+	}
+
+	return writeYAML(out, objects)
+}
+
+// loadConfig loads the config file of the deployer, or the defaults if no file is given
+func loadConfig(path string) error {
+	if path == "" {
+		deployerConfig.Config = *deployerConfig.NewConfig()
+		return nil
+	}
+
+	cfg, err := deployerConfig.Load(path)
+	if err != nil {
+		return err
+	}
+	deployerConfig.Config = *cfg
+
+	return nil
+}
+
+// readInput reads the given file, or stdin if the name is -
+func readInput(filename string) ([]byte, error) {
+	if filename == "-" {
+		return ioutil.ReadAll(os.Stdin)
+	}
+
+	return ioutil.ReadFile(filename)
+}
+
+// newScheme returns a scheme of all types the deployer generates, without the manager setting it up
+func newScheme() (*runtime.Scheme, error) {
+	s := runtime.NewScheme()
+
+	for _, add := range []func(*runtime.Scheme) error{clientgoscheme.AddToScheme, apis.AddToScheme, monitoringv1.AddToScheme} {
+		if err := add(s); err != nil {
+			return nil, err
+		}
+	}
+
+	return s, nil
+}
+
+// writeYAML prints the objects as multi-document yaml
+func writeYAML(out io.Writer, objects []runtime.Object) error {
+	for _, obj := range objects {
+		b, err := yaml.Marshal(obj)
+		if err != nil {
+			return err
+		}
+
+		if _, err := fmt.Fprintf(out, "---\n%s", b); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,47 @@
+package v1alpha1
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// Find returns the condition of the given type or nil if it is not set
+func (in ConditionList) Find(conditionType ConditionType) *Condition {
+	for i := range in {
+		if in[i].Type == conditionType {
+			return &in[i]
+		}
+	}
+
+	return nil
+}
+
+// IsTrue returns whether the condition of the given type is set and true
+func (in ConditionList) IsTrue(conditionType ConditionType) bool {
+	condition := in.Find(conditionType)
+	return condition != nil && condition.Status == corev1.ConditionTrue
+}
+
+// Set adds or replaces the condition of the same type. The transition time is only changed if the status changes.
+// It returns whether the condition was changed at all.
+func (in *ConditionList) Set(condition Condition) bool {
+	existing := in.Find(condition.Type)
+	if existing == nil {
+		condition.LastTransitionTime = metav1.Now()
+		*in = append(*in, condition)
+		return true
+	}
+
+	if existing.Status == condition.Status && existing.Reason == condition.Reason && existing.Message == condition.Message {
+		return false
+	}
+
+	if existing.Status != condition.Status {
+		existing.LastTransitionTime = metav1.Now()
+	}
+	existing.Status = condition.Status
+	existing.Reason = condition.Reason
+	existing.Message = condition.Message
+
+	return true
+}
@@ -0,0 +1,33 @@
+package v1alpha1
+
+// Latest returns the revision with the highest number or nil if the list is empty
+func (in RevisionList) Latest() *Revision {
+	var latest *Revision
+	for i := range in {
+		if latest == nil || in[i].Revision > latest.Revision {
+			latest = &in[i]
+		}
+	}
+
+	return latest
+}
+
+// Find returns the revision with the given number or nil if it is not part of the list
+func (in RevisionList) Find(revision int64) *Revision {
+	for i := range in {
+		if in[i].Revision == revision {
+			return &in[i]
+		}
+	}
+
+	return nil
+}
+
+// Prune removes the oldest revisions so that at most limit revisions are kept
+func (in *RevisionList) Prune(limit int) {
+	if limit < 1 || len(*in) <= limit {
+		return
+	}
+
+	*in = (*in)[len(*in)-limit:]
+}
@@ -3,7 +3,10 @@ package v1alpha1
 import (
 	"fmt"
 
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
 	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
@@ -16,13 +19,102 @@ type ServiceSpec struct {
 	Command   []string `json:"command,omitempty"`
 	Args      []string `json:"args,omitempty"`
 
+	// +kubebuilder:validation:Enum=Always;IfNotPresent;Never
+	ImagePullPolicy corev1.PullPolicy `json:"imagePullPolicy,omitempty"`
+	// PinDigest resolves the tag of the image to a digest whenever the image changes and deploys that digest, so
+	// all pods run the same image even if the tag is moved
+	PinDigest bool `json:"pinDigest,omitempty"`
+	// ImagePolicy updates the image automatically whenever a newer matching tag is pushed to the registry
+	ImagePolicy *ImagePolicy `json:"imagePolicy,omitempty"`
+
+	// ProgressDeadlineSeconds is the time a rollout may take before it is considered failed, defaults to 600
+	ProgressDeadlineSeconds *int32 `json:"progressDeadlineSeconds,omitempty"`
+	// AutoRollback restores the last revision that became ready when a rollout exceeds its progress deadline
+	AutoRollback bool `json:"autoRollback,omitempty"`
+
+	// Hooks run jobs from the pod template of the service at certain points of a rollout
+	Hooks *Hooks `json:"hooks,omitempty"`
+
+	// Schedule in cron format runs the service as a cron job instead of a long running workload
+	Schedule string `json:"schedule,omitempty"`
+	// CronJob configures the cron job of services with a schedule
+	CronJob *CronJobOptions `json:"cronJob,omitempty"`
+
+	// WorkloadKind is the kind of workload running the pods, defaults to Deployment
+	// +kubebuilder:validation:Enum=Deployment;StatefulSet
+	WorkloadKind WorkloadKind `json:"workloadKind,omitempty"`
+	// VolumeClaimTemplates create a persistent volume per pod of a StatefulSet
+	VolumeClaimTemplates []VolumeClaimTemplate `json:"volumeClaimTemplates,omitempty"`
+
+	// Strategy of rollouts, defaults to a rolling update of the deployment. It only applies to deployments.
+	// +kubebuilder:validation:Enum=rollingUpdate;canary;blueGreen
+	Strategy Strategy `json:"strategy,omitempty"`
+	// Canary configures the steps of canary rollouts
+	Canary *CanaryStrategy `json:"canary,omitempty"`
+	// BlueGreen configures the promotion of blue/green rollouts
+	BlueGreen *BlueGreenStrategy `json:"blueGreen,omitempty"`
+
 	Ports              PortList                    `json:"ports,omitempty"`
 	Resources          corev1.ResourceRequirements `json:"resources,omitempty"`
 	Env                Environment                 `json:"env,omitempty"`
 	Files              []File                      `json:"files,omitempty"`
+	Volumes            []Volume                    `json:"volumes,omitempty"`
 	ServiceAccountName string                      `json:"serviceAccountName,omitempty"`
+	// ServiceAccount creates the service account of the pods together with its permissions
+	ServiceAccount *ServiceAccount `json:"serviceAccount,omitempty"`
+
+	// SecurityContext of the pods and the app container, unset fields are taken from the security profile
+	SecurityContext *SecurityContext `json:"securityContext,omitempty"`
+
+	// NodeSelector is merged into the node selector of the deployment config
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	// Tolerations are added to the tolerations of the deployment config
+	Tolerations []corev1.Toleration `json:"tolerations,omitempty"`
+	// TopologySpreadConstraints replace the constraints of the deployment config. Constraints without a label selector
+	// select the pods of the service.
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	// PriorityClassName overrides the priority class of the deployment config
+	PriorityClassName string `json:"priorityClassName,omitempty"`
+	// AntiAffinity keeps the pods of the service on different nodes, defaults to the mode of the deployment config
+	// +kubebuilder:validation:Enum=preferred;required;disabled
+	AntiAffinity AntiAffinityMode `json:"antiAffinity,omitempty"`
+
+	// Metrics exposes the metrics of the app to prometheus
+	Metrics *Metrics `json:"metrics,omitempty"`
+
+	// IngressFrom lists the peers allowed to connect to the pods of the service. If set, all other incoming traffic
+	// is denied, except traffic from the ingress controller to ports exposed by ingresses.
+	IngressFrom []NetworkPeer `json:"ingressFrom,omitempty"`
+	// EgressTo lists the peers the pods of the service may connect to. If set, all other outgoing traffic is denied,
+	// except DNS lookups.
+	EgressTo []NetworkPeer `json:"egressTo,omitempty"`
+}
+
+// NetworkPeer references other services, networks or the ingress controller. Either Service and / or Project, CIDR or
+// IngressController has to be set.
+type NetworkPeer struct {
+	// Service is the name of a kubelix service, in the same project unless Project is set
+	Service string `json:"service,omitempty"`
+	// Project selects the services of a project by the apps.kubelix.io/project label
+	Project string `json:"project,omitempty"`
+	// CIDR is a block of IP addresses like 10.0.0.0/8
+	CIDR string `json:"cidr,omitempty"`
+	// IngressController references the pods of the ingress controller configured in the deployer config
+	IngressController bool `json:"ingressController,omitempty"`
 }
 
+// AntiAffinityMode defines how strictly pods of a service are spread across nodes
+type AntiAffinityMode string
+
+const (
+	// AntiAffinityPreferred schedules pods on different nodes if possible
+	AntiAffinityPreferred AntiAffinityMode = "preferred"
+	// AntiAffinityRequired never schedules two pods of the service on the same node
+	AntiAffinityRequired AntiAffinityMode = "required"
+	// AntiAffinityDisabled adds no anti-affinity
+	AntiAffinityDisabled AntiAffinityMode = "disabled"
+)
+
 // Environment defines env vars for the app container
 type Environment map[string]string
 
@@ -52,9 +144,357 @@ type File struct {
 	Content string `json:"content"`
 }
 
+// Hooks defines the jobs run during a rollout
+type Hooks struct {
+	// PreDeploy runs before a new revision is rolled out, the workloads are only updated once it succeeded
+	PreDeploy *Hook `json:"preDeploy,omitempty"`
+}
+
+// Hook defines a job running the image of the service with a different command
+type Hook struct {
+	Command []string `json:"command,omitempty"`
+	Args    []string `json:"args,omitempty"`
+	// BackoffLimit is the number of retries before the hook is considered failed, defaults to 0
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+	// ActiveDeadlineSeconds is the time the hook may run before it is considered failed
+	ActiveDeadlineSeconds *int64 `json:"activeDeadlineSeconds,omitempty"`
+}
+
+// CronJobOptions configures the cron job of a scheduled service
+type CronJobOptions struct {
+	// ConcurrencyPolicy defines how concurrent runs are treated, defaults to Forbid
+	// +kubebuilder:validation:Enum=Allow;Forbid;Replace
+	ConcurrencyPolicy batchv1beta1.ConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	// Suspend stops scheduling new runs, runs can still be triggered manually
+	Suspend bool `json:"suspend,omitempty"`
+	// SuccessfulJobsHistoryLimit defaults to 3
+	SuccessfulJobsHistoryLimit *int32 `json:"successfulJobsHistoryLimit,omitempty"`
+	// FailedJobsHistoryLimit defaults to 1
+	FailedJobsHistoryLimit *int32 `json:"failedJobsHistoryLimit,omitempty"`
+	// StartingDeadlineSeconds is the time a run may start late before it is skipped
+	StartingDeadlineSeconds *int64 `json:"startingDeadlineSeconds,omitempty"`
+	// BackoffLimit is the number of retries of a failed run, defaults to 6
+	BackoffLimit *int32 `json:"backoffLimit,omitempty"`
+}
+
+// WorkloadKind defines the kind of the workload running the pods of a service
+type WorkloadKind string
+
+// supported workload kinds
+const (
+	WorkloadKindDeployment  WorkloadKind = "Deployment"
+	WorkloadKindStatefulSet WorkloadKind = "StatefulSet"
+)
+
+// VolumeClaimTemplate defines a persistent volume claimed for each pod of a StatefulSet
+type VolumeClaimTemplate struct {
+	Name      string            `json:"name"`
+	Size      resource.Quantity `json:"size"`
+	MountPath string            `json:"mountPath"`
+	// StorageClassName defaults to the default storage class of the cluster
+	StorageClassName *string `json:"storageClassName,omitempty"`
+}
+
+// Strategy defines how a new revision is rolled out
+type Strategy string
+
+const (
+	// StrategyRollingUpdate replaces the pods of the deployment step by step
+	StrategyRollingUpdate Strategy = "rollingUpdate"
+	// StrategyCanary rolls out a new revision to a second deployment which receives a growing share of the traffic
+	StrategyCanary Strategy = "canary"
+	// StrategyBlueGreen rolls out a new revision to the inactive one of two deployments and switches the traffic to it
+	// once all of its pods are ready
+	StrategyBlueGreen Strategy = "blueGreen"
+)
+
+// Color identifies one of the two deployments of a blue/green rollout
+type Color string
+
+// colors of the two deployments of a blue/green rollout
+const (
+	ColorBlue  Color = "blue"
+	ColorGreen Color = "green"
+)
+
+// Other returns the opposite color
+func (c Color) Other() Color {
+	if c == ColorBlue {
+		return ColorGreen
+	}
+	return ColorBlue
+}
+
+// CanaryStrategy defines the traffic weights a canary receives before it is promoted
+type CanaryStrategy struct {
+	// Steps are the shares of traffic in percent the canary receives, defaults to 10, 50 and 100
+	Steps []int32 `json:"steps,omitempty"`
+	// Interval after which the next step is entered. Without an interval steps are only advanced by annotating the
+	// service with apps.kubelix.io/canary-promote.
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
+// BlueGreenStrategy defines how the preview of a blue/green rollout is exposed and promoted
+type BlueGreenStrategy struct {
+	// AutoPromote switches the traffic to the preview as soon as all of its pods are ready. Otherwise the preview is
+	// promoted by annotating the service with apps.kubelix.io/promote.
+	AutoPromote bool `json:"autoPromote,omitempty"`
+	// PreviewHost creates an ingress for the preview service with this host
+	PreviewHost string `json:"previewHost,omitempty"`
+	// PreviewPort is the name of the port the preview ingress sends traffic to, defaults to the first port
+	PreviewPort string `json:"previewPort,omitempty"`
+	// ScaleDownDelay is the time the previously active deployment is kept after a promotion, defaults to 5m
+	ScaleDownDelay *metav1.Duration `json:"scaleDownDelay,omitempty"`
+}
+
+// Volume defines a volume mounted into the app container. Exactly one source has to be set.
+type Volume struct {
+	Name      string `json:"name"`
+	MountPath string `json:"mountPath"`
+	ReadOnly  bool   `json:"readOnly,omitempty"`
+
+	// PersistentVolumeClaim creates a claim managed by the deployer
+	PersistentVolumeClaim *VolumeClaim `json:"persistentVolumeClaim,omitempty"`
+	// ExistingClaimName mounts a claim that was created upfront
+	ExistingClaimName string `json:"existingClaimName,omitempty"`
+	// EmptyDir adds scratch space that lives as long as the pod
+	EmptyDir *EmptyDirVolume `json:"emptyDir,omitempty"`
+}
+
+// VolumeClaim defines a persistent volume claim managed by the deployer
+type VolumeClaim struct {
+	Size resource.Quantity `json:"size"`
+	// AccessMode defaults to ReadWriteOnce
+	// +kubebuilder:validation:Enum=ReadWriteOnce;ReadOnlyMany;ReadWriteMany
+	AccessMode corev1.PersistentVolumeAccessMode `json:"accessMode,omitempty"`
+	// StorageClassName defaults to the default storage class of the cluster
+	StorageClassName *string `json:"storageClassName,omitempty"`
+	// ReclaimPolicy defines whether the claim is deleted together with the service, defaults to Retain
+	// +kubebuilder:validation:Enum=Retain;Delete
+	ReclaimPolicy corev1.PersistentVolumeReclaimPolicy `json:"reclaimPolicy,omitempty"`
+}
+
+// EmptyDirVolume defines scratch space of a pod
+type EmptyDirVolume struct {
+	// Tmpfs keeps the volume in memory
+	Tmpfs     bool               `json:"tmpfs,omitempty"`
+	SizeLimit *resource.Quantity `json:"sizeLimit,omitempty"`
+}
+
+// Metrics defines where prometheus scrapes the metrics of the app. A ServiceMonitor is created if the prometheus
+// operator is installed, otherwise the pods are annotated for scraping.
+type Metrics struct {
+	// Port is the name of the port serving the metrics
+	Port string `json:"port"`
+	// Path defaults to /metrics
+	Path string `json:"path,omitempty"`
+	// Interval between two scrapes like 30s, defaults to the interval of prometheus
+	Interval string `json:"interval,omitempty"`
+	// ScrapeTimeout defaults to the timeout of prometheus
+	ScrapeTimeout string `json:"scrapeTimeout,omitempty"`
+}
+
+// ServiceAccount defines the service account of a service and the permissions granted to it
+type ServiceAccount struct {
+	// Create generates a service account named like the service, or serviceAccountName if set
+	Create bool `json:"create,omitempty"`
+	// Annotations of the generated service account, e.g. for workload identity
+	Annotations map[string]string `json:"annotations,omitempty"`
+	// Rules are granted to the service account by a role in the namespace of the service
+	Rules []rbacv1.PolicyRule `json:"rules,omitempty"`
+}
+
+// SecurityContext defines the security settings of the pods and the app container
+type SecurityContext struct {
+	// Profile overrides the default security profile of the deployer config. With "none" only the settings given
+	// here are applied.
+	// +kubebuilder:validation:Enum=hardened;none
+	Profile SecurityProfile `json:"profile,omitempty"`
+
+	RunAsUser                *int64               `json:"runAsUser,omitempty"`
+	RunAsGroup               *int64               `json:"runAsGroup,omitempty"`
+	RunAsNonRoot             *bool                `json:"runAsNonRoot,omitempty"`
+	FSGroup                  *int64               `json:"fsGroup,omitempty"`
+	ReadOnlyRootFilesystem   *bool                `json:"readOnlyRootFilesystem,omitempty"`
+	AllowPrivilegeEscalation *bool                `json:"allowPrivilegeEscalation,omitempty"`
+	Capabilities             *corev1.Capabilities `json:"capabilities,omitempty"`
+	SeccompProfile           *SeccompProfile      `json:"seccompProfile,omitempty"`
+}
+
+// SecurityProfile is a set of default security settings
+type SecurityProfile string
+
+const (
+	// SecurityProfileHardened runs as non-root without privilege escalation, drops all capabilities and uses the
+	// seccomp profile of the container runtime
+	SecurityProfileHardened SecurityProfile = "hardened"
+	// SecurityProfileNone applies no default security settings
+	SecurityProfileNone SecurityProfile = "none"
+)
+
+// SeccompProfile selects the seccomp profile of the pods
+type SeccompProfile struct {
+	// +kubebuilder:validation:Enum=RuntimeDefault;Unconfined;Localhost
+	Type SeccompProfileType `json:"type"`
+	// LocalhostProfile is the path of the profile on the node relative to the seccomp directory of the kubelet,
+	// only used with type Localhost
+	LocalhostProfile string `json:"localhostProfile,omitempty"`
+}
+
+// SeccompProfileType is the kind of seccomp profile
+type SeccompProfileType string
+
+const (
+	// SeccompProfileRuntimeDefault uses the default profile of the container runtime
+	SeccompProfileRuntimeDefault SeccompProfileType = "RuntimeDefault"
+	// SeccompProfileUnconfined disables seccomp
+	SeccompProfileUnconfined SeccompProfileType = "Unconfined"
+	// SeccompProfileLocalhost uses a profile stored on the node
+	SeccompProfileLocalhost SeccompProfileType = "Localhost"
+)
+
+// ImagePolicyPausedAnnotation on a service stops its image policy from updating the image. It is set when the service
+// is rolled back and holds the checksum of the restored spec, so the rollback is not undone by the policy. It is removed
+// once a spec different from the restored one is applied, or can be removed manually to resume the policy.
+const ImagePolicyPausedAnnotation = "apps.kubelix.io/image-policy-paused"
+
+// DryRunAnnotation on a service reports the changes of reconciles in its status instead of applying them. Its image
+// policy is not applied either.
+const DryRunAnnotation = "apps.kubelix.io/dry-run"
+
+// ImagePolicy selects the newest tag of the image repository. Tags need to match the pattern and the semver range,
+// if set. Tags are ordered by semantic version if a range is set, alphabetically otherwise.
+type ImagePolicy struct {
+	// Semver is a range like ">=1.2.0 <2.0.0", "^1.2" or "~1.2.3", ranges can be combined with "||"
+	Semver string `json:"semver,omitempty"`
+	// Pattern is a regular expression tags need to match
+	Pattern string `json:"pattern,omitempty"`
+	// Interval between two checks of the registry, defaults to 5m
+	Interval *metav1.Duration `json:"interval,omitempty"`
+}
+
 // ServiceStatus defines the observed state of Service
 type ServiceStatus struct {
-	ManagedObjects ManagedObjectList `json:"managedObjects,omitempty"`
+	ManagedObjects ManagedObjectList  `json:"managedObjects,omitempty"`
+	ResolvedImage  *ResolvedImage     `json:"resolvedImage,omitempty"`
+	ImagePolicy    *ImagePolicyStatus `json:"imagePolicy,omitempty"`
+	Revisions      RevisionList       `json:"revisions,omitempty"`
+	Conditions     ConditionList      `json:"conditions,omitempty"`
+
+	// LastReadyRevision is the latest revision whose rollout completed with all replicas available
+	LastReadyRevision int64 `json:"lastReadyRevision,omitempty"`
+	// PreDeployRevision is the latest revision whose pre-deploy hook succeeded
+	PreDeployRevision int64 `json:"preDeployRevision,omitempty"`
+	// PreDeployChecksum is the checksum of the pod the last succeeded pre-deploy hook ran with
+	PreDeployChecksum string `json:"preDeployChecksum,omitempty"`
+
+	Canary    *CanaryStatus    `json:"canary,omitempty"`
+	BlueGreen *BlueGreenStatus `json:"blueGreen,omitempty"`
+
+	// DryRun reports the changes the last dry run would have applied
+	DryRun *DryRunStatus `json:"dryRun,omitempty"`
+}
+
+// DryRunStatus reports the changes a reconcile would have applied
+type DryRunStatus struct {
+	// Time of the dry run that first found the changes
+	Time    metav1.Time    `json:"time"`
+	Changes []DryRunChange `json:"changes,omitempty"`
+}
+
+// DryRunChange is a single write a reconcile would have made
+type DryRunChange struct {
+	APIVersion string `json:"apiVersion"`
+	Kind       string `json:"kind"`
+	Name       string `json:"name"`
+	// +kubebuilder:validation:Enum=create;update;delete
+	Action string `json:"action"`
+	// Diff is a json merge patch from the live to the desired object. Fields that would be removed are left out, so
+	// fields defaulted by the API server do not show up.
+	Diff string `json:"diff,omitempty"`
+}
+
+// BlueGreenStatus reports the revisions running in the blue and the green deployment
+type BlueGreenStatus struct {
+	// ActiveColor is the color of the deployment receiving the traffic of the service
+	// +kubebuilder:validation:Enum=blue;green
+	ActiveColor    Color `json:"activeColor"`
+	ActiveRevision int64 `json:"activeRevision"`
+	// PreviewRevision is the revision running in the inactive deployment until it is promoted, zero if none
+	PreviewRevision int64 `json:"previewRevision,omitempty"`
+	// PreviousRevision is the revision kept in the inactive deployment after a promotion, zero if none
+	PreviousRevision int64        `json:"previousRevision,omitempty"`
+	PromotedAt       *metav1.Time `json:"promotedAt,omitempty"`
+}
+
+// CanaryStatus reports the progress of a canary rollout
+type CanaryStatus struct {
+	// StableRevision is the revision receiving the traffic not sent to the canary
+	StableRevision int64 `json:"stableRevision"`
+	// CanaryRevision is the revision running as canary, zero if no canary rollout is in progress
+	CanaryRevision int64 `json:"canaryRevision,omitempty"`
+	// Step is the index of the current step
+	Step int32 `json:"step"`
+	// Weight is the share of traffic in percent the canary receives
+	Weight        int32        `json:"weight"`
+	StepStartedAt *metav1.Time `json:"stepStartedAt,omitempty"`
+	// StableTracked is true once the deployment of the service rolled out its pods with the stable track label, only
+	// then the service stops selecting the pods of the canary
+	StableTracked bool `json:"stableTracked,omitempty"`
+}
+
+// ConditionType is the type of a service condition
+type ConditionType string
+
+const (
+	// ConditionDegraded is true if the latest rollout failed
+	ConditionDegraded ConditionType = "Degraded"
+	// ConditionPreDeployFailed is true if the pre-deploy hook of the latest revision failed
+	ConditionPreDeployFailed ConditionType = "PreDeployFailed"
+	// ConditionAdoptionRefused is true if an existing object not owned by the service blocks the reconcile
+	ConditionAdoptionRefused ConditionType = "AdoptionRefused"
+	// ConditionRulesRejected is true if the service account rules grant more than the deployer config allows
+	ConditionRulesRejected ConditionType = "RulesRejected"
+)
+
+// ConditionList is a list of conditions with unique types
+type ConditionList []Condition
+
+// Condition describes the state of a service at a certain point
+type Condition struct {
+	Type               ConditionType          `json:"type"`
+	Status             corev1.ConditionStatus `json:"status"`
+	Reason             string                 `json:"reason,omitempty"`
+	Message            string                 `json:"message,omitempty"`
+	LastTransitionTime metav1.Time            `json:"lastTransitionTime"`
+}
+
+// RevisionList is a list of applied revisions ordered from oldest to newest
+type RevisionList []Revision
+
+// Revision records a spec of the service that was applied. The spec itself is stored in the revision config map of
+// the service.
+type Revision struct {
+	Revision  int64       `json:"revision"`
+	Image     string      `json:"image"`
+	Checksum  string      `json:"checksum"`
+	CreatedAt metav1.Time `json:"createdAt"`
+}
+
+// ImagePolicyStatus records the last check of the image policy and the last update of the image
+type ImagePolicyStatus struct {
+	LastCheckedAt metav1.Time  `json:"lastCheckedAt"`
+	LatestImage   string       `json:"latestImage,omitempty"`
+	Error         string       `json:"error,omitempty"`
+	LastUpdatedAt *metav1.Time `json:"lastUpdatedAt,omitempty"`
+	PreviousImage string       `json:"previousImage,omitempty"`
+}
+
+// ResolvedImage records the digest an image tag pointed to when it was deployed
+type ResolvedImage struct {
+	Image      string      `json:"image"`
+	Digest     string      `json:"digest"`
+	ResolvedAt metav1.Time `json:"resolvedAt"`
 }
 
 // ManagedObjectList is a list type for ManagedObject with utility functions
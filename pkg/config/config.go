@@ -1,5 +1,11 @@
 package config
 
+import (
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
 // NewConfig instantiates a new config instance with optional default values
 func NewConfig() *RootConfig {
 	return &RootConfig{
@@ -12,26 +18,144 @@ func NewConfig() *RootConfig {
 		Ingress: IngressConfig{
 			Annotations: map[string]string{},
 		},
-		DockerPullSecretes: []DockerPullSecret{},
+		DockerPullSecretes:    []DockerPullSecret{},
+		DockerPullSecretScope: DockerPullSecretScopeService,
+		RevisionHistoryLimit:  10,
+		SecurityProfile:       SecurityProfileHardened,
+		ServiceAccount: ServiceAccountConfig{
+			AllowedRules: []rbacv1.PolicyRule{
+				{
+					APIGroups: []string{""},
+					Resources: []string{"configmaps", "endpoints", "pods", "services"},
+					Verbs:     []string{"get", "list", "watch"},
+				},
+			},
+		},
 	}
 }
 
 // RootConfig configures the behavior of the operator
 type RootConfig struct {
-	CoreService        CoreServiceConfig  `json:"coreService"`
-	Deployment         DeploymentConfig   `json:"deployment"`
-	Ingress            IngressConfig      `json:"ingress"`
-	DockerPullSecretes []DockerPullSecret `json:"dockerPullSecretes"`
+	CoreService           CoreServiceConfig     `json:"coreService"`
+	Deployment            DeploymentConfig      `json:"deployment"`
+	Ingress               IngressConfig         `json:"ingress"`
+	DockerPullSecretes    []DockerPullSecret    `json:"dockerPullSecretes"`
+	DockerPullSecretScope DockerPullSecretScope `json:"dockerPullSecretScope"`
+	// DockerPullSecretMatching decides which services get a docker pull secret, defaults to registry
+	DockerPullSecretMatching DockerPullSecretMatching `json:"dockerPullSecretMatching,omitempty"`
+
+	// RevisionHistoryLimit is the number of applied specs kept per service for rollbacks
+	RevisionHistoryLimit int `json:"revisionHistoryLimit"`
+
+	// PlainHTTPRegistries lists registries whose API is accessed without TLS, e.g. when resolving image digests
+	PlainHTTPRegistries []string `json:"plainHTTPRegistries,omitempty"`
+
+	// NetworkPolicy configures the generated network policies
+	NetworkPolicy NetworkPolicyConfig `json:"networkPolicy,omitempty"`
+
+	// SecurityProfile is applied to the pods of all services which do not choose a profile themselves
+	SecurityProfile SecurityProfile `json:"securityProfile"`
+
+	// ServiceAccount limits the rules granted to the service accounts of services
+	ServiceAccount ServiceAccountConfig `json:"serviceAccount,omitempty"`
+
+	// Adoption decides which existing objects not owned by a service are taken over, defaults to annotated
+	Adoption AdoptionPolicy `json:"adoption,omitempty"`
 }
 
+// ServiceAccountConfig limits the rules services may grant to their service accounts
+type ServiceAccountConfig struct {
+	// AllowedRules is the upper bound of the rules of generated roles. Each permission granted by a service needs to be
+	// covered by one of them, the deployer itself needs to hold all of them.
+	AllowedRules []rbacv1.PolicyRule `json:"allowedRules,omitempty"`
+}
+
+// AdoptionPolicy defines how existing objects with the name of a generated object are handled if no service owns them.
+// Objects controlled by another owner are never taken over.
+type AdoptionPolicy string
+
+const (
+	// AdoptionRefuse never takes over existing objects, the service reports the conflict in its conditions
+	AdoptionRefuse AdoptionPolicy = "refuse"
+	// AdoptionAnnotated takes over existing objects annotated with apps.kubelix.io/adopt: "true" and refuses all others
+	AdoptionAnnotated AdoptionPolicy = "annotated"
+	// AdoptionAlways takes over all existing objects without owner
+	AdoptionAlways AdoptionPolicy = "always"
+)
+
+// SecurityProfile is a set of default security settings of pods
+type SecurityProfile string
+
+const (
+	// SecurityProfileHardened runs pods as non-root without privilege escalation, drops all capabilities and uses
+	// the seccomp profile of the container runtime
+	SecurityProfileHardened SecurityProfile = "hardened"
+	// SecurityProfileNone applies no default security settings
+	SecurityProfileNone SecurityProfile = "none"
+)
+
+// DockerPullSecretScope defines whether docker pull secrets are created per service or shared within a namespace
+type DockerPullSecretScope string
+
+const (
+	// DockerPullSecretScopeService creates a docker pull secret per service and registry
+	DockerPullSecretScopeService DockerPullSecretScope = "service"
+	// DockerPullSecretScopeNamespace creates a single docker pull secret per namespace and registry that is
+	// referenced by all services in the namespace
+	DockerPullSecretScopeNamespace DockerPullSecretScope = "namespace"
+)
+
+// DockerPullSecretMatching defines how docker pull secrets are matched with services
+type DockerPullSecretMatching string
+
+const (
+	// DockerPullSecretMatchingRegistry adds a docker pull secret to services whose image host equals its registry
+	DockerPullSecretMatchingRegistry DockerPullSecretMatching = "registry"
+	// DockerPullSecretMatchingAll adds all docker pull secrets to all services, as versions before registry matching did
+	DockerPullSecretMatchingAll DockerPullSecretMatching = "all"
+)
+
 // IngressConfig specifies additional information for ingress creation
 type IngressConfig struct {
 	Annotations map[string]string `json:"annotations"`
 }
 
-// DeploymentConfig specifies additional information for deployment creation
+// DeploymentConfig specifies additional information for deployment creation. The scheduling settings apply to the
+// pods of all services.
 type DeploymentConfig struct {
 	Annotations map[string]string `json:"annotations"`
+
+	NodeSelector              map[string]string                 `json:"nodeSelector,omitempty"`
+	Tolerations               []corev1.Toleration               `json:"tolerations,omitempty"`
+	TopologySpreadConstraints []corev1.TopologySpreadConstraint `json:"topologySpreadConstraints,omitempty"`
+	PriorityClassName         string                            `json:"priorityClassName,omitempty"`
+	// AntiAffinity is the default mode of the pod anti-affinity of services, defaults to preferred
+	AntiAffinity AntiAffinityMode `json:"antiAffinity,omitempty"`
+}
+
+// AntiAffinityMode defines how strictly pods of a service are spread across nodes
+type AntiAffinityMode string
+
+const (
+	// AntiAffinityPreferred schedules pods on different nodes if possible
+	AntiAffinityPreferred AntiAffinityMode = "preferred"
+	// AntiAffinityRequired never schedules two pods of a service on the same node
+	AntiAffinityRequired AntiAffinityMode = "required"
+	// AntiAffinityDisabled adds no anti-affinity
+	AntiAffinityDisabled AntiAffinityMode = "disabled"
+)
+
+// NetworkPolicyConfig specifies additional information for network policy creation
+type NetworkPolicyConfig struct {
+	// IngressController selects the pods of the ingress controller, which may connect to all ports exposed by ingresses
+	IngressController *NetworkPolicyPeer `json:"ingressController,omitempty"`
+}
+
+// NetworkPolicyPeer selects pods in the namespaces matching the namespace selector. Namespaces need to be labeled, as
+// they can not be selected by name.
+type NetworkPolicyPeer struct {
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector"`
+	PodSelector       *metav1.LabelSelector `json:"podSelector,omitempty"`
 }
 
 // CoreServiceConfig specifies additional information for core/v1 Service creation
@@ -39,9 +163,39 @@ type CoreServiceConfig struct {
 	Annotations map[string]string `json:"annotations"`
 }
 
-// DockerPullSecret defines secrets used to pull docker images from a registry
+// DockerPullSecret defines secrets used to pull docker images from a registry. Credentials are either given in
+// plain text with Username and Password, with the password read from a secret using PasswordSecretRef, copied
+// as a whole from an existing secret of type kubernetes.io/dockerconfigjson using SecretRef or fetched by running
+// an external credential plugin configured with Exec. Referenced secrets are read from the namespace the operator
+// runs in.
+// The secret is only added to services running an image from the registry and whose namespace is contained in
+// Namespaces and matches NamespaceSelector, if set.
 type DockerPullSecret struct {
-	Registry string `json:"registry"`
-	Username string `json:"username"`
-	Password string `json:"password"`
+	Registry          string                `json:"registry"`
+	Username          string                `json:"username"`
+	Password          string                `json:"password"`
+	PasswordSecretRef *SecretKeyRef         `json:"passwordSecretRef,omitempty"`
+	SecretRef         *SecretRef            `json:"secretRef,omitempty"`
+	Exec              *ExecCredentials      `json:"exec,omitempty"`
+	Namespaces        []string              `json:"namespaces,omitempty"`
+	NamespaceSelector *metav1.LabelSelector `json:"namespaceSelector,omitempty"`
+}
+
+// SecretKeyRef references a single key of a secret in the operator namespace
+type SecretKeyRef struct {
+	Name string `json:"name"`
+	Key  string `json:"key"`
+}
+
+// SecretRef references a whole secret in the operator namespace
+type SecretRef struct {
+	Name string `json:"name"`
+}
+
+// ExecCredentials configures a binary that prints short-lived registry credentials as json to stdout
+type ExecCredentials struct {
+	Command        string            `json:"command"`
+	Args           []string          `json:"args,omitempty"`
+	Env            map[string]string `json:"env,omitempty"`
+	TimeoutSeconds int               `json:"timeoutSeconds,omitempty"`
 }
@@ -7,7 +7,8 @@ import (
 )
 
 type envConfig struct {
-	ConfigFile string `split_words:"true" default:"config.yaml"`
+	ConfigFile        string `split_words:"true" default:"config.yaml"`
+	OperatorNamespace string `split_words:"true"`
 }
 
 // Env holds the environment config
@@ -16,6 +17,9 @@ var Env envConfig
 // Config file content
 var Config RootConfig
 
+// DryRun reports the changes of reconciles instead of applying them, set with the --dry-run flag
+var DryRun bool
+
 func Init() {
 	envconfig.MustProcess("", &Env)
 
@@ -24,5 +24,9 @@ func Load(path string) (*RootConfig, error) {
 		return nil, fmt.Errorf("failed to parse yaml config: %v", err)
 	}
 
+	if err := cfg.Validate(); err != nil {
+		return nil, fmt.Errorf("invalid config: %v", err)
+	}
+
 	return cfg, nil
 }
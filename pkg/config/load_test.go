@@ -39,5 +39,23 @@ var testConfig = &RootConfig{
 			Username: "test",
 			Password: "testpw",
 		},
+		{
+			Registry: "registry.example.com",
+			Username: "deploy",
+			PasswordSecretRef: &SecretKeyRef{
+				Name: "registry-credentials",
+				Key:  "password",
+			},
+		},
+		{
+			Registry: "quay.io",
+			SecretRef: &SecretRef{
+				Name: "quay-pull-secret",
+			},
+		},
 	},
+	DockerPullSecretScope: DockerPullSecretScopeService,
+	RevisionHistoryLimit:  10,
+	SecurityProfile:       SecurityProfileHardened,
+	ServiceAccount:        NewConfig().ServiceAccount,
 }
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"fmt"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+)
+
+// Validate checks the config for settings that contradict each other
+func (c *RootConfig) Validate() error {
+	switch c.DockerPullSecretScope {
+	case DockerPullSecretScopeService, DockerPullSecretScopeNamespace:
+	default:
+		return fmt.Errorf("unknown dockerPullSecretScope %q", c.DockerPullSecretScope)
+	}
+
+	switch c.DockerPullSecretMatching {
+	case "", DockerPullSecretMatchingRegistry, DockerPullSecretMatchingAll:
+	default:
+		return fmt.Errorf("unknown dockerPullSecretMatching %q", c.DockerPullSecretMatching)
+	}
+
+	switch c.SecurityProfile {
+	case SecurityProfileHardened, SecurityProfileNone:
+	default:
+		return fmt.Errorf("unknown securityProfile %q", c.SecurityProfile)
+	}
+
+	switch c.Deployment.AntiAffinity {
+	case "", AntiAffinityPreferred, AntiAffinityRequired, AntiAffinityDisabled:
+	default:
+		return fmt.Errorf("unknown deployment.antiAffinity %q", c.Deployment.AntiAffinity)
+	}
+
+	switch c.Adoption {
+	case "", AdoptionRefuse, AdoptionAnnotated, AdoptionAlways:
+	default:
+		return fmt.Errorf("unknown adoption %q", c.Adoption)
+	}
+
+	if ic := c.NetworkPolicy.IngressController; ic != nil && ic.NamespaceSelector == nil {
+		return fmt.Errorf("networkPolicy.ingressController.namespaceSelector must not be empty")
+	}
+
+	if c.RevisionHistoryLimit < 1 {
+		return fmt.Errorf("revisionHistoryLimit must be at least 1")
+	}
+
+	for _, reg := range c.DockerPullSecretes {
+		if err := reg.Validate(); err != nil {
+			return fmt.Errorf("docker pull secret for registry %q: %v", reg.Registry, err)
+		}
+	}
+
+	return nil
+}
+
+// Validate checks that exactly one source of credentials is configured
+func (d DockerPullSecret) Validate() error {
+	if d.Registry == "" {
+		return fmt.Errorf("registry must not be empty")
+	}
+
+	if d.NamespaceSelector != nil {
+		if _, err := metav1.LabelSelectorAsSelector(d.NamespaceSelector); err != nil {
+			return fmt.Errorf("invalid namespaceSelector: %v", err)
+		}
+	}
+
+	if d.Exec != nil {
+		if d.Password != "" || d.PasswordSecretRef != nil || d.SecretRef != nil {
+			return fmt.Errorf("exec can not be combined with password, passwordSecretRef or secretRef")
+		}
+		if d.Exec.Command == "" {
+			return fmt.Errorf("exec.command must not be empty")
+		}
+		return nil
+	}
+
+	if d.SecretRef != nil {
+		if d.Username != "" || d.Password != "" || d.PasswordSecretRef != nil {
+			return fmt.Errorf("secretRef can not be combined with username, password or passwordSecretRef")
+		}
+		if d.SecretRef.Name == "" {
+			return fmt.Errorf("secretRef.name must not be empty")
+		}
+		return nil
+	}
+
+	if d.PasswordSecretRef != nil {
+		if d.Password != "" {
+			return fmt.Errorf("password and passwordSecretRef can not be combined")
+		}
+		if d.PasswordSecretRef.Name == "" || d.PasswordSecretRef.Key == "" {
+			return fmt.Errorf("passwordSecretRef needs both name and key")
+		}
+	}
+
+	return nil
+}
+
+// ReferencesSecret returns true when the credentials are read from the secret with the given name
+func (d DockerPullSecret) ReferencesSecret(name string) bool {
+	return (d.SecretRef != nil && d.SecretRef.Name == name) ||
+		(d.PasswordSecretRef != nil && d.PasswordSecretRef.Name == name)
+}
+
+// AllowsNamespace returns true when the credentials may be used in the namespace with the given name and labels
+func (d DockerPullSecret) AllowsNamespace(name string, namespaceLabels map[string]string) bool {
+	if len(d.Namespaces) > 0 && !containsString(d.Namespaces, name) {
+		return false
+	}
+
+	if d.NamespaceSelector == nil {
+		return true
+	}
+
+	selector, err := metav1.LabelSelectorAsSelector(d.NamespaceSelector)
+	if err != nil {
+		return false
+	}
+
+	return selector.Matches(labels.Set(namespaceLabels))
+}
+
+func containsString(list []string, value string) bool {
+	for _, item := range list {
+		if item == value {
+			return true
+		}
+	}
+
+	return false
+}
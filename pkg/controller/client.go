@@ -0,0 +1,54 @@
+package controller
+
+import (
+	"context"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+)
+
+// NewClient creates the client of the manager. It reads from the cache like the default client, except for secrets
+// which are read from the API server, so the operator does not cache the secrets of the whole cluster. Pods and replica
+// sets are only listed while a workload is recreated, so they are listed from the API server as well instead of
+// caching them for the whole cluster.
+func NewClient(cache cache.Cache, config *rest.Config, options client.Options) (client.Client, error) {
+	c, err := client.New(config, options)
+	if err != nil {
+		return nil, err
+	}
+
+	return &client.DelegatingClient{
+		Reader: &uncachedSecretsReader{
+			cached: &client.DelegatingReader{CacheReader: cache, ClientReader: c},
+			direct: c,
+		},
+		Writer:       c,
+		StatusClient: c,
+	}, nil
+}
+
+type uncachedSecretsReader struct {
+	cached client.Reader
+	direct client.Reader
+}
+
+func (r *uncachedSecretsReader) Get(ctx context.Context, key client.ObjectKey, obj runtime.Object) error {
+	if _, ok := obj.(*corev1.Secret); ok {
+		return r.direct.Get(ctx, key, obj)
+	}
+
+	return r.cached.Get(ctx, key, obj)
+}
+
+func (r *uncachedSecretsReader) List(ctx context.Context, list runtime.Object, opts ...client.ListOption) error {
+	switch list.(type) {
+	case *corev1.SecretList, *corev1.PodList, *appsv1.ReplicaSetList:
+		return r.direct.List(ctx, list, opts...)
+	}
+
+	return r.cached.List(ctx, list, opts...)
+}
@@ -0,0 +1,92 @@
+package imagepolicy
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+// policy selects the newest tag out of a list of tags
+type policy struct {
+	pattern      *regexp.Regexp
+	versionRange versionRange
+}
+
+func newPolicy(spec *appsv1alpha1.ImagePolicy) (*policy, error) {
+	p := &policy{}
+
+	if spec.Pattern != "" {
+		pattern, err := regexp.Compile(spec.Pattern)
+		if err != nil {
+			return nil, fmt.Errorf("invalid pattern %q: %v", spec.Pattern, err)
+		}
+		p.pattern = pattern
+	}
+
+	if spec.Semver != "" {
+		versionRange, err := parseRange(spec.Semver)
+		if err != nil {
+			return nil, fmt.Errorf("invalid semver range %q: %v", spec.Semver, err)
+		}
+		p.versionRange = versionRange
+	}
+
+	if p.pattern == nil && p.versionRange == nil {
+		return nil, fmt.Errorf("image policy needs a semver range or a pattern")
+	}
+
+	return p, nil
+}
+
+// latest returns the newest tag matching the policy or an empty string if none matches
+func (p *policy) latest(tags []string) string {
+	candidates := make([]string, 0)
+	versions := make(map[string]version)
+
+	for _, tag := range tags {
+		if p.pattern != nil && !p.pattern.MatchString(tag) {
+			continue
+		}
+
+		if p.versionRange != nil {
+			v, err := parseVersion(tag)
+			if err != nil || !p.versionRange.matches(v) {
+				continue
+			}
+			versions[tag] = v
+		}
+
+		candidates = append(candidates, tag)
+	}
+
+	if len(candidates) == 0 {
+		return ""
+	}
+
+	sort.SliceStable(candidates, func(i, j int) bool {
+		if p.versionRange != nil {
+			if cmp := versions[candidates[i]].compare(versions[candidates[j]]); cmp != 0 {
+				return cmp < 0
+			}
+		}
+		return candidates[i] < candidates[j]
+	})
+
+	return candidates[len(candidates)-1]
+}
+
+// newer returns true when the candidate tag is ordered after the current one
+func (p *policy) newer(candidate, current string) bool {
+	if candidate == current {
+		return false
+	}
+
+	if p.latest([]string{current}) == "" {
+		// the current tag does not match the policy at all, so any matching tag is an improvement
+		return true
+	}
+
+	return p.latest([]string{current, candidate}) == candidate
+}
@@ -0,0 +1,59 @@
+package imagepolicy
+
+import (
+	"testing"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestPolicy_latest(t *testing.T) {
+	tags := []string{"latest", "1.0.0", "1.2.0", "1.10.0", "2.0.0-rc.1", "2.0.0", "main-20200101", "main-20200305"}
+
+	tests := []struct {
+		name string
+		spec appsv1alpha1.ImagePolicy
+		want string
+	}{
+		{name: "semver_all", spec: appsv1alpha1.ImagePolicy{Semver: "*"}, want: "2.0.0"},
+		{name: "semver_range", spec: appsv1alpha1.ImagePolicy{Semver: "^1.0"}, want: "1.10.0"},
+		{name: "semver_no_match", spec: appsv1alpha1.ImagePolicy{Semver: "^3.0"}, want: ""},
+		{name: "pattern", spec: appsv1alpha1.ImagePolicy{Pattern: "^main-"}, want: "main-20200305"},
+		{name: "pattern_and_semver", spec: appsv1alpha1.ImagePolicy{Pattern: `^1\.[0-2]\.`, Semver: "*"}, want: "1.2.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, err := newPolicy(&tt.spec)
+			if err != nil {
+				t.Fatalf("newPolicy() error = %v", err)
+			}
+			if got := p.latest(tags); got != tt.want {
+				t.Errorf("latest() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPolicy_newer(t *testing.T) {
+	p, err := newPolicy(&appsv1alpha1.ImagePolicy{Semver: "^1.0"})
+	if err != nil {
+		t.Fatalf("newPolicy() error = %v", err)
+	}
+
+	if !p.newer("1.2.0", "1.1.0") {
+		t.Error("expected 1.2.0 to be newer than 1.1.0")
+	}
+	if p.newer("1.1.0", "1.2.0") {
+		t.Error("expected 1.1.0 not to be newer than 1.2.0")
+	}
+	if !p.newer("1.0.0", "latest") {
+		t.Error("expected any matching tag to be newer than a tag not matching the policy")
+	}
+}
+
+func TestNewPolicy_invalid(t *testing.T) {
+	for _, spec := range []appsv1alpha1.ImagePolicy{{}, {Pattern: "("}, {Semver: ">=a.b"}} {
+		if _, err := newPolicy(&spec); err == nil {
+			t.Errorf("newPolicy(%#v) expected error", spec)
+		}
+	}
+}
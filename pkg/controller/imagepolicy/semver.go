@@ -0,0 +1,278 @@
+package imagepolicy
+
+import (
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+var versionPattern = regexp.MustCompile(`^v?(\d+)(?:\.(\d+))?(?:\.(\d+))?(?:-([0-9A-Za-z.-]+))?(?:\+[0-9A-Za-z.-]+)?$`)
+
+// version is a parsed semantic version; build metadata is ignored
+type version struct {
+	major, minor, patch int64
+	prerelease          string
+}
+
+func parseVersion(value string) (version, error) {
+	m := versionPattern.FindStringSubmatch(value)
+	if m == nil {
+		return version{}, fmt.Errorf("%q is not a semantic version", value)
+	}
+
+	v := version{prerelease: m[4]}
+	parts := []*int64{&v.major, &v.minor, &v.patch}
+	for i, part := range m[1:4] {
+		if part == "" {
+			continue
+		}
+
+		n, err := strconv.ParseInt(part, 10, 64)
+		if err != nil {
+			return version{}, fmt.Errorf("%q is not a semantic version: %v", value, err)
+		}
+		*parts[i] = n
+	}
+
+	return v, nil
+}
+
+// compare returns -1, 0 or 1 if v is lower, equal or greater than o
+func (v version) compare(o version) int {
+	for _, pair := range [][2]int64{{v.major, o.major}, {v.minor, o.minor}, {v.patch, o.patch}} {
+		if pair[0] != pair[1] {
+			if pair[0] < pair[1] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	return comparePrerelease(v.prerelease, o.prerelease)
+}
+
+// comparePrerelease orders pre-releases below releases and compares their identifiers one by one
+func comparePrerelease(a, b string) int {
+	switch {
+	case a == b:
+		return 0
+	case a == "":
+		return 1
+	case b == "":
+		return -1
+	}
+
+	as, bs := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		an, aErr := strconv.ParseInt(as[i], 10, 64)
+		bn, bErr := strconv.ParseInt(bs[i], 10, 64)
+
+		switch {
+		case aErr == nil && bErr == nil:
+			if an != bn {
+				if an < bn {
+					return -1
+				}
+				return 1
+			}
+		case aErr == nil:
+			return -1
+		case bErr == nil:
+			return 1
+		case as[i] != bs[i]:
+			if as[i] < bs[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	switch {
+	case len(as) < len(bs):
+		return -1
+	case len(as) > len(bs):
+		return 1
+	}
+	return 0
+}
+
+// next returns the lowest version above all versions a partial version with the given number of parts stands for,
+// e.g. 1.3.0 for 1.2
+func (v version) next(given int) version {
+	if given == 1 {
+		return version{major: v.major + 1}
+	}
+
+	return version{major: v.major, minor: v.minor + 1}
+}
+
+// constraint is a single comparison like ">=1.2.0". A "!=" constraint with an upper version excludes all versions from
+// version up to upper, as "!=1.2" does.
+type constraint struct {
+	operator string
+	version  version
+	upper    *version
+}
+
+func (c constraint) matches(v version) bool {
+	cmp := v.compare(c.version)
+
+	if c.operator == "!=" && c.upper != nil {
+		return cmp < 0 || v.compare(*c.upper) >= 0
+	}
+
+	switch c.operator {
+	case ">":
+		return cmp > 0
+	case ">=":
+		return cmp >= 0
+	case "<":
+		return cmp < 0
+	case "<=":
+		return cmp <= 0
+	case "!=":
+		return cmp != 0
+	default:
+		return cmp == 0
+	}
+}
+
+// versionRange is a list of alternatives, each of them a list of constraints that all need to match
+type versionRange [][]constraint
+
+var operatorPattern = regexp.MustCompile(`^(>=|<=|!=|>|<|=|\^|~)?\s*(.*)$`)
+
+// parseRange parses ranges like ">=1.2.0 <2.0.0 || ^3.1", "~1.2" or "1.x"
+func parseRange(value string) (versionRange, error) {
+	result := make(versionRange, 0)
+
+	for _, alternative := range strings.Split(value, "||") {
+		constraints := make([]constraint, 0)
+
+		for _, field := range strings.Fields(alternative) {
+			expanded, err := parseConstraint(field)
+			if err != nil {
+				return nil, err
+			}
+			constraints = append(constraints, expanded...)
+		}
+
+		if len(constraints) == 0 {
+			return nil, fmt.Errorf("range %q contains an empty alternative", value)
+		}
+
+		result = append(result, constraints)
+	}
+
+	return result, nil
+}
+
+// parseConstraint expands caret, tilde and wildcard constraints into simple comparisons
+func parseConstraint(value string) ([]constraint, error) {
+	m := operatorPattern.FindStringSubmatch(value)
+	operator, raw := m[1], m[2]
+
+	if raw == "*" || raw == "x" || raw == "X" {
+		return []constraint{{operator: ">=", version: version{}}}, nil
+	}
+
+	// count the given parts to find out which of them are wildcards, e.g. "1.2" or "1.2.x"
+	core := strings.TrimPrefix(raw, "v")
+	if i := strings.IndexAny(core, "-+"); i != -1 {
+		core = core[:i]
+	}
+
+	parts := strings.Split(core, ".")
+	given := 0
+	for _, part := range parts {
+		if part == "x" || part == "X" || part == "*" {
+			break
+		}
+		given++
+	}
+	if given == 0 || given > 3 {
+		return nil, fmt.Errorf("invalid constraint %q", value)
+	}
+
+	versionString := strings.Join(parts[:given], ".")
+	if given == 3 {
+		versionString = raw
+	}
+
+	v, err := parseVersion(versionString)
+	if err != nil {
+		return nil, fmt.Errorf("invalid constraint %q: %v", value, err)
+	}
+
+	// partial versions stand for all versions they are a prefix of, e.g. "<=1.2" includes 1.2.1
+	if given < 3 {
+		upper := v.next(given)
+
+		switch operator {
+		case ">":
+			return []constraint{{operator: ">=", version: upper}}, nil
+		case "<=":
+			return []constraint{{operator: "<", version: upper}}, nil
+		case "!=":
+			return []constraint{{operator: operator, version: v, upper: &upper}}, nil
+		case ">=", "<":
+			return []constraint{{operator: operator, version: v}}, nil
+		}
+	}
+
+	switch {
+	case operator == "^":
+		upper := version{major: v.major + 1}
+		if v.major == 0 && given > 1 {
+			upper = version{minor: v.minor + 1}
+			if v.minor == 0 && given > 2 {
+				upper = version{patch: v.patch + 1}
+			}
+		}
+		return []constraint{{operator: ">=", version: v}, {operator: "<", version: upper}}, nil
+
+	case operator == "~" || given < 3:
+		upper := v.next(given)
+		if given == 3 {
+			upper = v.next(2)
+		}
+		return []constraint{{operator: ">=", version: v}, {operator: "<", version: upper}}, nil
+	}
+
+	return []constraint{{operator: operator, version: v}}, nil
+}
+
+// matches returns true when the version satisfies any alternative of the range. Pre-releases only match if the
+// range explicitly mentions a pre-release of the same version.
+func (r versionRange) matches(v version) bool {
+	for _, constraints := range r {
+		if v.prerelease != "" && !mentionsPrerelease(constraints, v) {
+			continue
+		}
+
+		matches := true
+		for _, c := range constraints {
+			if !c.matches(v) {
+				matches = false
+				break
+			}
+		}
+
+		if matches {
+			return true
+		}
+	}
+
+	return false
+}
+
+func mentionsPrerelease(constraints []constraint, v version) bool {
+	for _, c := range constraints {
+		if c.version.prerelease != "" && c.version.major == v.major && c.version.minor == v.minor && c.version.patch == v.patch {
+			return true
+		}
+	}
+
+	return false
+}
@@ -0,0 +1,107 @@
+package imagepolicy
+
+import "testing"
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		give    string
+		want    version
+		wantErr bool
+	}{
+		{give: "1.2.3", want: version{major: 1, minor: 2, patch: 3}},
+		{give: "v1.2.3", want: version{major: 1, minor: 2, patch: 3}},
+		{give: "1.2", want: version{major: 1, minor: 2}},
+		{give: "1.2.3-rc.1+build.5", want: version{major: 1, minor: 2, patch: 3, prerelease: "rc.1"}},
+		{give: "latest", wantErr: true},
+		{give: "1.2.3.4", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.give, func(t *testing.T) {
+			got, err := parseVersion(tt.give)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("parseVersion() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("parseVersion() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersion_compare(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{a: "1.2.3", b: "1.2.3", want: 0},
+		{a: "1.2.3", b: "1.2.4", want: -1},
+		{a: "1.10.0", b: "1.9.0", want: 1},
+		{a: "1.0.0-rc.1", b: "1.0.0", want: -1},
+		{a: "1.0.0-rc.2", b: "1.0.0-rc.10", want: -1},
+		{a: "1.0.0-alpha", b: "1.0.0-beta", want: -1},
+		{a: "1.0.0-alpha.1", b: "1.0.0-alpha", want: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.a+"_"+tt.b, func(t *testing.T) {
+			a, _ := parseVersion(tt.a)
+			b, _ := parseVersion(tt.b)
+			if got := a.compare(b); got != tt.want {
+				t.Errorf("compare() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestVersionRange_matches(t *testing.T) {
+	tests := []struct {
+		rng  string
+		give string
+		want bool
+	}{
+		{rng: ">=1.2.0 <2.0.0", give: "1.5.0", want: true},
+		{rng: ">=1.2.0 <2.0.0", give: "2.0.0", want: false},
+		{rng: ">=1.2.0 <2.0.0", give: "1.1.9", want: false},
+		{rng: "^1.2", give: "1.9.3", want: true},
+		{rng: "^1.2", give: "2.0.0", want: false},
+		{rng: "^0.2.3", give: "0.2.9", want: true},
+		{rng: "^0.2.3", give: "0.3.0", want: false},
+		{rng: "~1.2.3", give: "1.2.9", want: true},
+		{rng: "~1.2.3", give: "1.3.0", want: false},
+		{rng: "1.x", give: "1.7.0", want: true},
+		{rng: "1.x", give: "2.0.0", want: false},
+		{rng: "1.2", give: "1.2.5", want: true},
+		{rng: "*", give: "3.0.0", want: true},
+		{rng: "^1.0 || ^3.0", give: "3.1.0", want: true},
+		{rng: "^1.0 || ^3.0", give: "2.1.0", want: false},
+		{rng: "^1.0", give: "1.1.0-rc.1", want: false},
+		{rng: ">=1.1.0-rc.0", give: "1.1.0-rc.1", want: true},
+		{rng: "<=1.2", give: "1.2.1", want: true},
+		{rng: "<=1.2", give: "1.3.0", want: false},
+		{rng: "<=1", give: "1.9.9", want: true},
+		{rng: ">1.2", give: "1.2.5", want: false},
+		{rng: ">1.2", give: "1.3.0", want: true},
+		{rng: ">=1.2", give: "1.2.0", want: true},
+		{rng: "<1.2", give: "1.2.0", want: false},
+		{rng: "<1.2", give: "1.1.9", want: true},
+		{rng: "!=1.2", give: "1.2.3", want: false},
+		{rng: "!=1.2", give: "1.3.0", want: true},
+		{rng: "!=1.2", give: "1.1.0", want: true},
+		{rng: "<=1.2.x", give: "1.2.7", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.rng+"_"+tt.give, func(t *testing.T) {
+			r, err := parseRange(tt.rng)
+			if err != nil {
+				t.Fatalf("parseRange() error = %v", err)
+			}
+			v, err := parseVersion(tt.give)
+			if err != nil {
+				t.Fatalf("parseVersion() error = %v", err)
+			}
+			if got := r.matches(v); got != tt.want {
+				t.Errorf("matches() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,225 @@
+package imagepolicy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	logf "sigs.k8s.io/controller-runtime/pkg/log"
+	"sigs.k8s.io/controller-runtime/pkg/manager"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/registry"
+)
+
+var log = logf.Log.WithName("image_policy")
+
+const (
+	// defaultInterval between two checks of a policy without configured interval
+	defaultInterval = 5 * time.Minute
+	// tickInterval at which all services are checked for policies that are due
+	tickInterval = 30 * time.Second
+)
+
+// Add creates a new image policy updater and adds it to the Manager. It periodically checks the registries of all
+// services with an image policy for newer tags and updates the image of the service.
+func Add(mgr manager.Manager) error {
+	// updating the image of a service is a write of its own, which a dry run must not do
+	if config.DryRun {
+		log.Info("Dry run, image policies are not applied")
+		return nil
+	}
+
+	return mgr.Add(&updater{
+		client:   mgr.GetClient(),
+		registry: registry.NewClient(registry.Options{PlainHTTP: config.Config.PlainHTTPRegistries}),
+		recorder: mgr.GetEventRecorderFor("image-policy"),
+		now:      time.Now,
+	})
+}
+
+// blank assignment to verify that updater implements manager.Runnable
+var _ manager.Runnable = &updater{}
+
+type updater struct {
+	client   client.Client
+	registry registry.Client
+	recorder record.EventRecorder
+	now      func() time.Time
+}
+
+// Start checks the image policies until the stop channel is closed
+func (u *updater) Start(stop <-chan struct{}) error {
+	ticker := time.NewTicker(tickInterval)
+	defer ticker.Stop()
+
+	for {
+		u.checkAll()
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+func (u *updater) checkAll() {
+	services := &appsv1alpha1.ServiceList{}
+	if err := u.client.List(context.TODO(), services); err != nil {
+		log.Error(err, "failed to list services")
+		return
+	}
+
+	for i := range services.Items {
+		svc := &services.Items[i]
+		if svc.Spec.ImagePolicy == nil || paused(svc) || dryRun(svc) || !u.due(svc) {
+			continue
+		}
+
+		reqLogger := log.WithValues("Request.Namespace", svc.Namespace, "Request.Name", svc.Name)
+		if err := u.check(reqLogger, svc); err != nil {
+			reqLogger.Error(err, "failed to check image policy")
+		}
+	}
+}
+
+// paused returns true when the service was rolled back, so the policy must not replace the restored image, or when
+// its latest rollout failed, so a broken image is not updated until the rollout is resolved
+func paused(svc *appsv1alpha1.Service) bool {
+	if _, ok := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; ok {
+		return true
+	}
+
+	return svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionDegraded)
+}
+
+// dryRun returns true when the changes of the service are only reported, so neither its image nor its status are
+// written
+func dryRun(svc *appsv1alpha1.Service) bool {
+	return config.DryRun || svc.Annotations[appsv1alpha1.DryRunAnnotation] == "true"
+}
+
+// due returns true when the interval of the policy has passed since the last check
+func (u *updater) due(svc *appsv1alpha1.Service) bool {
+	if svc.Status.ImagePolicy == nil {
+		return true
+	}
+
+	interval := defaultInterval
+	if svc.Spec.ImagePolicy.Interval != nil {
+		interval = svc.Spec.ImagePolicy.Interval.Duration
+	}
+
+	return !u.now().Before(svc.Status.ImagePolicy.LastCheckedAt.Add(interval))
+}
+
+// check looks up the newest image matching the policy and updates the service if it is newer than the current one
+func (u *updater) check(reqLogger logr.Logger, svc *appsv1alpha1.Service) error {
+	status := &appsv1alpha1.ImagePolicyStatus{}
+	if svc.Status.ImagePolicy != nil {
+		status = svc.Status.ImagePolicy.DeepCopy()
+	}
+	status.LastCheckedAt = metav1.NewTime(u.now())
+
+	latest, newer, err := u.latestImage(svc)
+	if err != nil {
+		if status.Error != err.Error() {
+			u.recorder.Event(svc, corev1.EventTypeWarning, "ImagePolicyFailed", err.Error())
+		}
+		status.Error = err.Error()
+	} else {
+		status.Error = ""
+		status.LatestImage = latest
+	}
+
+	if err == nil && newer {
+		previous := svc.Spec.Image
+		svc.Spec.Image = latest
+
+		if err := u.client.Update(context.TODO(), svc); err != nil {
+			return fmt.Errorf("failed to update image to %s: %v", latest, err)
+		}
+
+		reqLogger.Info("Updated image", "Image.Previous", previous, "Image.Latest", latest)
+		u.recorder.Eventf(svc, corev1.EventTypeNormal, "ImageUpdated", "Updated image from %s to %s", previous, latest)
+
+		updatedAt := metav1.NewTime(u.now())
+		status.LastUpdatedAt = &updatedAt
+		status.PreviousImage = previous
+	}
+
+	svc.Status.ImagePolicy = status
+	if err := u.client.Status().Update(context.TODO(), svc); err != nil {
+		return fmt.Errorf("failed to update status: %v", err)
+	}
+
+	return nil
+}
+
+// latestImage returns the image with the newest tag matching the policy and whether it is newer than the current one
+func (u *updater) latestImage(svc *appsv1alpha1.Service) (string, bool, error) {
+	policy, err := newPolicy(svc.Spec.ImagePolicy)
+	if err != nil {
+		return "", false, err
+	}
+
+	ref, err := registry.ParseReference(svc.Spec.Image)
+	if err != nil {
+		return "", false, err
+	}
+
+	auth, err := u.registryAuth(svc, ref)
+	if err != nil {
+		return "", false, err
+	}
+
+	tags, err := u.registry.Tags(context.TODO(), ref, auth)
+	if err != nil {
+		return "", false, fmt.Errorf("failed to list tags of %s: %v", ref.Name(), err)
+	}
+
+	tag := policy.latest(tags)
+	if tag == "" {
+		return "", false, fmt.Errorf("no tag of %s matches the image policy", ref.Name())
+	}
+
+	return registry.ReplaceTag(svc.Spec.Image, tag), policy.newer(tag, ref.Tag), nil
+}
+
+// registryAuth reads the credentials for the registry from the docker pull secrets managed for the service
+func (u *updater) registryAuth(svc *appsv1alpha1.Service, ref registry.Reference) (registry.Auth, error) {
+	for _, managedObject := range svc.Status.ManagedObjects {
+		if managedObject.Reference.Kind != "Secret" || managedObject.Reference.APIVersion != corev1.SchemeGroupVersion.String() {
+			continue
+		}
+
+		secret := &corev1.Secret{}
+		name := types.NamespacedName{Namespace: managedObject.Reference.Namespace, Name: managedObject.Reference.Name}
+		if err := u.client.Get(context.TODO(), name, secret); err != nil {
+			return registry.Auth{}, fmt.Errorf("failed to get secret %s: %v", name, err)
+		}
+
+		if secret.Type != corev1.SecretTypeDockerConfigJson {
+			continue
+		}
+
+		auth, err := registry.AuthFromDockerConfig(secret.Data[corev1.DockerConfigJsonKey], ref.Host)
+		if err != nil {
+			return registry.Auth{}, fmt.Errorf("secret %s: %v", name, err)
+		}
+
+		if auth != (registry.Auth{}) {
+			return auth, nil
+		}
+	}
+
+	return registry.Auth{}, nil
+}
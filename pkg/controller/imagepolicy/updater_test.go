@@ -0,0 +1,218 @@
+package imagepolicy
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	clientgoscheme "k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/registry"
+)
+
+type fakeRegistry struct {
+	tags []string
+	auth registry.Auth
+}
+
+func (f *fakeRegistry) Digest(ctx context.Context, ref registry.Reference, auth registry.Auth) (string, error) {
+	return "", nil
+}
+
+func (f *fakeRegistry) Tags(ctx context.Context, ref registry.Reference, auth registry.Auth) ([]string, error) {
+	f.auth = auth
+	return f.tags, nil
+}
+
+func TestUpdater_checkAll(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(scheme)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image:       "registry.example.com/app:1.0.0",
+			ImagePolicy: &appsv1alpha1.ImagePolicy{Semver: "^1.0"},
+		},
+		Status: appsv1alpha1.ServiceStatus{
+			ManagedObjects: appsv1alpha1.ManagedObjectList{
+				{Reference: corev1.ObjectReference{APIVersion: "v1", Kind: "Secret", Namespace: "team", Name: "app-docker-pull"}},
+			},
+		},
+	}
+	secret := &corev1.Secret{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-docker-pull", Namespace: "team"},
+		Type:       corev1.SecretTypeDockerConfigJson,
+		Data: map[string][]byte{
+			corev1.DockerConfigJsonKey: []byte(`{"auths": {"registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}}}`),
+		},
+	}
+
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	reg := &fakeRegistry{tags: []string{"1.0.0", "1.1.0", "2.0.0"}}
+	recorder := record.NewFakeRecorder(10)
+	u := &updater{
+		client:   fake.NewFakeClientWithScheme(scheme, svc, secret),
+		registry: reg,
+		recorder: recorder,
+		now:      func() time.Time { return now },
+	}
+
+	u.checkAll()
+
+	got := &appsv1alpha1.Service{}
+	if err := u.client.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "app"}, got); err != nil {
+		t.Fatalf("failed to get service: %v", err)
+	}
+
+	if want := "registry.example.com/app:1.1.0"; got.Spec.Image != want {
+		t.Errorf("expected image %s, got %s", want, got.Spec.Image)
+	}
+	if reg.auth.Username != "user" || reg.auth.Password != "password" {
+		t.Errorf("expected credentials of the docker pull secret, got %v", reg.auth)
+	}
+	if got.Status.ImagePolicy == nil || got.Status.ImagePolicy.PreviousImage != "registry.example.com/app:1.0.0" {
+		t.Errorf("expected update to be recorded in status, got %#v", got.Status.ImagePolicy)
+	}
+	if len(recorder.Events) != 1 {
+		t.Errorf("expected a single event, got %d", len(recorder.Events))
+	}
+
+	// the next check is only due after the interval passed
+	reg.tags = append(reg.tags, "1.2.0")
+	u.checkAll()
+	if err := u.client.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "app"}, got); err != nil {
+		t.Fatalf("failed to get service: %v", err)
+	}
+	if want := "registry.example.com/app:1.1.0"; got.Spec.Image != want {
+		t.Errorf("expected image %s before interval passed, got %s", want, got.Spec.Image)
+	}
+
+	now = now.Add(defaultInterval)
+	u.checkAll()
+	if err := u.client.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "app"}, got); err != nil {
+		t.Fatalf("failed to get service: %v", err)
+	}
+	if want := "registry.example.com/app:1.2.0"; got.Spec.Image != want {
+		t.Errorf("expected image %s after interval passed, got %s", want, got.Spec.Image)
+	}
+}
+
+func TestUpdater_checkAll_paused(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(scheme)
+
+	degraded := appsv1alpha1.Condition{Type: appsv1alpha1.ConditionDegraded, Status: corev1.ConditionTrue, Reason: "ProgressDeadlineExceeded"}
+	// an automatic rollback restores the spec, pauses the policy and marks the service as degraded until the restored
+	// revision is rolled out again
+	rolledBack := appsv1alpha1.Condition{Type: appsv1alpha1.ConditionDegraded, Status: corev1.ConditionTrue, Reason: "RolledBack"}
+	recovered := appsv1alpha1.Condition{Type: appsv1alpha1.ConditionDegraded, Status: corev1.ConditionFalse, Reason: "RolloutComplete"}
+
+	tests := []struct {
+		name       string
+		paused     bool
+		conditions appsv1alpha1.ConditionList
+		wantImage  string
+	}{
+		{name: "rolled_back", paused: true, conditions: appsv1alpha1.ConditionList{rolledBack}, wantImage: "registry.example.com/app:1.0.0"},
+		{name: "rolled_back_and_recovered", paused: true, conditions: appsv1alpha1.ConditionList{recovered}, wantImage: "registry.example.com/app:1.0.0"},
+		{name: "degraded", conditions: appsv1alpha1.ConditionList{degraded}, wantImage: "registry.example.com/app:1.0.0"},
+		{name: "resumed", conditions: appsv1alpha1.ConditionList{recovered}, wantImage: "registry.example.com/app:1.1.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team"},
+				Spec: appsv1alpha1.ServiceSpec{
+					Image:       "registry.example.com/app:1.0.0",
+					ImagePolicy: &appsv1alpha1.ImagePolicy{Semver: "^1.0"},
+				},
+				Status: appsv1alpha1.ServiceStatus{Conditions: tt.conditions},
+			}
+			if tt.paused {
+				svc.Annotations = map[string]string{appsv1alpha1.ImagePolicyPausedAnnotation: "checksum"}
+			}
+
+			u := &updater{
+				client:   fake.NewFakeClientWithScheme(scheme, svc),
+				registry: &fakeRegistry{tags: []string{"1.0.0", "1.1.0"}},
+				recorder: record.NewFakeRecorder(10),
+				now:      time.Now,
+			}
+
+			u.checkAll()
+
+			got := &appsv1alpha1.Service{}
+			if err := u.client.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "app"}, got); err != nil {
+				t.Fatalf("failed to get service: %v", err)
+			}
+			if got.Spec.Image != tt.wantImage {
+				t.Errorf("image = %s, want %s", got.Spec.Image, tt.wantImage)
+			}
+		})
+	}
+}
+
+func TestUpdater_checkAll_dryRun(t *testing.T) {
+	scheme := runtime.NewScheme()
+	_ = clientgoscheme.AddToScheme(scheme)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(scheme)
+
+	defer func(dryRun bool) { config.DryRun = dryRun }(config.DryRun)
+
+	tests := []struct {
+		name        string
+		flag        bool
+		annotations map[string]string
+	}{
+		{name: "flag", flag: true},
+		{name: "annotation", annotations: map[string]string{appsv1alpha1.DryRunAnnotation: "true"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.DryRun = tt.flag
+
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "team", Annotations: tt.annotations},
+				Spec: appsv1alpha1.ServiceSpec{
+					Image:       "registry.example.com/app:1.0.0",
+					ImagePolicy: &appsv1alpha1.ImagePolicy{Semver: "^1.0"},
+				},
+			}
+
+			recorder := record.NewFakeRecorder(10)
+			u := &updater{
+				client:   fake.NewFakeClientWithScheme(scheme, svc),
+				registry: &fakeRegistry{tags: []string{"1.0.0", "1.1.0"}},
+				recorder: recorder,
+				now:      time.Now,
+			}
+
+			u.checkAll()
+
+			got := &appsv1alpha1.Service{}
+			if err := u.client.Get(context.TODO(), types.NamespacedName{Namespace: "team", Name: "app"}, got); err != nil {
+				t.Fatalf("failed to get service: %v", err)
+			}
+			if want := "registry.example.com/app:1.0.0"; got.Spec.Image != want {
+				t.Errorf("image = %s, want %s", got.Spec.Image, want)
+			}
+			if got.Status.ImagePolicy != nil {
+				t.Errorf("expected no status to be written, got %#v", got.Status.ImagePolicy)
+			}
+			if len(recorder.Events) != 0 {
+				t.Errorf("expected no events, got %d", len(recorder.Events))
+			}
+		})
+	}
+}
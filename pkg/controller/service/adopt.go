@@ -0,0 +1,163 @@
+package service
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+// isAdopted returns true when the object was created outside of the deployer and annotated to be taken over
+func isAdopted(meta metav1.Object) bool {
+	return meta.GetAnnotations()[AdoptAnnotation] == "true"
+}
+
+func adoptionPolicy() config.AdoptionPolicy {
+	if config.Config.Adoption != "" {
+		return config.Config.Adoption
+	}
+
+	return config.AdoptionAnnotated
+}
+
+// isOwnedBy returns true when the existing object already belongs to the service. Shared objects are created by the
+// first service using them, so they belong to all services as long as only services reference them. Retained claims
+// have no owner, so they belong to the service they are labelled with, even after it was deleted and created again.
+func isOwnedBy(meta metav1.Object, svc *appsv1alpha1.Service) bool {
+	refs := meta.GetOwnerReferences()
+	if containsOwnerReference(refs, svc.UID) {
+		return true
+	}
+
+	if isRetained(meta) && len(refs) == 0 {
+		labels := meta.GetLabels()
+		return labels["apps.kubelix.io/service"] == svc.Name && labels["apps.kubelix.io/project"] == svc.Namespace
+	}
+
+	if !isShared(meta) || len(refs) == 0 {
+		return false
+	}
+	for _, ref := range refs {
+		if ref.APIVersion != appsv1alpha1.SchemeGroupVersion.String() || ref.Kind != "Service" {
+			return false
+		}
+	}
+
+	return true
+}
+
+// ensureOwnership checks whether an existing object may be updated with the generated one. Objects not owned by the
+// service are taken over depending on the adoption policy, objects controlled by another owner never are. A refused
+// object is not managed by the service, so it is never updated nor deleted, and the conflict is reported in the
+// conditions of the service.
+func (r *ReconcileService) ensureOwnership(reqLogger logr.Logger, svc *appsv1alpha1.Service, found, obj runtime.Object, name types.NamespacedName) error {
+	foundMeta, ok := found.(metav1.Object)
+	if !ok {
+		return fmt.Errorf("failed to convert %s to metav1.Object", found)
+	}
+
+	if isOwnedBy(foundMeta, svc) {
+		return nil
+	}
+
+	kind := obj.GetObjectKind().GroupVersionKind().Kind
+	if controller := metav1.GetControllerOf(foundMeta); controller != nil {
+		return r.refuseAdoption(reqLogger, svc, obj, name, "ControlledByOther",
+			fmt.Sprintf("%s %s is controlled by %s %s", kind, name.Name, controller.Kind, controller.Name))
+	}
+
+	switch adoptionPolicy() {
+	case config.AdoptionRefuse:
+		return r.refuseAdoption(reqLogger, svc, obj, name, "NotOwned",
+			fmt.Sprintf("%s %s already exists and is not owned by the service", kind, name.Name))
+	case config.AdoptionAnnotated:
+		if !isAdopted(foundMeta) {
+			return r.refuseAdoption(reqLogger, svc, obj, name, "NotAnnotated",
+				fmt.Sprintf("%s %s already exists and is not annotated with %s: \"true\"", kind, name.Name, AdoptAnnotation))
+		}
+	}
+
+	// the adopted object keeps its other owners and is marked, so it keeps being updated in place
+	if objMeta, ok := obj.(metav1.Object); ok {
+		objMeta.SetOwnerReferences(mergeOwnerReferences(foundMeta.GetOwnerReferences(), objMeta.GetOwnerReferences()))
+		foundMeta.SetAnnotations(mergeLabels(foundMeta.GetAnnotations(), map[string]string{AdoptAnnotation: "true"}))
+	}
+
+	message := fmt.Sprintf("Adopted existing %s %s", kind, name.Name)
+	reqLogger.Info(message)
+	r.recorder.Event(svc, corev1.EventTypeNormal, "Adopted", message)
+	r.countObjectOperation(obj.GetObjectKind().GroupVersionKind(), operationAdopt)
+
+	return nil
+}
+
+func (r *ReconcileService) refuseAdoption(reqLogger logr.Logger, svc *appsv1alpha1.Service, obj runtime.Object, name types.NamespacedName, reason, message string) error {
+	if managedObject := svc.Status.ManagedObjects.Find(obj, name); managedObject != nil {
+		svc.Status.ManagedObjects.Remove(managedObject)
+	}
+
+	changed := svc.Status.Conditions.Set(appsv1alpha1.Condition{
+		Type:    appsv1alpha1.ConditionAdoptionRefused,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	})
+	if changed {
+		r.recorder.Event(svc, corev1.EventTypeWarning, "AdoptionRefused", message)
+	}
+
+	if err := r.update(reqLogger, svc); err != nil {
+		return err
+	}
+
+	return fmt.Errorf("refused to adopt: %s", message)
+}
+
+// resolveAdoptionRefused resets the condition once all objects of the service could be reconciled
+func resolveAdoptionRefused(svc *appsv1alpha1.Service) {
+	if svc.Status.Conditions.Find(appsv1alpha1.ConditionAdoptionRefused) == nil {
+		return
+	}
+
+	svc.Status.Conditions.Set(appsv1alpha1.Condition{
+		Type:   appsv1alpha1.ConditionAdoptionRefused,
+		Status: corev1.ConditionFalse,
+		Reason: "Resolved",
+	})
+}
+
+// adoptObject prepares the update of an adopted object, so it is taken over in place instead of being recreated. The
+// selector of a deployment can not be changed, so the selector of the existing deployment is kept and its labels are
+// added to the pods. Services keep their cluster IP. The annotation is kept, so later updates do the same.
+func adoptObject(found, obj runtime.Object) {
+	foundMeta, ok := found.(metav1.Object)
+	if !ok || !isAdopted(foundMeta) {
+		return
+	}
+
+	if objMeta, ok := obj.(metav1.Object); ok {
+		objMeta.SetAnnotations(mergeLabels(objMeta.GetAnnotations(), map[string]string{AdoptAnnotation: "true"}))
+	}
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		foundDep := found.(*appsv1.Deployment)
+		if foundDep.Spec.Selector == nil || reflect.DeepEqual(foundDep.Spec.Selector, o.Spec.Selector) {
+			return
+		}
+
+		o.Spec.Selector = foundDep.Spec.Selector.DeepCopy()
+		o.Spec.Template.Labels = mergeLabels(foundDep.Spec.Selector.MatchLabels, o.Spec.Template.Labels)
+
+	case *corev1.Service:
+		o.Spec.ClusterIP = found.(*corev1.Service).Spec.ClusterIP
+	}
+}
@@ -0,0 +1,241 @@
+package service
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func Test_adoptObject(t *testing.T) {
+	adopted := metav1.ObjectMeta{Name: "shop", Annotations: map[string]string{AdoptAnnotation: "true"}}
+
+	foundDep := &appsv1.Deployment{
+		ObjectMeta: adopted,
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app": "shop"}},
+		},
+	}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop"},
+		Spec: appsv1.DeploymentSpec{
+			Selector: &metav1.LabelSelector{MatchLabels: map[string]string{"app.kubernetes.io/name": "shop"}},
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{"app.kubernetes.io/name": "shop"}},
+			},
+		},
+	}
+
+	adoptObject(foundDep, dep)
+
+	if !reflect.DeepEqual(dep.Spec.Selector, foundDep.Spec.Selector) {
+		t.Errorf("selector = %v, want the existing selector %v", dep.Spec.Selector, foundDep.Spec.Selector)
+	}
+	wantLabels := map[string]string{"app": "shop", "app.kubernetes.io/name": "shop"}
+	if !reflect.DeepEqual(dep.Spec.Template.Labels, wantLabels) {
+		t.Errorf("pod labels = %v, want %v", dep.Spec.Template.Labels, wantLabels)
+	}
+	if !isAdopted(dep) {
+		t.Error("expected the adopt annotation to be kept")
+	}
+
+	foundSvc := &corev1.Service{ObjectMeta: adopted, Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.10"}}
+	svc := &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+
+	adoptObject(foundSvc, svc)
+
+	if svc.Spec.ClusterIP != "10.0.0.10" {
+		t.Errorf("cluster IP = %q, want the existing one", svc.Spec.ClusterIP)
+	}
+
+	// objects without annotation are updated as usual
+	svc = &corev1.Service{ObjectMeta: metav1.ObjectMeta{Name: "shop"}}
+	adoptObject(&corev1.Service{Spec: corev1.ServiceSpec{ClusterIP: "10.0.0.10"}}, svc)
+
+	if svc.Spec.ClusterIP != "" || isAdopted(svc) {
+		t.Errorf("expected the service to be unchanged, got %+v", svc)
+	}
+}
+
+func TestReconcileService_ensureObject_adoption(t *testing.T) {
+	defer func(policy config.AdoptionPolicy) { config.Config.Adoption = policy }(config.Config.Adoption)
+
+	isController := true
+	otherController := metav1.OwnerReference{APIVersion: "apps/v1", Kind: "ReplicaSet", Name: "other", UID: "other-uid", Controller: &isController}
+
+	tests := []struct {
+		name        string
+		policy      config.AdoptionPolicy
+		annotations map[string]string
+		owners      []metav1.OwnerReference
+		wantReason  string
+	}{
+		{name: "refuse", policy: config.AdoptionRefuse, annotations: map[string]string{AdoptAnnotation: "true"}, wantReason: "NotOwned"},
+		{name: "annotated_without_annotation", policy: config.AdoptionAnnotated, wantReason: "NotAnnotated"},
+		{name: "annotated", policy: config.AdoptionAnnotated, annotations: map[string]string{AdoptAnnotation: "true"}},
+		{name: "default_is_annotated", annotations: map[string]string{AdoptAnnotation: "true"}},
+		{name: "always", policy: config.AdoptionAlways},
+		{name: "controlled_by_other", policy: config.AdoptionAlways, owners: []metav1.OwnerReference{otherController}, wantReason: "ControlledByOther"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Config.Adoption = tt.policy
+
+			s := runtime.NewScheme()
+			_ = scheme.AddToScheme(s)
+			_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+			svc := &appsv1alpha1.Service{ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop", UID: "svc-uid"}}
+			existing := &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:            "shop",
+					Namespace:       "shop",
+					Annotations:     tt.annotations,
+					OwnerReferences: tt.owners,
+				},
+				Data: map[string]string{"key": "existing"},
+			}
+
+			c := fake.NewFakeClientWithScheme(s, svc, existing)
+			r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+			cm := &corev1.ConfigMap{
+				TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop"},
+				Data:       map[string]string{"key": "generated"},
+			}
+			if err := controllerutil.SetControllerReference(svc, cm, s); err != nil {
+				t.Fatal(err)
+			}
+			name := types.NamespacedName{Name: "shop", Namespace: "shop"}
+
+			err := r.ensureObject(log, svc, cm, name)
+
+			found := &corev1.ConfigMap{}
+			if err := c.Get(context.TODO(), name, found); err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.wantReason != "" {
+				if err == nil {
+					t.Fatal("expected the adoption to be refused")
+				}
+				condition := svc.Status.Conditions.Find(appsv1alpha1.ConditionAdoptionRefused)
+				if condition == nil || condition.Status != corev1.ConditionTrue || condition.Reason != tt.wantReason {
+					t.Errorf("condition = %+v, want reason %s", condition, tt.wantReason)
+				}
+				if svc.Status.ManagedObjects.Find(cm, name) != nil {
+					t.Error("expected the refused object not to be managed")
+				}
+				if found.Data["key"] != "existing" {
+					t.Error("expected the refused object to be unchanged")
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatalf("ensureObject() error = %v", err)
+			}
+			if found.Data["key"] != "generated" || !isAdopted(found) {
+				t.Errorf("expected the object to be updated and marked as adopted, got %+v", found)
+			}
+			if controller := metav1.GetControllerOf(found); controller == nil || controller.UID != svc.UID {
+				t.Errorf("expected the service to control the adopted object, got %v", found.OwnerReferences)
+			}
+		})
+	}
+}
+
+func TestReconcileService_ensureObject_retainedClaim(t *testing.T) {
+	defer func(policy config.AdoptionPolicy) { config.Config.Adoption = policy }(config.Config.Adoption)
+	config.Config.Adoption = config.AdoptionAnnotated
+
+	tests := []struct {
+		name    string
+		service string
+		wantErr bool
+	}{
+		{name: "same_service", service: "shop"},
+		{name: "other_service", service: "other", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			_ = scheme.AddToScheme(s)
+			_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+			// the service was deleted and created again, its retained claim is left without owner
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop", UID: "new-uid"},
+				Spec: appsv1alpha1.ServiceSpec{
+					Singleton: true,
+					Volumes: []appsv1alpha1.Volume{
+						{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appsv1alpha1.VolumeClaim{Size: resource.MustParse("2Gi")}},
+					},
+				},
+			}
+			existing := &corev1.PersistentVolumeClaim{
+				ObjectMeta: metav1.ObjectMeta{
+					Name:        "shop-data",
+					Namespace:   "shop",
+					Labels:      map[string]string{"apps.kubelix.io/service": tt.service, "apps.kubelix.io/project": "shop"},
+					Annotations: map[string]string{retainAnnotation: "true"},
+				},
+				Spec: corev1.PersistentVolumeClaimSpec{
+					VolumeName: "pv-1",
+					Resources:  corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+				},
+			}
+
+			c := fake.NewFakeClientWithScheme(s, svc, existing)
+			r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+			_, err := r.ensureVolumeClaims(svc, log)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ensureVolumeClaims() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if tt.wantErr {
+				return
+			}
+
+			found := &corev1.PersistentVolumeClaim{}
+			if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop-data", Namespace: "shop"}, found); err != nil {
+				t.Fatal(err)
+			}
+			if size := found.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "2Gi" || found.Spec.VolumeName != "pv-1" {
+				t.Errorf("expected the retained claim to be updated in place, got %+v", found.Spec)
+			}
+			if isAdopted(found) || svc.Status.Conditions.Find(appsv1alpha1.ConditionAdoptionRefused) != nil {
+				t.Errorf("expected the retained claim to be owned without adoption, got %+v", found.ObjectMeta)
+			}
+		})
+	}
+}
+
+func Test_resolveAdoptionRefused(t *testing.T) {
+	svc := &appsv1alpha1.Service{}
+
+	resolveAdoptionRefused(svc)
+	if len(svc.Status.Conditions) != 0 {
+		t.Errorf("expected no condition to be added, got %v", svc.Status.Conditions)
+	}
+
+	svc.Status.Conditions.Set(appsv1alpha1.Condition{Type: appsv1alpha1.ConditionAdoptionRefused, Status: corev1.ConditionTrue})
+	resolveAdoptionRefused(svc)
+	if svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionAdoptionRefused) {
+		t.Error("expected the condition to be resolved")
+	}
+}
@@ -1,5 +1,7 @@
 package service
 
+import "time"
+
 var ptrOne, ptrThree *int32
 
 func init() {
@@ -15,7 +17,91 @@ func ptrInt64(num int64) *int64 {
 	return &num
 }
 
+func ptrBool(value bool) *bool {
+	return &value
+}
+
 const (
 	dockerConfigContent = `{"auths": {"%s": {"auth": "%s"}}}`
-	fieldIsImmutable = "field is immutable"
+	fieldIsImmutable    = "field is immutable"
+	tlsSecretSuffix     = "-tls"
+
+	// minRequeueAfter is the minimum delay between two reconciles scheduled to refresh expiring credentials
+	minRequeueAfter = 30 * time.Second
+
+	// rollbackAnnotation on a service restores the spec of the referenced revision
+	rollbackAnnotation = "apps.kubelix.io/rollback-to"
+
+	// revisionAnnotation on a deployment holds the revision of the service it was generated from
+	revisionAnnotation = "apps.kubelix.io/revision"
+
+	// progressDeadlineExceeded is the reason of the progressing condition of a deployment whose rollout failed
+	progressDeadlineExceeded = "ProgressDeadlineExceeded"
+
+	// canaryPromoteAnnotation on a service advances its canary rollout to the next step
+	canaryPromoteAnnotation = "apps.kubelix.io/canary-promote"
+
+	// workloadLabel distinguishes the pods of the workload kinds, so the selectors of the old and the new workload never
+	// overlap while the kind of a service is switched
+	workloadLabel       = "apps.kubelix.io/workload"
+	workloadDeployment  = "deployment"
+	workloadStatefulSet = "statefulset"
+
+	// statefulSetSpecForbidden is part of the error returned for updates of statefulset fields that can not be changed
+	statefulSetSpecForbidden = "updates to statefulset spec for fields other than"
+
+	// trackLabel distinguishes the pods of the stable and the canary deployment
+	trackLabel   = "apps.kubelix.io/track"
+	trackStable  = "stable"
+	trackCanary  = "canary"
+	canarySuffix = "canary"
+
+	// annotations of the nginx ingress controller splitting traffic between an ingress and its canary
+	nginxCanaryAnnotation       = "nginx.ingress.kubernetes.io/canary"
+	nginxCanaryWeightAnnotation = "nginx.ingress.kubernetes.io/canary-weight"
+
+	// namespaceNameLabel is set to the name of each namespace by kubernetes, so namespaces can be selected by name
+	namespaceNameLabel = "kubernetes.io/metadata.name"
+
+	// promoteAnnotation on a service switches the traffic to the preview of a blue/green rollout once it is ready
+	promoteAnnotation = "apps.kubelix.io/promote"
+
+	// colorLabel distinguishes the pods of the blue and the green deployment
+	colorLabel    = "apps.kubelix.io/color"
+	previewSuffix = "preview"
+
+	// defaultScaleDownDelay is the time the previously active deployment of a blue/green rollout is kept by default
+	defaultScaleDownDelay = 5 * time.Minute
+
+	// retainAnnotation marks objects that are kept when they are no longer generated for a service
+	retainAnnotation = "apps.kubelix.io/retain"
+
+	// triggerAnnotation on a scheduled service creates a one-off job, its value is part of the job name
+	triggerAnnotation = "apps.kubelix.io/trigger"
+
+	// hookLabel marks the jobs of hooks with the hook they run
+	hookLabel     = "apps.kubelix.io/hook"
+	hookPreDeploy = "pre-deploy"
+
+	// hookChecksumAnnotation on a hook job holds the checksum of the pod it runs
+	hookChecksumAnnotation = "apps.kubelix.io/hook-checksum"
+
+	// headlessLabel marks the headless service of a statefulset, which is not scraped for metrics to avoid duplicates
+	headlessLabel = "apps.kubelix.io/headless"
+
+	// scrape annotations of pods used by prometheus setups without the prometheus operator
+	prometheusScrapeAnnotation = "prometheus.io/scrape"
+	prometheusPortAnnotation   = "prometheus.io/port"
+	prometheusPathAnnotation   = "prometheus.io/path"
+	defaultMetricsPath         = "/metrics"
+
+	// seccompPodAnnotation selects the seccomp profile of all containers of a pod
+	seccompPodAnnotation = "seccomp.security.alpha.kubernetes.io/pod"
+
+	// sharedLabel marks objects that are referenced by several services of a namespace
+	sharedLabel = "apps.kubelix.io/shared"
 )
+
+// AdoptAnnotation marks existing objects the deployer may take over with the annotated adoption policy, set by
+// deployer import --adopt. Adopted objects keep it, so they are updated in place.
+const AdoptAnnotation = "apps.kubelix.io/adopt"
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/metrics"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+const (
+	metricsNamespace = "kubelix"
+	metricsSubsystem = "deployer"
+
+	operationCreate   = "create"
+	operationUpdate   = "update"
+	operationRecreate = "recreate"
+	operationAdopt    = "adopt"
+)
+
+var (
+	reconcileDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "reconcile_duration_seconds",
+		Help:      "Duration of reconciles per service",
+		Buckets:   []float64{0.01, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30},
+	}, []string{"namespace", "service"})
+
+	objectOperations = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "object_operations_total",
+		Help:      "Objects created, updated, recreated and adopted per kind. A recreated object is counted as created as well.",
+	}, []string{"group", "version", "kind", "operation"})
+
+	immutableFieldConflicts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "immutable_field_conflicts_total",
+		Help:      "Updates rejected because of a changed immutable field per kind",
+	}, []string{"group", "version", "kind"})
+
+	cleanupDeletions = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "cleanup_deletions_total",
+		Help:      "Objects deleted because they are no longer generated for a service per kind",
+	}, []string{"group", "version", "kind"})
+
+	validationFailures = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "validation_failures_total",
+		Help:      "Reconciles failed because of an invalid service spec per service and check",
+	}, []string{"namespace", "service", "check"})
+
+	managedObjects = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Namespace: metricsNamespace,
+		Subsystem: metricsSubsystem,
+		Name:      "managed_objects",
+		Help:      "Number of objects managed per service",
+	}, []string{"namespace", "service"})
+)
+
+func init() {
+	metrics.Registry.MustRegister(
+		reconcileDuration,
+		objectOperations,
+		immutableFieldConflicts,
+		cleanupDeletions,
+		validationFailures,
+		managedObjects,
+	)
+}
+
+func observeReconcileDuration(name types.NamespacedName, start time.Time) {
+	reconcileDuration.WithLabelValues(name.Namespace, name.Name).Observe(time.Since(start).Seconds())
+}
+
+// countObjectOperation counts a write of ensureObject, writes of dry runs are not counted
+func (r *ReconcileService) countObjectOperation(gvk schema.GroupVersionKind, operation string) {
+	if r.dryRun {
+		return
+	}
+
+	objectOperations.WithLabelValues(gvk.Group, gvk.Version, gvk.Kind, operation).Inc()
+}
+
+// validationFailed counts the failed check of the service and returns the error
+func validationFailed(svc *appsv1alpha1.Service, check string, err error) error {
+	validationFailures.WithLabelValues(svc.Namespace, svc.Name, check).Inc()
+	return err
+}
+
+// forgetServiceMetrics removes the series of a deleted service
+func forgetServiceMetrics(name types.NamespacedName) {
+	reconcileDuration.DeleteLabelValues(name.Namespace, name.Name)
+	managedObjects.DeleteLabelValues(name.Namespace, name.Name)
+	for _, check := range []string{"files", "volumes", "networkPeers", "metrics", "serviceAccount"} {
+		validationFailures.DeleteLabelValues(name.Namespace, name.Name, check)
+	}
+}
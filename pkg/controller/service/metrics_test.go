@@ -0,0 +1,74 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_ensureObject_metrics(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "counted", Namespace: "metrics"},
+		Spec:       appsv1alpha1.ServiceSpec{Image: "counted:1.0"},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}}
+
+	created := objectOperations.WithLabelValues("", "v1", "ConfigMap", operationCreate)
+	updated := objectOperations.WithLabelValues("", "v1", "ConfigMap", operationUpdate)
+	createdBefore, updatedBefore := testutil.ToFloat64(created), testutil.ToFloat64(updated)
+
+	cm := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "counted",
+			Namespace:       "metrics",
+			OwnerReferences: []metav1.OwnerReference{newOwnerReference(svc)},
+		},
+		Data: map[string]string{"key": "value"},
+	}
+	name := types.NamespacedName{Name: cm.Name, Namespace: cm.Namespace}
+
+	if err := r.ensureObject(log, svc, cm.DeepCopy(), name); err != nil {
+		t.Fatalf("ensureObject() error = %v", err)
+	}
+
+	cm.Data["key"] = "changed"
+	svc.Status.ManagedObjects.Find(cm, name).Checksum = "outdated"
+	if err := r.ensureObject(log, svc, cm.DeepCopy(), name); err != nil {
+		t.Fatalf("ensureObject() error = %v", err)
+	}
+
+	if got := testutil.ToFloat64(created) - createdBefore; got != 1 {
+		t.Errorf("created = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(updated) - updatedBefore; got != 1 {
+		t.Errorf("updated = %v, want 1", got)
+	}
+	if got := testutil.ToFloat64(managedObjects.WithLabelValues("metrics", "counted")); got != 1 {
+		t.Errorf("managed objects = %v, want 1", got)
+	}
+
+	_ = validationFailed(svc, "files", nil)
+	if got := testutil.ToFloat64(validationFailures.WithLabelValues("metrics", "counted", "files")); got != 1 {
+		t.Errorf("validation failures = %v, want 1", got)
+	}
+
+	forgetServiceMetrics(name)
+	if managedObjects.DeleteLabelValues("metrics", "counted") {
+		t.Errorf("expected the series of the deleted service to be removed")
+	}
+}
@@ -0,0 +1,60 @@
+package service
+
+import (
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+// newOwnerReference returns a non-controller owner reference to the service. Objects shared by several services
+// carry one of those per service, so the garbage collector removes them once the last service is gone.
+func newOwnerReference(svc *appsv1alpha1.Service) metav1.OwnerReference {
+	return metav1.OwnerReference{
+		APIVersion: appsv1alpha1.SchemeGroupVersion.String(),
+		Kind:       "Service",
+		Name:       svc.Name,
+		UID:        svc.UID,
+	}
+}
+
+// mergeOwnerReferences adds all references from desired to existing that are not yet part of it
+func mergeOwnerReferences(existing, desired []metav1.OwnerReference) []metav1.OwnerReference {
+	result := append([]metav1.OwnerReference{}, existing...)
+
+	for _, ref := range desired {
+		if !containsOwnerReference(result, ref.UID) {
+			result = append(result, ref)
+		}
+	}
+
+	return result
+}
+
+// removeOwnerReference returns the references without the one pointing to the owner with the given uid
+func removeOwnerReference(refs []metav1.OwnerReference, uid types.UID) []metav1.OwnerReference {
+	result := make([]metav1.OwnerReference, 0, len(refs))
+
+	for _, ref := range refs {
+		if ref.UID != uid {
+			result = append(result, ref)
+		}
+	}
+
+	return result
+}
+
+func containsOwnerReference(refs []metav1.OwnerReference, uid types.UID) bool {
+	for _, ref := range refs {
+		if ref.UID == uid {
+			return true
+		}
+	}
+
+	return false
+}
+
+// isShared returns true when the object is shared by several services
+func isShared(meta metav1.Object) bool {
+	return meta.GetLabels()[sharedLabel] == "true"
+}
@@ -0,0 +1,61 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func Test_mergeOwnerReferences(t *testing.T) {
+	refA := metav1.OwnerReference{Name: "a", UID: "uid-a"}
+	refB := metav1.OwnerReference{Name: "b", UID: "uid-b"}
+
+	tests := []struct {
+		name     string
+		existing []metav1.OwnerReference
+		desired  []metav1.OwnerReference
+		want     []metav1.OwnerReference
+	}{
+		{
+			name:     "empty",
+			existing: nil,
+			desired:  []metav1.OwnerReference{refA},
+			want:     []metav1.OwnerReference{refA},
+		},
+		{
+			name:     "add",
+			existing: []metav1.OwnerReference{refA},
+			desired:  []metav1.OwnerReference{refB},
+			want:     []metav1.OwnerReference{refA, refB},
+		},
+		{
+			name:     "already_contained",
+			existing: []metav1.OwnerReference{refA, refB},
+			desired:  []metav1.OwnerReference{refA},
+			want:     []metav1.OwnerReference{refA, refB},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := mergeOwnerReferences(tt.existing, tt.desired); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("mergeOwnerReferences() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_removeOwnerReference(t *testing.T) {
+	refA := metav1.OwnerReference{Name: "a", UID: "uid-a"}
+	refB := metav1.OwnerReference{Name: "b", UID: "uid-b"}
+
+	got := removeOwnerReference([]metav1.OwnerReference{refA, refB}, "uid-a")
+	if want := []metav1.OwnerReference{refB}; !reflect.DeepEqual(got, want) {
+		t.Errorf("removeOwnerReference() = %v, want %v", got, want)
+	}
+
+	got = removeOwnerReference([]metav1.OwnerReference{refB}, "uid-b")
+	if len(got) != 0 {
+		t.Errorf("removeOwnerReference() = %v, want empty list", got)
+	}
+}
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"fmt"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/registry"
+)
+
+// RenderOptions configures Render
+type RenderOptions struct {
+	// Scheme the owner references are set with, it needs to contain the kubelix types
+	Scheme *runtime.Scheme
+
+	// ServiceMonitors renders a ServiceMonitor instead of scrape annotations, like in clusters running the prometheus
+	// operator
+	ServiceMonitors bool
+}
+
+// Render builds the objects the deployer creates for the service without a cluster. Everything that can only be
+// resolved in the cluster is reported as a warning: credentials read from secrets or plugins, image digests and
+// pre-deploy hooks. Canaries and blue/green previews depend on the state of the rollout, so only the workload of the
+// current spec is rendered.
+func Render(svc *appsv1alpha1.Service, opts RenderOptions) ([]runtime.Object, []string, error) {
+	b := &objectBuilder{scheme: opts.Scheme, serviceMonitors: opts.ServiceMonitors}
+	svc = svc.DeepCopy()
+
+	if err := validateForRender(svc); err != nil {
+		return nil, nil, err
+	}
+
+	objects := make([]runtime.Object, 0)
+	dockerConfigs, warnings := renderDockerConfigs(svc)
+
+	if svc.Spec.PinDigest && (svc.Status.ResolvedImage == nil || svc.Status.ResolvedImage.Image != svc.Spec.Image) {
+		warnings = append(warnings, fmt.Sprintf("image %s is not pinned to its digest, it is resolved in the cluster", svc.Spec.Image))
+	}
+	if preDeployHook(svc) != nil {
+		warnings = append(warnings, "the pre-deploy hook runs as a job of each revision and is not rendered")
+	}
+
+	secrets, err := b.newDockerPullSecretsForService(svc, dockerConfigs)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, s := range secrets {
+		objects = append(objects, s)
+	}
+
+	configMap, err := b.newFilesConfigMapForService(svc)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects = append(objects, configMap)
+
+	claims, err := b.newVolumeClaimsForService(svc)
+	if err != nil {
+		return nil, nil, err
+	}
+	for _, c := range claims {
+		objects = append(objects, c)
+	}
+
+	serviceAccount, err := b.renderServiceAccount(svc)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects = append(objects, serviceAccount...)
+
+	workloads, err := b.renderWorkloads(svc, secrets)
+	if err != nil {
+		return nil, nil, err
+	}
+	objects = append(objects, workloads...)
+
+	if len(svc.Spec.Ports) > 0 && !isCronJob(svc) {
+		coreService, err := b.newServiceForService(svc)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, coreService)
+
+		ingresses, err := b.newIngressesForService(svc)
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, i := range ingresses {
+			objects = append(objects, i)
+		}
+
+		if svc.Spec.Metrics != nil && b.serviceMonitors {
+			serviceMonitor, err := b.newServiceMonitorForService(svc)
+			if err != nil {
+				return nil, nil, err
+			}
+			objects = append(objects, serviceMonitor)
+		}
+	}
+
+	if len(svc.Spec.IngressFrom) > 0 || len(svc.Spec.EgressTo) > 0 {
+		policy, err := b.newNetworkPolicyForService(svc)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, policy)
+	}
+
+	return objects, warnings, nil
+}
+
+// validateForRender runs the checks of the spec which are done while the objects are ensured
+func validateForRender(svc *appsv1alpha1.Service) error {
+	if err := validateNetworkPeers("ingressFrom", svc.Spec.IngressFrom); err != nil {
+		return err
+	}
+	if err := validateNetworkPeers("egressTo", svc.Spec.EgressTo); err != nil {
+		return err
+	}
+	if err := validateMetrics(svc); err != nil {
+		return err
+	}
+
+	return validateServiceAccount(svc)
+}
+
+// renderDockerConfigs resolves the credentials of the registries the service pulls images from. Only credentials
+// configured in the config file itself are available offline, all others are skipped with a warning. The labels of
+// the namespace are unknown, so namespace selectors are assumed to match.
+func renderDockerConfigs(svc *appsv1alpha1.Service) ([]dockerConfig, []string) {
+	configs := make([]dockerConfig, 0)
+	warnings := make([]string, 0)
+
+	for _, reg := range config.Config.DockerPullSecretes {
+		selector := reg.NamespaceSelector
+		reg.NamespaceSelector = nil
+		if !dockerPullSecretMatchesService(reg, svc, nil) {
+			continue
+		}
+
+		if selector != nil {
+			warnings = append(warnings, fmt.Sprintf("the namespace selector of registry %s can not be checked, it is assumed to match", reg.Registry))
+		}
+
+		if reg.SecretRef != nil || reg.PasswordSecretRef != nil || reg.Exec != nil {
+			warnings = append(warnings, fmt.Sprintf("the credentials of registry %s are resolved in the cluster, its docker pull secret is not rendered", reg.Registry))
+			continue
+		}
+
+		configs = append(configs, dockerConfig{
+			Registry: reg.Registry,
+			Content:  formatDockerPullSecret(reg.Registry, reg.Username, reg.Password),
+			Auth:     registry.Auth{Username: reg.Username, Password: reg.Password},
+		})
+	}
+
+	return configs, warnings
+}
+
+func (b *objectBuilder) renderServiceAccount(svc *appsv1alpha1.Service) ([]runtime.Object, error) {
+	sa := svc.Spec.ServiceAccount
+	if sa == nil {
+		return nil, nil
+	}
+
+	objects := make([]runtime.Object, 0, 3)
+
+	if sa.Create {
+		serviceAccount, err := b.newServiceAccountForService(svc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, serviceAccount)
+	}
+
+	if len(sa.Rules) > 0 {
+		role, err := b.newRoleForService(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		binding, err := b.newRoleBindingForService(svc, role)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, role, binding)
+	}
+
+	return objects, nil
+}
+
+// renderWorkloads builds the workloads of a service which was never rolled out before, blue/green services start with
+// the blue deployment
+func (b *objectBuilder) renderWorkloads(svc *appsv1alpha1.Service, secrets []*corev1.Secret) ([]runtime.Object, error) {
+	switch {
+	case isCronJob(svc):
+		cronJob, err := b.newCronJobForService(svc, secrets)
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{cronJob}, nil
+
+	case isStatefulSet(svc):
+		headless, err := b.newHeadlessServiceForService(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		sts, err := b.newStatefulSetForService(svc, secrets)
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{headless, sts}, nil
+
+	case rolloutStrategy(svc) == appsv1alpha1.StrategyBlueGreen:
+		if svc.Status.BlueGreen == nil {
+			svc.Status.BlueGreen = &appsv1alpha1.BlueGreenStatus{ActiveColor: appsv1alpha1.ColorBlue}
+		}
+
+		dep, err := b.newColorDeploymentForService(svc, svc.Status.BlueGreen.ActiveColor, secrets)
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{dep}, nil
+
+	default:
+		dep, err := b.newDeploymentForService(svc, secrets)
+		if err != nil {
+			return nil, err
+		}
+		return []runtime.Object{dep}, nil
+	}
+}
@@ -0,0 +1,99 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestRender(t *testing.T) {
+	defer func(cfg []config.DockerPullSecret) { config.Config.DockerPullSecretes = cfg }(config.Config.DockerPullSecretes)
+	config.Config.DockerPullSecretes = []config.DockerPullSecret{
+		{Registry: "registry.example.com", Username: "ci", Password: "secret"},
+		{Registry: "registry.example.com", SecretRef: &config.SecretRef{Name: "registry"}},
+		{Registry: "other.example.com", Username: "ci", Password: "secret"},
+	}
+
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "registry.example.com/shop:1.0",
+			Ports: appsv1alpha1.PortList{
+				{Name: "http", Container: 8080, Ingresses: []appsv1alpha1.PortIngress{{Host: "shop.example.com"}}},
+			},
+		},
+	}
+
+	objects, warnings, err := Render(svc, RenderOptions{Scheme: s})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	kinds := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		kinds = append(kinds, obj.GetObjectKind().GroupVersionKind().Kind)
+	}
+	wantKinds := []string{"Secret", "ConfigMap", "Deployment", "Service", "Ingress"}
+	if !reflect.DeepEqual(kinds, wantKinds) {
+		t.Errorf("Render() kinds = %v, want %v", kinds, wantKinds)
+	}
+
+	if len(warnings) != 1 {
+		t.Errorf("expected a warning about the credentials read from a secret, got %v", warnings)
+	}
+
+	dep := objects[2].(*appsv1.Deployment)
+	if secrets := dep.Spec.Template.Spec.ImagePullSecrets; len(secrets) != 1 || secrets[0].Name != objects[0].(*corev1.Secret).Name {
+		t.Errorf("expected the deployment to reference the rendered secret, got %v", secrets)
+	}
+
+	// services with invalid specs are rejected like in the cluster
+	svc.Spec.Metrics = &appsv1alpha1.Metrics{Port: "metrics"}
+	if _, _, err := Render(svc, RenderOptions{Scheme: s}); err == nil {
+		t.Error("expected an error for a metrics port the service does not have")
+	}
+}
+
+func TestRender_blueGreen(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image:    "shop:1.0",
+			Strategy: appsv1alpha1.StrategyBlueGreen,
+			Ports:    appsv1alpha1.PortList{{Name: "http", Container: 8080}},
+		},
+	}
+
+	objects, _, err := Render(svc, RenderOptions{Scheme: s})
+	if err != nil {
+		t.Fatalf("Render() error = %v", err)
+	}
+
+	dep := objects[1].(*appsv1.Deployment)
+	coreService := objects[2].(*corev1.Service)
+	if dep.Name != colorName(svc, appsv1alpha1.ColorBlue) {
+		t.Errorf("expected the blue deployment to be rendered, got %s", dep.Name)
+	}
+	if !reflect.DeepEqual(coreService.Spec.Selector, dep.Spec.Template.Labels) {
+		t.Errorf("service selector %v does not match the pods %v", coreService.Spec.Selector, dep.Spec.Template.Labels)
+	}
+	if svc.Status.BlueGreen != nil {
+		t.Error("expected the service to be left untouched")
+	}
+}
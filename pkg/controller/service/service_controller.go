@@ -3,12 +3,21 @@ package service
 import (
 	"context"
 	"fmt"
+	"reflect"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
 	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/runtime"
 	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/record"
 	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller"
 	"sigs.k8s.io/controller-runtime/pkg/handler"
@@ -18,6 +27,9 @@ import (
 	"sigs.k8s.io/controller-runtime/pkg/source"
 
 	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/credentials"
+	"github.com/kubelix/deployer/pkg/registry"
 )
 
 var log = logf.Log.WithName("controller_service")
@@ -30,7 +42,17 @@ func Add(mgr manager.Manager) error {
 
 // newReconciler returns a new reconcile.Reconciler
 func newReconciler(mgr manager.Manager) reconcile.Reconciler {
-	return &ReconcileService{client: mgr.GetClient(), scheme: mgr.GetScheme()}
+	return &ReconcileService{
+		client:   mgr.GetClient(),
+		recorder: mgr.GetEventRecorderFor("service-controller"),
+		registry: registry.NewClient(registry.Options{PlainHTTP: config.Config.PlainHTTPRegistries}),
+
+		objectBuilder: objectBuilder{
+			scheme:          mgr.GetScheme(),
+			serviceMonitors: serviceMonitorsAvailable(mgr.GetConfig()),
+		},
+		credentials: &credentialsCache{},
+	}
 }
 
 // add adds a new Controller to mgr with r as the reconcile.Reconciler
@@ -47,6 +69,41 @@ func add(mgr manager.Manager, r reconcile.Reconciler) error {
 		return err
 	}
 
+	// Watch for changes to secrets holding docker pull credentials in the operator namespace. Each referenced secret
+	// is watched on its own, so the secrets of the whole cluster are not cached.
+	clientset, err := kubernetes.NewForConfig(mgr.GetConfig())
+	if err != nil {
+		return err
+	}
+	for _, name := range dockerPullSecretSources() {
+		informer := newOperatorSecretInformer(clientset, name)
+		err = mgr.Add(manager.RunnableFunc(func(stop <-chan struct{}) error {
+			informer.Run(stop)
+			return nil
+		}))
+		if err != nil {
+			return err
+		}
+
+		err = c.Watch(&source.Informer{Informer: informer}, &handler.EnqueueRequestsFromMapFunc{
+			ToRequests: newDockerPullSecretMapper(mgr.GetClient()),
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	// Watch for changes to the workloads and hook jobs to follow their rollout
+	for _, t := range []runtime.Object{&appsv1.Deployment{}, &appsv1.StatefulSet{}, &batchv1.Job{}} {
+		err = c.Watch(&source.Kind{Type: t}, &handler.EnqueueRequestForOwner{
+			IsController: true,
+			OwnerType:    &appsv1alpha1.Service{},
+		})
+		if err != nil {
+			return err
+		}
+	}
+
 	//createdItems := []runtime.Object{
 	//	//&appsv1.Deployment{},
 	//	//&corev1.Service{},
@@ -73,8 +130,32 @@ var _ reconcile.Reconciler = &ReconcileService{}
 type ReconcileService struct {
 	// This client, initialized using mgr.Client() above, is a split client
 	// that reads objects from the cache and writes to the apiserver
-	client client.Client
+	client   client.Client
+	recorder record.EventRecorder
+	registry registry.Client
+
+	objectBuilder
+
+	// credentials caches the providers of docker registry credentials by their index in the config
+	credentials *credentialsCache
+
+	// dryRun is set on the copy running a dry run, whose client only records writes
+	dryRun bool
+}
+
+// objectBuilder creates the objects of a service. It does not need a cluster, so the objects can be rendered offline,
+// see Render.
+type objectBuilder struct {
 	scheme *runtime.Scheme
+
+	// serviceMonitors is true when the ServiceMonitor CRD of the prometheus operator was found at startup
+	serviceMonitors bool
+}
+
+// credentialsCache holds the providers of docker registry credentials, it is shared by dry runs
+type credentialsCache struct {
+	sync.Mutex
+	providers map[int]credentials.Provider
 }
 
 // Reconcile reads that state of the cluster for a Service object and makes changes based on the state read
@@ -85,6 +166,7 @@ type ReconcileService struct {
 func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Result, error) {
 	reqLogger := log.WithValues("Request.Namespace", request.Namespace, "Request.Name", request.Name)
 	reqLogger.Info("Reconciling Service")
+	start := time.Now()
 
 	// Fetch the Service object
 	svc := &appsv1alpha1.Service{}
@@ -94,18 +176,54 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 			// Request object not found, could have been deleted after reconcile request.
 			// Owned objects are automatically garbage collected. For additional cleanup logic use finalizers.
 			// Return and don't requeue
+			forgetServiceMetrics(request.NamespacedName)
 			return reconcile.Result{}, nil
 		}
 		// Error reading the object - requeue the request.
 		return reconcile.Result{}, err
 	}
 
+	defer observeReconcileDuration(request.NamespacedName, start)
+
+	if isDryRun(svc) {
+		return r.reconcileDryRun(svc, reqLogger)
+	}
+
+	return r.reconcile(svc, reqLogger)
+}
+
+// reconcile applies the spec of the service
+func (r *ReconcileService) reconcile(svc *appsv1alpha1.Service, reqLogger logr.Logger) (reconcile.Result, error) {
+	if err := r.rollback(svc, reqLogger); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	if err := r.checkRollout(svc, reqLogger); err != nil {
+		return reconcile.Result{}, err
+	}
+
 	generatedObjects := make([]runtime.Object, 0)
 
-	secrets, err := r.ensureDockerPullSecrets(svc, reqLogger)
+	dockerConfigs, err := r.loadDockerConfigs(svc)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	secrets, credentialsExpireAt, err := r.ensureDockerPullSecrets(svc, dockerConfigs, reqLogger)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
+
+	if err := r.resolveImage(svc, dockerConfigs, reqLogger); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	revisions, err := r.ensureRevisions(svc, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	generatedObjects = append(generatedObjects, revisions)
+
 	for _, s := range secrets {
 		generatedObjects = append(generatedObjects, s)
 	}
@@ -116,13 +234,48 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 	}
 	generatedObjects = append(generatedObjects, configMap)
 
-	dep, err := r.ensureDeployment(svc, secrets, reqLogger)
+	volumeClaims, err := r.ensureVolumeClaims(svc, reqLogger)
 	if err != nil {
 		return reconcile.Result{}, err
 	}
-	generatedObjects = append(generatedObjects, dep)
+	generatedObjects = append(generatedObjects, volumeClaims...)
+
+	// the service account needs to exist before any pod using it is created
+	serviceAccount, err := r.ensureServiceAccount(svc, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	generatedObjects = append(generatedObjects, serviceAccount...)
+
+	// the workloads are held back until the pre-deploy hook of the latest revision succeeded. They keep running the
+	// previous revision, so the cleanup keeps them until the latest revision is ready.
+	hookSucceeded, err := r.ensurePreDeployHook(svc, secrets, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// a dry run reports the changes of the workloads the hook would hold back as well
+	var nextCanaryStep, scaleDownAfter time.Duration
+	if hookSucceeded || r.dryRun {
+		nextCanaryStep, err = r.advanceCanary(svc, reqLogger)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+
+		scaleDownAfter, err = r.advanceBlueGreen(svc, reqLogger)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
 
-	if len(svc.Spec.Ports) > 0 {
+		workloads, err := r.ensureWorkloads(svc, secrets, reqLogger)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		generatedObjects = append(generatedObjects, workloads...)
+	}
+
+	// scheduled services run to completion, so they are never exposed
+	if len(svc.Spec.Ports) > 0 && !isCronJob(svc) {
 		coreService, err := r.ensureService(svc, reqLogger)
 		if err != nil {
 			return reconcile.Result{}, err
@@ -136,30 +289,130 @@ func (r *ReconcileService) Reconcile(request reconcile.Request) (reconcile.Resul
 		for _, i := range ingresses {
 			generatedObjects = append(generatedObjects, i)
 		}
+
+		serviceMonitor, err := r.ensureServiceMonitor(svc, reqLogger)
+		if err != nil {
+			return reconcile.Result{}, err
+		}
+		if serviceMonitor != nil {
+			generatedObjects = append(generatedObjects, serviceMonitor)
+		}
+	}
+
+	networkPolicy, err := r.ensureNetworkPolicy(svc, reqLogger)
+	if err != nil {
+		return reconcile.Result{}, err
+	}
+	if networkPolicy != nil {
+		generatedObjects = append(generatedObjects, networkPolicy)
 	}
 
 	if err := r.cleanupManagedObjects(reqLogger, svc, generatedObjects); err != nil {
 		return reconcile.Result{}, err
 	}
 
-	return reconcile.Result{}, r.update(reqLogger, svc)
+	if err := r.cleanupOrphanedReplicaSets(svc, reqLogger); err != nil {
+		return reconcile.Result{}, err
+	}
+
+	// short-lived registry credentials need to be refreshed before they expire
+	result := requeueBefore(credentialsExpireAt)
+	result = requeueAfter(result, nextCanaryStep)
+	result = requeueAfter(result, scaleDownAfter)
+
+	resolveAdoptionRefused(svc)
+
+	return result, r.update(reqLogger, svc)
+}
+
+// ensureWorkloads creates the workloads running the pods of the service depending on its kind and rollout strategy
+func (r *ReconcileService) ensureWorkloads(svc *appsv1alpha1.Service, secrets []*corev1.Secret, reqLogger logr.Logger) ([]runtime.Object, error) {
+	switch {
+	case isCronJob(svc):
+		cronJob, err := r.ensureCronJob(svc, secrets, reqLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		if err := r.triggerJob(svc, cronJob, reqLogger); err != nil {
+			return nil, err
+		}
+
+		return []runtime.Object{cronJob}, nil
+
+	case isStatefulSet(svc):
+		return r.ensureStatefulSet(svc, secrets, reqLogger)
+
+	case rolloutStrategy(svc) == appsv1alpha1.StrategyBlueGreen:
+		return r.ensureBlueGreen(svc, secrets, reqLogger)
+
+	default:
+		dep, err := r.ensureDeployment(svc, secrets, reqLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		canary, err := r.ensureCanary(svc, secrets, reqLogger)
+		if err != nil {
+			return nil, err
+		}
+
+		return append([]runtime.Object{dep}, canary...), nil
+	}
+}
+
+// requeueAfter shortens the delay of the result to the given duration, if it is set
+func requeueAfter(result reconcile.Result, after time.Duration) reconcile.Result {
+	if after <= 0 {
+		return result
+	}
+
+	if result.RequeueAfter == 0 || after < result.RequeueAfter {
+		result.RequeueAfter = after
+	}
+
+	return result
 }
 
-func (r *ReconcileService) makeKubelixLabels(svc *appsv1alpha1.Service) map[string]string {
+// requeueBefore returns a result that requeues the request shortly before the given point in time, if it is set
+func requeueBefore(expiresAt time.Time) reconcile.Result {
+	if expiresAt.IsZero() {
+		return reconcile.Result{}
+	}
+
+	after := time.Until(expiresAt.Add(-credentials.RefreshBefore))
+	if after < minRequeueAfter {
+		after = minRequeueAfter
+	}
+
+	return reconcile.Result{RequeueAfter: after}
+}
+
+func (b *objectBuilder) makeKubelixLabels(svc *appsv1alpha1.Service) map[string]string {
 	return map[string]string{
 		"apps.kubelix.io/service": svc.Name,
 		"apps.kubelix.io/project": svc.Namespace,
 	}
 }
 
-func (r *ReconcileService) makeLabels(svc *appsv1alpha1.Service) map[string]string {
-	return mergeLabels(r.makeKubelixLabels(svc), map[string]string{
+func (b *objectBuilder) makeLabels(svc *appsv1alpha1.Service) map[string]string {
+	return mergeLabels(b.makeKubelixLabels(svc), map[string]string{
 		"app.kubernetes.io/name":       svc.Namespace,
 		"app.kubernetes.io/svc":        svc.Name,
 		"app.kubernetes.io/managed-by": "kubelix-deployer",
 	})
 }
 
+// makeSharedLabels returns the labels for objects that are shared by all services of a namespace
+func (b *objectBuilder) makeSharedLabels(svc *appsv1alpha1.Service) map[string]string {
+	return map[string]string{
+		"apps.kubelix.io/project":      svc.Namespace,
+		"app.kubernetes.io/name":       svc.Namespace,
+		"app.kubernetes.io/managed-by": "kubelix-deployer",
+		sharedLabel:                    "true",
+	}
+}
+
 func (r *ReconcileService) ensureObject(reqLogger logr.Logger, svc *appsv1alpha1.Service, obj runtime.Object, name types.NamespacedName) error {
 	objGVK := obj.GetObjectKind().GroupVersionKind()
 	reqLogger = reqLogger.WithValues(
@@ -181,7 +434,8 @@ func (r *ReconcileService) ensureObject(reqLogger logr.Logger, svc *appsv1alpha1
 		return nil
 	}
 
-	found := obj.DeepCopyObject()
+	// a copy of obj would keep fields the existing object does not set, e.g. the owner references
+	found := reflect.New(reflect.TypeOf(obj).Elem()).Interface().(runtime.Object)
 	err = r.client.Get(context.TODO(), name, found)
 	if err != nil {
 		if errors.IsNotFound(err) {
@@ -191,6 +445,7 @@ func (r *ReconcileService) ensureObject(reqLogger logr.Logger, svc *appsv1alpha1
 				reqLogger.Error(err, fmt.Sprintf("Object: %#v", obj))
 				return fmt.Errorf("failed to create object: %v", err)
 			}
+			r.countObjectOperation(objGVK, operationCreate)
 
 			return r.update(reqLogger, svc)
 		}
@@ -198,26 +453,78 @@ func (r *ReconcileService) ensureObject(reqLogger logr.Logger, svc *appsv1alpha1
 		return err
 	}
 
+	// an object recreated without its dependents is only removed once they are orphaned
+	if foundMeta, ok := found.(metav1.Object); ok && foundMeta.GetDeletionTimestamp() != nil {
+		return fmt.Errorf("object is being deleted, waiting to create it again")
+	}
+
+	if err := r.ensureOwnership(reqLogger, svc, found, obj, name); err != nil {
+		return err
+	}
+
 	reqLogger.Info("Updating existing object")
 
-	err = r.client.Update(context.TODO(), obj)
+	if err := mergeSharedOwnerReferences(found, obj); err != nil {
+		return err
+	}
+
+	if foundMeta, ok := found.(metav1.Object); ok && isAdopted(foundMeta) {
+		reqLogger.Info("Updating adopted object in place")
+		adoptObject(found, obj)
+	}
+
+	err = r.client.Update(context.TODO(), mergeVolumeClaim(found, obj))
 	if err != nil {
-		if strings.Contains(err.Error(), fieldIsImmutable) {
-			errDelete := r.client.Delete(context.TODO(), obj)
+		notPermitted := strings.Contains(err.Error(), fieldIsImmutable) || strings.Contains(err.Error(), statefulSetSpecForbidden)
+		if notPermitted {
+			immutableFieldConflicts.WithLabelValues(objGVK.Group, objGVK.Version, objGVK.Kind).Inc()
+		}
+
+		if notPermitted && recreatable(obj) {
+			errDelete := r.deleteForRecreate(found, obj)
 			if errDelete != nil {
 				return fmt.Errorf("failed to delete object after update was not permitted (field is immutable): %v", errDelete)
 			}
+			r.countObjectOperation(objGVK, operationRecreate)
 
-			// as we have deleted the object we now can safely recreate it
+			// as we have deleted the object we now can safely recreate it, its checksum must not skip the creation
+			if managedObject := svc.Status.ManagedObjects.Find(obj, name); managedObject != nil {
+				svc.Status.ManagedObjects.Remove(managedObject)
+			}
 			return r.ensureObject(reqLogger, svc, obj, name)
 		}
 		return fmt.Errorf("failed to update object: %v", err)
 	}
+	r.countObjectOperation(objGVK, operationUpdate)
 
 	return r.update(reqLogger, svc)
 }
 
+// deleteForRecreate deletes an object whose update was not permitted. Workloads are deleted without their pods, which
+// keep running until the recreated workload replaced them.
+func (r *ReconcileService) deleteForRecreate(found, obj runtime.Object) error {
+	switch o := obj.(type) {
+	case *appsv1.StatefulSet:
+		if err := r.relabelStatefulSetPods(found.(*appsv1.StatefulSet), o); err != nil {
+			return err
+		}
+		return r.client.Delete(context.TODO(), found, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+
+	case *appsv1.Deployment:
+		return r.client.Delete(context.TODO(), found, client.PropagationPolicy(metav1.DeletePropagationOrphan))
+	}
+
+	return r.client.Delete(context.TODO(), obj)
+}
+
 func (r *ReconcileService) update(reqLogger logr.Logger, svc *appsv1alpha1.Service) error {
+	// dry runs work on a copy of the service, which must not be reloaded nor persisted
+	if r.dryRun {
+		return nil
+	}
+
+	managedObjects.WithLabelValues(svc.Namespace, svc.Name).Set(float64(len(svc.Status.ManagedObjects)))
+
 	if err := r.client.Status().Update(context.TODO(), svc); err != nil {
 		return fmt.Errorf("failed to update status: %v", err)
 	}
@@ -257,13 +564,32 @@ func (r *ReconcileService) cleanupManagedObjects(reqLogger logr.Logger, svc *app
 	newList := appsv1alpha1.ManagedObjectList{}
 	newList.FromObjectList(generatedObjects)
 
+	var workloadReady *bool
+
 	for _, ref := range svc.Status.ManagedObjects {
 		// managedObject is also contained by current version, so the object was not deleted
 		if newList.Contains(ref) {
 			continue
 		}
 
-		if err := r.deleteManagedObject(reqLogger, ref); err != nil {
+		// a workload that is replaced, e.g. when switching from a deployment to a statefulset, keeps running until
+		// the workload replacing it is ready
+		if isWorkload(ref) {
+			if workloadReady == nil {
+				ready, err := r.workloadReady(svc)
+				if err != nil {
+					return err
+				}
+				workloadReady = &ready
+			}
+
+			if !*workloadReady {
+				reqLogger.Info(fmt.Sprintf("keeping managedObject %s until its replacement is ready", ref))
+				continue
+			}
+		}
+
+		if err := r.deleteManagedObject(reqLogger, svc, ref); err != nil {
 			return fmt.Errorf("failed to clean up object: %v", err)
 		}
 
@@ -273,7 +599,7 @@ func (r *ReconcileService) cleanupManagedObjects(reqLogger logr.Logger, svc *app
 	return nil
 }
 
-func (r *ReconcileService) deleteManagedObject(reqLogger logr.Logger, managedObject *appsv1alpha1.ManagedObject) error {
+func (r *ReconcileService) deleteManagedObject(reqLogger logr.Logger, svc *appsv1alpha1.Service, managedObject *appsv1alpha1.ManagedObject) error {
 	kind := managedObject.GroupVersionKind()
 	name := managedObject.NamespacedName()
 
@@ -292,12 +618,53 @@ func (r *ReconcileService) deleteManagedObject(reqLogger logr.Logger, managedObj
 		return fmt.Errorf("failed to find managedObject %s: %v", managedObject, err)
 	}
 
+	if meta, ok := obj.(metav1.Object); ok && isRetained(meta) {
+		reqLogger.Info(fmt.Sprintf("retained managedObject %s", managedObject))
+		return nil
+	}
+
+	if meta, ok := obj.(metav1.Object); ok && isShared(meta) {
+		// shared objects are only deleted once the last service referencing them is gone
+		refs := removeOwnerReference(meta.GetOwnerReferences(), svc.UID)
+		if len(refs) > 0 {
+			meta.SetOwnerReferences(refs)
+			if err := r.client.Update(context.TODO(), obj); err != nil {
+				return fmt.Errorf("failed to release shared managedObject %s: %v", managedObject, err)
+			}
+
+			reqLogger.Info(fmt.Sprintf("released shared managedObject %s", managedObject))
+			return nil
+		}
+	}
+
 	err = r.client.Delete(context.TODO(), obj)
 	if err != nil {
 		return fmt.Errorf("failed to delete managedObject %s: %v", managedObject, err)
 	}
 
+	if !r.dryRun {
+		cleanupDeletions.WithLabelValues(kind.Group, kind.Version, kind.Kind).Inc()
+	}
 	reqLogger.Info(fmt.Sprintf("deleted managedObject %s", managedObject))
 
 	return nil
 }
+
+// mergeSharedOwnerReferences keeps the owner references of all other services on objects shared by several services
+func mergeSharedOwnerReferences(found, obj runtime.Object) error {
+	foundMeta, ok := found.(metav1.Object)
+	if !ok {
+		return fmt.Errorf("failed to convert %s to metav1.Object", found)
+	}
+
+	objMeta, ok := obj.(metav1.Object)
+	if !ok {
+		return fmt.Errorf("failed to convert %s to metav1.Object", obj)
+	}
+
+	if isShared(objMeta) {
+		objMeta.SetOwnerReferences(mergeOwnerReferences(foundMeta.GetOwnerReferences(), objMeta.GetOwnerReferences()))
+	}
+
+	return nil
+}
@@ -0,0 +1,338 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+// advanceBlueGreen rolls out the latest revision to the inactive deployment and promotes it once all of its pods are
+// ready. It returns the duration after which the previously active deployment is due to be removed, zero if none.
+func (r *ReconcileService) advanceBlueGreen(svc *appsv1alpha1.Service, reqLogger logr.Logger) (time.Duration, error) {
+	if rolloutStrategy(svc) != appsv1alpha1.StrategyBlueGreen {
+		svc.Status.BlueGreen = nil
+		return 0, nil
+	}
+
+	latest := svc.Status.Revisions.Latest()
+	if latest == nil {
+		return 0, nil
+	}
+
+	status := svc.Status.BlueGreen
+	if status == nil {
+		// there is nothing to preview against, so the first revision is rolled out to blue directly. It becomes
+		// active once all of its pods are ready, until then the service keeps selecting the pods it selected before.
+		ready, err := r.colorDeploymentReady(svc, appsv1alpha1.ColorBlue, latest.Revision)
+		if err != nil || !ready {
+			return 0, err
+		}
+
+		status = &appsv1alpha1.BlueGreenStatus{ActiveColor: appsv1alpha1.ColorBlue, ActiveRevision: latest.Revision}
+		svc.Status.BlueGreen = status
+		r.recordBlueGreenEvent(svc, reqLogger, "Activated", "Switched traffic to revision %d in %s", status.ActiveRevision, status.ActiveColor)
+	}
+
+	// a rollback to the active spec aborts the preview
+	if active := svc.Status.Revisions.Find(status.ActiveRevision); active == nil || active.Checksum == latest.Checksum {
+		status.ActiveRevision = latest.Revision
+	}
+
+	if latest.Revision == status.ActiveRevision {
+		status.PreviewRevision = 0
+	} else if status.PreviewRevision != latest.Revision {
+		// the preview replaces the previously active revision, if it is still kept
+		status.PreviewRevision = latest.Revision
+		status.PreviousRevision = 0
+		r.recordBlueGreenEvent(svc, reqLogger, "PreviewStarted", "Rolling out revision %d to %s", status.PreviewRevision, status.ActiveColor.Other())
+	}
+
+	if status.PreviewRevision != 0 {
+		if err := r.promoteBlueGreen(svc, reqLogger); err != nil {
+			return 0, err
+		}
+	}
+
+	if status.PreviousRevision == 0 || status.PromotedAt == nil {
+		return 0, nil
+	}
+
+	remaining := time.Until(status.PromotedAt.Add(scaleDownDelay(svc)))
+	if remaining > 0 {
+		return remaining, nil
+	}
+
+	status.PreviousRevision = 0
+	return 0, nil
+}
+
+// promoteBlueGreen switches the traffic to the preview if all of its pods are ready and the promotion is either
+// automatic or requested by annotation
+func (r *ReconcileService) promoteBlueGreen(svc *appsv1alpha1.Service, reqLogger logr.Logger) error {
+	status := svc.Status.BlueGreen
+
+	_, promote := svc.Annotations[promoteAnnotation]
+	if !promote && (svc.Spec.BlueGreen == nil || !svc.Spec.BlueGreen.AutoPromote) {
+		return nil
+	}
+
+	// the annotation is kept until the preview is ready, so it can be set right after changing the spec
+	ready, err := r.colorDeploymentReady(svc, status.ActiveColor.Other(), status.PreviewRevision)
+	if err != nil || !ready {
+		return err
+	}
+
+	delete(svc.Annotations, promoteAnnotation)
+
+	now := metav1.Now()
+	status.PreviousRevision = status.ActiveRevision
+	status.ActiveRevision = status.PreviewRevision
+	status.ActiveColor = status.ActiveColor.Other()
+	status.PreviewRevision = 0
+	status.PromotedAt = &now
+
+	r.recordBlueGreenEvent(svc, reqLogger, "Promoted", "Switched traffic to revision %d in %s", status.ActiveRevision, status.ActiveColor)
+
+	return nil
+}
+
+// colorDeploymentReady returns whether the deployment of the color runs the revision and all of its pods are ready
+func (r *ReconcileService) colorDeploymentReady(svc *appsv1alpha1.Service, color appsv1alpha1.Color, revision int64) (bool, error) {
+	dep := &appsv1.Deployment{}
+	name := types.NamespacedName{Namespace: svc.Namespace, Name: colorName(svc, color)}
+	if err := r.client.Get(context.TODO(), name, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get %s deployment: %v", color, err)
+	}
+
+	return dep.Annotations[revisionAnnotation] == strconv.FormatInt(revision, 10) && deploymentComplete(dep), nil
+}
+
+func (r *ReconcileService) recordBlueGreenEvent(svc *appsv1alpha1.Service, reqLogger logr.Logger, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	reqLogger.Info(message)
+	r.recorder.Event(svc, corev1.EventTypeNormal, reason, message)
+}
+
+func scaleDownDelay(svc *appsv1alpha1.Service) time.Duration {
+	if svc.Spec.BlueGreen == nil || svc.Spec.BlueGreen.ScaleDownDelay == nil {
+		return defaultScaleDownDelay
+	}
+
+	return svc.Spec.BlueGreen.ScaleDownDelay.Duration
+}
+
+// ensureBlueGreen creates the deployment of the active color and, while a preview is in progress or the previously
+// active revision is kept, the deployment of the inactive color. The preview service and ingress are only created
+// during a preview.
+func (r *ReconcileService) ensureBlueGreen(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) ([]runtime.Object, error) {
+	status := svc.Status.BlueGreen
+	objects := make([]runtime.Object, 0)
+
+	// the first revision is rolled out to blue before any color is active
+	active, color := svc, appsv1alpha1.ColorBlue
+	if status != nil {
+		var err error
+		if active, err = r.serviceAtRevision(svc, status.ActiveRevision); err != nil {
+			return nil, err
+		}
+		color = status.ActiveColor
+	}
+
+	dep, err := r.newColorDeploymentForService(active, color, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, dep)
+
+	var inactiveRevision int64
+	if status != nil {
+		inactiveRevision = status.PreviewRevision
+		if inactiveRevision == 0 {
+			inactiveRevision = status.PreviousRevision
+		}
+	}
+
+	if inactiveRevision != 0 {
+		inactive, err := r.serviceAtRevision(svc, inactiveRevision)
+		if err != nil {
+			return nil, err
+		}
+
+		dep, err := r.newColorDeploymentForService(inactive, status.ActiveColor.Other(), dockerPullSecrets)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, dep)
+	}
+
+	if status != nil && status.PreviewRevision != 0 && len(svc.Spec.Ports) > 0 {
+		coreService, err := r.newPreviewServiceForService(svc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, coreService)
+
+		if svc.Spec.BlueGreen != nil && svc.Spec.BlueGreen.PreviewHost != "" {
+			ingress, err := r.newPreviewIngressForService(svc)
+			if err != nil {
+				return nil, err
+			}
+			objects = append(objects, ingress)
+		}
+	}
+
+	for _, obj := range objects {
+		meta := obj.(metav1.Object)
+		name := types.NamespacedName{Name: meta.GetName(), Namespace: meta.GetNamespace()}
+		if err := r.ensureObject(reqLogger, svc, obj, name); err != nil {
+			return nil, fmt.Errorf("failed to handle blue/green deployment: %v", err)
+		}
+	}
+
+	return objects, nil
+}
+
+func (b *objectBuilder) newColorDeploymentForService(svc *appsv1alpha1.Service, color appsv1alpha1.Color, dockerPullSecrets []*corev1.Secret) (*appsv1.Deployment, error) {
+	dep, err := b.newDeploymentForService(svc, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := b.makeColorLabels(svc, color)
+
+	dep.Name = colorName(svc, color)
+	dep.Labels = labels
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+
+	return dep, nil
+}
+
+func (b *objectBuilder) newPreviewServiceForService(svc *appsv1alpha1.Service) (*corev1.Service, error) {
+	coreService, err := b.newServiceForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	coreService.Name = previewName(svc)
+	coreService.Spec.Selector = b.makeColorLabels(svc, svc.Status.BlueGreen.ActiveColor.Other())
+
+	return coreService, nil
+}
+
+func (b *objectBuilder) newPreviewIngressForService(svc *appsv1alpha1.Service) (*networkingv1beta1.Ingress, error) {
+	host := svc.Spec.BlueGreen.PreviewHost
+
+	port := svc.Spec.Ports[0]
+	for _, p := range svc.Spec.Ports {
+		if p.Name == svc.Spec.BlueGreen.PreviewPort {
+			port = p
+		}
+	}
+
+	// the name is also used for the tls secret, so leave room for the suffix
+	name := names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength-len(tlsSecretSuffix), svc.Name, previewSuffix, host)
+
+	rules := b.makeIngressRules(svc, port, appsv1alpha1.PortIngress{Host: host})
+	for _, rule := range rules {
+		for i := range rule.HTTP.Paths {
+			rule.HTTP.Paths[i].Backend.ServiceName = previewName(svc)
+		}
+	}
+
+	ingress := &networkingv1beta1.Ingress{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1beta1.SchemeGroupVersion.String(),
+			Kind:       "Ingress",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      name,
+			Namespace: svc.Namespace,
+			Labels:    b.makeLabels(svc),
+		},
+		Spec: networkingv1beta1.IngressSpec{
+			Rules: rules,
+			TLS: []networkingv1beta1.IngressTLS{
+				{
+					Hosts:      []string{host},
+					SecretName: name + tlsSecretSuffix,
+				},
+			},
+		},
+	}
+
+	if len(config.Config.Ingress.Annotations) > 0 {
+		ingress.SetAnnotations(config.Config.Ingress.Annotations)
+	}
+
+	if err := controllerutil.SetControllerReference(svc, ingress, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return ingress, nil
+}
+
+// makeSelectorLabels returns the selector of the service, which only matches the pods of the stable track for canary
+// rollouts once they are rolled out and of the active color for blue/green rollouts once a color is active
+func (b *objectBuilder) makeSelectorLabels(svc *appsv1alpha1.Service) map[string]string {
+	switch rolloutStrategy(svc) {
+	case appsv1alpha1.StrategyBlueGreen:
+		if svc.Status.BlueGreen != nil {
+			return b.makeColorLabels(svc, svc.Status.BlueGreen.ActiveColor)
+		}
+	case appsv1alpha1.StrategyCanary:
+		if svc.Status.Canary == nil || !svc.Status.Canary.StableTracked {
+			return b.makeLabels(svc)
+		}
+	}
+
+	return b.makePodLabels(svc)
+}
+
+// rolloutDeploymentName returns the name of the deployment the latest revision is rolled out to
+func rolloutDeploymentName(svc *appsv1alpha1.Service) string {
+	status := svc.Status.BlueGreen
+	if rolloutStrategy(svc) != appsv1alpha1.StrategyBlueGreen {
+		return svc.Name
+	}
+
+	if status == nil {
+		return colorName(svc, appsv1alpha1.ColorBlue)
+	}
+
+	if status.PreviewRevision != 0 {
+		return colorName(svc, status.ActiveColor.Other())
+	}
+
+	return colorName(svc, status.ActiveColor)
+}
+
+func (b *objectBuilder) makeColorLabels(svc *appsv1alpha1.Service, color appsv1alpha1.Color) map[string]string {
+	return mergeLabels(b.makeLabels(svc), map[string]string{colorLabel: string(color)})
+}
+
+func colorName(svc *appsv1alpha1.Service, color appsv1alpha1.Color) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, string(color))
+}
+
+func previewName(svc *appsv1alpha1.Service) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, previewSuffix)
+}
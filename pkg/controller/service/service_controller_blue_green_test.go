@@ -0,0 +1,125 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_advanceBlueGreen(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+
+	green := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-green",
+			Namespace:   "default",
+			Annotations: map[string]string{revisionAnnotation: "2"},
+		},
+		Spec: appsv1.DeploymentSpec{Replicas: ptrThree},
+	}
+	c := fake.NewFakeClientWithScheme(s, green)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{}},
+		Spec:       appsv1alpha1.ServiceSpec{Strategy: appsv1alpha1.StrategyBlueGreen},
+		Status: appsv1alpha1.ServiceStatus{
+			Revisions: appsv1alpha1.RevisionList{{Revision: 1, Checksum: "one"}},
+		},
+	}
+
+	// the first revision is active once blue is ready, until then the service keeps its previous selector
+	if _, err := r.advanceBlueGreen(svc, log); err != nil {
+		t.Fatalf("advanceBlueGreen() error = %v", err)
+	}
+	if svc.Status.BlueGreen != nil {
+		t.Errorf("expected no active color before blue is ready, got %+v", svc.Status.BlueGreen)
+	}
+	if got, ok := r.makeSelectorLabels(svc)[colorLabel]; ok {
+		t.Errorf("selector color = %v, want none", got)
+	}
+	if got := rolloutDeploymentName(svc); got != "app-blue" {
+		t.Errorf("rolloutDeploymentName() = %v, want app-blue", got)
+	}
+
+	blue := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-blue",
+			Namespace:   "default",
+			Annotations: map[string]string{revisionAnnotation: "1"},
+			Generation:  1,
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: ptrThree},
+		Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+	}
+	if err := c.Create(context.TODO(), blue); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := r.advanceBlueGreen(svc, log); err != nil {
+		t.Fatalf("advanceBlueGreen() error = %v", err)
+	}
+	if got, want := *svc.Status.BlueGreen, (appsv1alpha1.BlueGreenStatus{ActiveColor: appsv1alpha1.ColorBlue, ActiveRevision: 1}); got != want {
+		t.Errorf("status after first revision = %+v, want %+v", got, want)
+	}
+	if got := r.makeSelectorLabels(svc)[colorLabel]; got != "blue" {
+		t.Errorf("selector color = %v, want blue", got)
+	}
+
+	// a new revision is previewed in green
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 2, Checksum: "two"})
+	svc.Annotations[promoteAnnotation] = "true"
+	if _, err := r.advanceBlueGreen(svc, log); err != nil {
+		t.Fatalf("advanceBlueGreen() error = %v", err)
+	}
+	if svc.Status.BlueGreen.PreviewRevision != 2 || svc.Status.BlueGreen.ActiveColor != appsv1alpha1.ColorBlue {
+		t.Errorf("expected preview of revision 2, got %+v", svc.Status.BlueGreen)
+	}
+	if got := rolloutDeploymentName(svc); got != "app-green" {
+		t.Errorf("rolloutDeploymentName() = %v, want app-green", got)
+	}
+	if _, ok := svc.Annotations[promoteAnnotation]; !ok {
+		t.Errorf("expected promote annotation to be kept until the preview is ready")
+	}
+
+	// green becomes ready and is promoted because of the annotation
+	green.Generation = 1
+	green.Status = appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+	r.client = fake.NewFakeClientWithScheme(s, green)
+
+	after, err := r.advanceBlueGreen(svc, log)
+	if err != nil {
+		t.Fatalf("advanceBlueGreen() error = %v", err)
+	}
+	if got, want := svc.Status.BlueGreen.ActiveColor, appsv1alpha1.ColorGreen; got != want {
+		t.Errorf("active color = %v, want %v", got, want)
+	}
+	if svc.Status.BlueGreen.ActiveRevision != 2 || svc.Status.BlueGreen.PreviousRevision != 1 {
+		t.Errorf("expected revision 2 to be active and 1 to be kept, got %+v", svc.Status.BlueGreen)
+	}
+	if after <= 0 || after > defaultScaleDownDelay {
+		t.Errorf("advanceBlueGreen() = %v, want requeue within %v", after, defaultScaleDownDelay)
+	}
+	if got := r.makeSelectorLabels(svc)[colorLabel]; got != "green" {
+		t.Errorf("selector color = %v, want green", got)
+	}
+
+	// the previously active revision is removed after the delay
+	svc.Status.BlueGreen.PromotedAt = &metav1.Time{Time: time.Now().Add(-defaultScaleDownDelay)}
+	if _, err := r.advanceBlueGreen(svc, log); err != nil {
+		t.Fatalf("advanceBlueGreen() error = %v", err)
+	}
+	if svc.Status.BlueGreen.PreviousRevision != 0 {
+		t.Errorf("expected previous revision to be removed, got %+v", svc.Status.BlueGreen)
+	}
+}
@@ -0,0 +1,355 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+var defaultCanarySteps = []int32{10, 50, 100}
+
+// advanceCanary moves the canary rollout of the latest revision forward. A step is only advanced while the canary
+// deployment runs all of its pods. It returns the duration after which the next step is due, zero if steps are only
+// advanced manually or no canary rollout is in progress.
+func (r *ReconcileService) advanceCanary(svc *appsv1alpha1.Service, reqLogger logr.Logger) (time.Duration, error) {
+	if rolloutStrategy(svc) != appsv1alpha1.StrategyCanary {
+		svc.Status.Canary = nil
+		return 0, nil
+	}
+
+	latest := svc.Status.Revisions.Latest()
+	if latest == nil {
+		return 0, nil
+	}
+
+	status := svc.Status.Canary
+	if status == nil {
+		// the first revision, and any revision applied before the strategy was switched to canary, is rolled out
+		// directly as there is nothing to compare the canary with
+		stable := svc.Status.LastReadyRevision
+		if stable == 0 || svc.Status.Revisions.Find(stable) == nil {
+			stable = latest.Revision
+		}
+
+		status = &appsv1alpha1.CanaryStatus{StableRevision: stable}
+		svc.Status.Canary = status
+	}
+
+	// a rollback to the stable spec aborts the canary rollout
+	if stable := svc.Status.Revisions.Find(status.StableRevision); stable == nil || stable.Checksum == latest.Checksum {
+		status.StableRevision = latest.Revision
+	}
+
+	if latest.Revision == status.StableRevision {
+		delete(svc.Annotations, canaryPromoteAnnotation)
+		status.CanaryRevision = 0
+		status.Step = 0
+		status.Weight = 0
+		status.StepStartedAt = nil
+		return 0, nil
+	}
+
+	steps := canarySteps(svc)
+	interval := canaryInterval(svc)
+	now := metav1.Now()
+
+	if status.CanaryRevision != latest.Revision {
+		delete(svc.Annotations, canaryPromoteAnnotation)
+		status.CanaryRevision = latest.Revision
+		status.Step = 0
+		status.Weight = steps[0]
+		status.StepStartedAt = &now
+
+		r.recordCanaryEvent(svc, reqLogger, "CanaryStarted", "Started canary of revision %d with %d%% of the traffic", status.CanaryRevision, status.Weight)
+		return interval, nil
+	}
+
+	// the canary deployment reports its progress, which triggers the next reconcile, so the step and a pending
+	// promotion are kept until it is available
+	available, err := r.canaryAvailable(svc, status.CanaryRevision)
+	if err != nil {
+		return 0, err
+	}
+	if !available {
+		return 0, nil
+	}
+
+	_, promote := svc.Annotations[canaryPromoteAnnotation]
+	delete(svc.Annotations, canaryPromoteAnnotation)
+
+	if !promote {
+		if interval == 0 {
+			return 0, nil
+		}
+
+		if remaining := time.Until(status.StepStartedAt.Add(interval)); remaining > 0 {
+			return remaining, nil
+		}
+	}
+
+	status.Step++
+	if int(status.Step) >= len(steps) {
+		r.recordCanaryEvent(svc, reqLogger, "CanaryPromoted", "Promoted canary of revision %d", status.CanaryRevision)
+
+		status.StableRevision = status.CanaryRevision
+		status.CanaryRevision = 0
+		status.Step = 0
+		status.Weight = 0
+		status.StepStartedAt = nil
+		return 0, nil
+	}
+
+	status.Weight = steps[status.Step]
+	status.StepStartedAt = &now
+
+	r.recordCanaryEvent(svc, reqLogger, "CanaryStepAdvanced", "Canary of revision %d receives %d%% of the traffic", status.CanaryRevision, status.Weight)
+	return interval, nil
+}
+
+// checkCanaryRollout inspects the canary deployment of the revision. A canary that exceeded its progress deadline is
+// handled like any failed rollout, the revision is only marked as ready once it is promoted to the stable deployment.
+func (r *ReconcileService) checkCanaryRollout(svc *appsv1alpha1.Service, revision int64, reqLogger logr.Logger) error {
+	dep, err := r.canaryDeployment(svc, revision)
+	if err != nil || dep == nil {
+		return err
+	}
+
+	switch {
+	case deploymentComplete(dep):
+		svc.Status.Conditions.Set(appsv1alpha1.Condition{
+			Type:   appsv1alpha1.ConditionDegraded,
+			Status: corev1.ConditionFalse,
+			Reason: "CanaryAvailable",
+		})
+
+	case deploymentFailed(dep):
+		return r.handleFailedRollout(svc, revision, reqLogger)
+	}
+
+	return nil
+}
+
+// canaryAvailable returns whether the canary deployment runs the revision and all of its pods are available
+func (r *ReconcileService) canaryAvailable(svc *appsv1alpha1.Service, revision int64) (bool, error) {
+	dep, err := r.canaryDeployment(svc, revision)
+	if err != nil || dep == nil {
+		return false, err
+	}
+
+	return deploymentComplete(dep), nil
+}
+
+// canaryDeployment returns the canary deployment, if it exists and runs the revision
+func (r *ReconcileService) canaryDeployment(svc *appsv1alpha1.Service, revision int64) (*appsv1.Deployment, error) {
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: canaryName(svc)}, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return nil, nil
+		}
+		return nil, fmt.Errorf("failed to get canary deployment: %v", err)
+	}
+
+	if dep.Annotations[revisionAnnotation] != strconv.FormatInt(revision, 10) {
+		return nil, nil
+	}
+
+	return dep, nil
+}
+
+func (r *ReconcileService) recordCanaryEvent(svc *appsv1alpha1.Service, reqLogger logr.Logger, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	reqLogger.Info(message)
+	r.recorder.Event(svc, corev1.EventTypeNormal, reason, message)
+}
+
+// canaryInProgress returns whether a canary deployment runs next to the stable one
+func canaryInProgress(svc *appsv1alpha1.Service) bool {
+	return rolloutStrategy(svc) == appsv1alpha1.StrategyCanary && svc.Status.Canary != nil && svc.Status.Canary.CanaryRevision != 0
+}
+
+func canarySteps(svc *appsv1alpha1.Service) []int32 {
+	if svc.Spec.Canary == nil || len(svc.Spec.Canary.Steps) == 0 {
+		return defaultCanarySteps
+	}
+
+	return svc.Spec.Canary.Steps
+}
+
+func canaryInterval(svc *appsv1alpha1.Service) time.Duration {
+	if svc.Spec.Canary == nil || svc.Spec.Canary.Interval == nil {
+		return 0
+	}
+
+	return svc.Spec.Canary.Interval.Duration
+}
+
+// trackStable marks the stable track once the deployment of the service rolled out its pods with the stable track
+// label. Until then the service selects all pods, so switching its selector never leaves it without endpoints.
+func (r *ReconcileService) trackStable(svc *appsv1alpha1.Service) error {
+	status := svc.Status.Canary
+	if rolloutStrategy(svc) != appsv1alpha1.StrategyCanary || status == nil || status.StableTracked {
+		return nil
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get stable deployment: %v", err)
+	}
+
+	status.StableTracked = dep.Spec.Template.Labels[trackLabel] == trackStable && deploymentComplete(dep)
+	return nil
+}
+
+// stableService returns the service as it was at the stable revision while a canary rollout is in progress, the
+// service itself otherwise
+func (r *ReconcileService) stableService(svc *appsv1alpha1.Service) (*appsv1alpha1.Service, error) {
+	if !canaryInProgress(svc) {
+		return svc, nil
+	}
+
+	return r.serviceAtRevision(svc, svc.Status.Canary.StableRevision)
+}
+
+// ensureCanary creates the deployment, service and ingresses of the canary while a canary rollout is in progress
+func (r *ReconcileService) ensureCanary(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) ([]runtime.Object, error) {
+	if !canaryInProgress(svc) {
+		return nil, nil
+	}
+
+	objects := make([]runtime.Object, 0)
+
+	dep, err := r.newCanaryDeploymentForService(svc, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+	objects = append(objects, dep)
+
+	if len(svc.Spec.Ports) > 0 {
+		coreService, err := r.newCanaryServiceForService(svc)
+		if err != nil {
+			return nil, err
+		}
+		objects = append(objects, coreService)
+
+		ingresses, err := r.newCanaryIngressesForService(svc)
+		if err != nil {
+			return nil, err
+		}
+		for _, ingress := range ingresses {
+			objects = append(objects, ingress)
+		}
+	}
+
+	for _, obj := range objects {
+		meta := obj.(metav1.Object)
+		name := types.NamespacedName{Name: meta.GetName(), Namespace: meta.GetNamespace()}
+		if err := r.ensureObject(reqLogger, svc, obj, name); err != nil {
+			return nil, fmt.Errorf("failed to handle canary: %v", err)
+		}
+	}
+
+	return objects, nil
+}
+
+func (b *objectBuilder) newCanaryDeploymentForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) (*appsv1.Deployment, error) {
+	dep, err := b.newDeploymentForService(svc, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	labels := b.makeCanaryLabels(svc)
+
+	dep.Name = canaryName(svc)
+	dep.Labels = labels
+	dep.Spec.Selector = &metav1.LabelSelector{MatchLabels: labels}
+	dep.Spec.Template.Labels = labels
+	dep.Spec.Replicas = ptrInt32(canaryReplicas(*dep.Spec.Replicas, svc.Status.Canary.Weight))
+
+	return dep, nil
+}
+
+// canaryReplicas returns the replicas of the canary deployment needed to serve its share of the traffic, at least one
+func canaryReplicas(replicas, weight int32) int32 {
+	canary := (replicas*weight + 99) / 100
+	if canary < 1 {
+		return 1
+	}
+
+	return canary
+}
+
+func (b *objectBuilder) newCanaryServiceForService(svc *appsv1alpha1.Service) (*corev1.Service, error) {
+	coreService, err := b.newServiceForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	coreService.Name = canaryName(svc)
+	coreService.Spec.Selector = b.makeCanaryLabels(svc)
+
+	return coreService, nil
+}
+
+// newCanaryIngressesForService creates an ingress for each ingress of the service, that sends the weight of the
+// current step to the canary. The tls config is left to the main ingress.
+func (b *objectBuilder) newCanaryIngressesForService(svc *appsv1alpha1.Service) ([]*networkingv1beta1.Ingress, error) {
+	ingresses, err := b.newIngressesForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ingress := range ingresses {
+		ingress.Name = names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, ingress.Name, canarySuffix)
+		ingress.Spec.TLS = nil
+
+		annotations := make(map[string]string, len(config.Config.Ingress.Annotations)+2)
+		for k, v := range config.Config.Ingress.Annotations {
+			annotations[k] = v
+		}
+		annotations[nginxCanaryAnnotation] = "true"
+		annotations[nginxCanaryWeightAnnotation] = strconv.Itoa(int(svc.Status.Canary.Weight))
+		ingress.SetAnnotations(annotations)
+
+		for _, rule := range ingress.Spec.Rules {
+			for i := range rule.HTTP.Paths {
+				rule.HTTP.Paths[i].Backend.ServiceName = canaryName(svc)
+			}
+		}
+	}
+
+	return ingresses, nil
+}
+
+// makePodLabels returns the labels of the pods of the deployment, which carry the stable track for canary rollouts
+func (b *objectBuilder) makePodLabels(svc *appsv1alpha1.Service) map[string]string {
+	if rolloutStrategy(svc) != appsv1alpha1.StrategyCanary {
+		return b.makeLabels(svc)
+	}
+
+	return mergeLabels(b.makeLabels(svc), map[string]string{trackLabel: trackStable})
+}
+
+func (b *objectBuilder) makeCanaryLabels(svc *appsv1alpha1.Service) map[string]string {
+	return mergeLabels(b.makeLabels(svc), map[string]string{trackLabel: trackCanary})
+}
+
+func canaryName(svc *appsv1alpha1.Service) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, canarySuffix)
+}
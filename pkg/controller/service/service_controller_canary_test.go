@@ -0,0 +1,349 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func newCanaryDeployment(revision string, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-canary",
+			Namespace:   "default",
+			Annotations: map[string]string{revisionAnnotation: revision},
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: ptrOne},
+		Status: status,
+	}
+}
+
+func TestReconcileService_advanceCanary(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	c := fake.NewFakeClientWithScheme(s)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Annotations: map[string]string{}},
+		Spec: appsv1alpha1.ServiceSpec{
+			Strategy: appsv1alpha1.StrategyCanary,
+			Canary:   &appsv1alpha1.CanaryStrategy{Steps: []int32{20, 100}},
+		},
+		Status: appsv1alpha1.ServiceStatus{
+			LastReadyRevision: 1,
+			Revisions: appsv1alpha1.RevisionList{
+				{Revision: 1, Checksum: "one"},
+				{Revision: 2, Checksum: "two"},
+			},
+		},
+	}
+
+	advance := func() time.Duration {
+		t.Helper()
+		next, err := r.advanceCanary(svc, log)
+		if err != nil {
+			t.Fatalf("advanceCanary() error = %v", err)
+		}
+		return next
+	}
+
+	if got := advance(); got != 0 {
+		t.Errorf("advanceCanary() = %v, want no requeue without interval", got)
+	}
+	if got, want := *svc.Status.Canary, (appsv1alpha1.CanaryStatus{StableRevision: 1, CanaryRevision: 2, Weight: 20, StepStartedAt: svc.Status.Canary.StepStartedAt}); got != want {
+		t.Errorf("status after start = %+v, want %+v", got, want)
+	}
+
+	// the canary is not available yet, so the promotion is kept until it is
+	svc.Annotations[canaryPromoteAnnotation] = "true"
+	advance()
+	if svc.Status.Canary.Step != 0 {
+		t.Errorf("expected step to be kept while the canary is unavailable, got %d", svc.Status.Canary.Step)
+	}
+	if _, ok := svc.Annotations[canaryPromoteAnnotation]; !ok {
+		t.Errorf("expected promote annotation to be kept")
+	}
+
+	canary := newCanaryDeployment("2", appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1})
+	if err := c.Create(context.TODO(), canary); err != nil {
+		t.Fatalf("failed to create canary deployment: %v", err)
+	}
+
+	advance()
+	if svc.Status.Canary.Step != 1 || svc.Status.Canary.Weight != 100 {
+		t.Errorf("expected second step with weight 100, got %+v", svc.Status.Canary)
+	}
+	if _, ok := svc.Annotations[canaryPromoteAnnotation]; ok {
+		t.Errorf("expected promote annotation to be removed")
+	}
+
+	// without interval and annotation the step is kept
+	advance()
+	if svc.Status.Canary.Step != 1 {
+		t.Errorf("expected step to be kept, got %d", svc.Status.Canary.Step)
+	}
+
+	// the interval elapsed, so the canary is promoted
+	svc.Spec.Canary.Interval = &metav1.Duration{Duration: time.Minute}
+	svc.Status.Canary.StepStartedAt = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+	advance()
+	if svc.Status.Canary.StableRevision != 2 || canaryInProgress(svc) {
+		t.Errorf("expected revision 2 to be promoted, got %+v", svc.Status.Canary)
+	}
+
+	// a new revision starts the next canary and requeues after the interval
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 3, Checksum: "three"})
+	if got := advance(); got != time.Minute {
+		t.Errorf("advanceCanary() = %v, want %v", got, time.Minute)
+	}
+
+	// the canary deployment still runs the previous revision, so the elapsed interval does not advance the step
+	svc.Status.Canary.StepStartedAt = &metav1.Time{Time: time.Now().Add(-2 * time.Minute)}
+	advance()
+	if svc.Status.Canary.Step != 0 {
+		t.Errorf("expected step to be kept for the new canary, got %d", svc.Status.Canary.Step)
+	}
+
+	// rolling back to the stable spec aborts the canary
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 4, Checksum: "two"})
+	advance()
+	if svc.Status.Canary.StableRevision != 4 || canaryInProgress(svc) {
+		t.Errorf("expected canary to be aborted, got %+v", svc.Status.Canary)
+	}
+}
+
+func TestReconcileService_checkRollout_canary(t *testing.T) {
+	failed := appsv1.DeploymentStatus{
+		Replicas:        1,
+		UpdatedReplicas: 1,
+		Conditions: []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: progressDeadlineExceeded},
+		},
+	}
+	available := appsv1.DeploymentStatus{Replicas: 1, UpdatedReplicas: 1, AvailableReplicas: 1}
+
+	tests := []struct {
+		name              string
+		autoRollback      bool
+		canary            *appsv1.Deployment
+		wantImage         string
+		wantDegraded      bool
+		wantDegradedCause string
+	}{
+		{
+			name:      "available canary keeps the last ready revision",
+			canary:    newCanaryDeployment("2", available),
+			wantImage: "app:2",
+		},
+		{
+			name:              "failed canary without auto rollback",
+			canary:            newCanaryDeployment("2", failed),
+			wantImage:         "app:2",
+			wantDegraded:      true,
+			wantDegradedCause: "ProgressDeadlineExceeded",
+		},
+		{
+			name:              "failed canary with auto rollback",
+			autoRollback:      true,
+			canary:            newCanaryDeployment("2", failed),
+			wantImage:         "app:1",
+			wantDegraded:      true,
+			wantDegradedCause: "RolledBack",
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			_ = scheme.AddToScheme(s)
+			_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1alpha1.ServiceSpec{Image: "app:2", Strategy: appsv1alpha1.StrategyCanary, AutoRollback: tt.autoRollback},
+				Status: appsv1alpha1.ServiceStatus{
+					LastReadyRevision: 1,
+					Revisions: appsv1alpha1.RevisionList{
+						{Revision: 1, Image: "app:1"},
+						{Revision: 2, Image: "app:2"},
+					},
+					Canary: &appsv1alpha1.CanaryStatus{StableRevision: 1, CanaryRevision: 2, Weight: 10},
+				},
+			}
+
+			// the stable deployment runs the last ready revision and is not inspected while the canary runs
+			stable := newRolloutDeployment("1", available)
+
+			c := fake.NewFakeClientWithScheme(s, svc.DeepCopy(), stable, tt.canary, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-revisions", Namespace: "default"},
+				Data:       map[string]string{"1": `{"image":"app:1","strategy":"canary","autoRollback":true}`},
+			})
+			r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+			if err := r.checkRollout(svc, log); err != nil {
+				t.Fatalf("checkRollout() error = %v", err)
+			}
+
+			if svc.Spec.Image != tt.wantImage {
+				t.Errorf("image = %v, want %v", svc.Spec.Image, tt.wantImage)
+			}
+			if svc.Status.LastReadyRevision != 1 {
+				t.Errorf("lastReadyRevision = %v, want 1", svc.Status.LastReadyRevision)
+			}
+			if got := svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionDegraded); got != tt.wantDegraded {
+				t.Errorf("degraded = %v, want %v", got, tt.wantDegraded)
+			}
+			if tt.wantDegraded {
+				if got := svc.Status.Conditions.Find(appsv1alpha1.ConditionDegraded).Reason; got != tt.wantDegradedCause {
+					t.Errorf("reason = %v, want %v", got, tt.wantDegradedCause)
+				}
+			}
+		})
+	}
+}
+
+func TestReconcileService_newCanaryIngressesForService(t *testing.T) {
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: scheme.Scheme}}
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(r.scheme)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Strategy: appsv1alpha1.StrategyCanary,
+			Ports: appsv1alpha1.PortList{
+				{Name: "http", Container: 8080, Service: 80, Ingresses: []appsv1alpha1.PortIngress{{Host: "app.example.com"}}},
+			},
+		},
+		Status: appsv1alpha1.ServiceStatus{Canary: &appsv1alpha1.CanaryStatus{StableRevision: 1, CanaryRevision: 2, Weight: 30}},
+	}
+
+	ingresses, err := r.newCanaryIngressesForService(svc)
+	if err != nil {
+		t.Fatalf("newCanaryIngressesForService() error = %v", err)
+	}
+	if len(ingresses) != 1 {
+		t.Fatalf("expected 1 ingress, got %d", len(ingresses))
+	}
+
+	ingress := ingresses[0]
+	if got, want := ingress.Name, "app-http-app-example-com-canary"; got != want {
+		t.Errorf("name = %v, want %v", got, want)
+	}
+	if got := ingress.Annotations[nginxCanaryWeightAnnotation]; got != "30" {
+		t.Errorf("weight = %v, want 30", got)
+	}
+	if len(ingress.Spec.TLS) != 0 {
+		t.Errorf("expected no tls config, got %v", ingress.Spec.TLS)
+	}
+	if got := ingress.Spec.Rules[0].HTTP.Paths[0].Backend.ServiceName; got != "app-canary" {
+		t.Errorf("backend = %v, want app-canary", got)
+	}
+}
+
+func TestReconcileService_trackStable(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	stable := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", Generation: 2},
+		Spec: appsv1.DeploymentSpec{
+			Replicas: ptrThree,
+			Template: corev1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: map[string]string{trackLabel: trackStable}},
+			},
+		},
+		// the labelled template is not rolled out yet
+		Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3},
+	}
+	c := fake.NewFakeClientWithScheme(s, stable)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1alpha1.ServiceSpec{Strategy: appsv1alpha1.StrategyCanary},
+		Status:     appsv1alpha1.ServiceStatus{Canary: &appsv1alpha1.CanaryStatus{StableRevision: 1}},
+	}
+
+	if err := r.trackStable(svc); err != nil {
+		t.Fatalf("trackStable() error = %v", err)
+	}
+	if _, ok := r.makeSelectorLabels(svc)[trackLabel]; ok || svc.Status.Canary.StableTracked {
+		t.Errorf("expected the service to select all pods until the stable track is rolled out, got %v", r.makeSelectorLabels(svc))
+	}
+
+	stable.Status.ObservedGeneration = 2
+	if err := c.Status().Update(context.TODO(), stable); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.trackStable(svc); err != nil {
+		t.Fatalf("trackStable() error = %v", err)
+	}
+	if got := r.makeSelectorLabels(svc)[trackLabel]; got != trackStable || !svc.Status.Canary.StableTracked {
+		t.Errorf("expected the service to select the stable track, got %v", r.makeSelectorLabels(svc))
+	}
+}
+
+func Test_canaryReplicas(t *testing.T) {
+	tests := []struct {
+		replicas, weight, want int32
+	}{
+		{replicas: 3, weight: 10, want: 1},
+		{replicas: 3, weight: 50, want: 2},
+		{replicas: 3, weight: 100, want: 3},
+		{replicas: 1, weight: 100, want: 1},
+		{replicas: 3, weight: 0, want: 1},
+	}
+	for _, tt := range tests {
+		if got := canaryReplicas(tt.replicas, tt.weight); got != tt.want {
+			t.Errorf("canaryReplicas(%d, %d) = %d, want %d", tt.replicas, tt.weight, got, tt.want)
+		}
+	}
+}
+
+func TestReconcileService_newCanaryDeploymentForService(t *testing.T) {
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: scheme.Scheme}}
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(r.scheme)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1alpha1.ServiceSpec{Strategy: appsv1alpha1.StrategyCanary},
+		Status:     appsv1alpha1.ServiceStatus{Canary: &appsv1alpha1.CanaryStatus{StableRevision: 1, CanaryRevision: 2, Weight: 50}},
+	}
+
+	stable, err := r.newDeploymentForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newDeploymentForService() error = %v", err)
+	}
+	canary, err := r.newCanaryDeploymentForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newCanaryDeploymentForService() error = %v", err)
+	}
+
+	stableSelector := labels.SelectorFromSet(stable.Spec.Selector.MatchLabels)
+	canarySelector := labels.SelectorFromSet(canary.Spec.Selector.MatchLabels)
+	if !stableSelector.Matches(labels.Set(stable.Spec.Template.Labels)) || stableSelector.Matches(labels.Set(canary.Spec.Template.Labels)) {
+		t.Errorf("expected the stable selector %v to only match the stable pods", stable.Spec.Selector.MatchLabels)
+	}
+	if !canarySelector.Matches(labels.Set(canary.Spec.Template.Labels)) || canarySelector.Matches(labels.Set(stable.Spec.Template.Labels)) {
+		t.Errorf("expected the canary selector %v to only match the canary pods", canary.Spec.Selector.MatchLabels)
+	}
+	if *canary.Spec.Replicas != 2 {
+		t.Errorf("canary replicas = %d, want 2", *canary.Spec.Replicas)
+	}
+}
@@ -14,6 +14,10 @@ import (
 )
 
 func (r *ReconcileService) ensureService(svc *appsv1alpha1.Service, reqLogger logr.Logger) (*corev1.Service, error) {
+	if err := r.trackStable(svc); err != nil {
+		return nil, err
+	}
+
 	coreService, err := r.newServiceForService(svc)
 	if err != nil {
 		return nil, err
@@ -27,8 +31,8 @@ func (r *ReconcileService) ensureService(svc *appsv1alpha1.Service, reqLogger lo
 	return coreService, nil
 }
 
-func (r *ReconcileService) newServiceForService(svc *appsv1alpha1.Service) (*corev1.Service, error) {
-	labels := r.makeLabels(svc)
+func (b *objectBuilder) newServiceForService(svc *appsv1alpha1.Service) (*corev1.Service, error) {
+	labels := b.makeLabels(svc)
 
 	coreService := &corev1.Service{
 		TypeMeta: metav1.TypeMeta{
@@ -42,16 +46,16 @@ func (r *ReconcileService) newServiceForService(svc *appsv1alpha1.Service) (*cor
 		},
 		Spec: corev1.ServiceSpec{
 			Ports:    svc.Spec.Ports.ToServicePorts(),
-			Selector: labels,
+			Selector: b.makeSelectorLabels(svc),
 			Type:     corev1.ServiceTypeClusterIP,
 		},
 	}
 
 	if len(config.Config.CoreService.Annotations) > 0 {
-		coreService.SetAnnotations(config.Config.Ingress.Annotations)
+		coreService.SetAnnotations(config.Config.CoreService.Annotations)
 	}
 
-	if err := controllerutil.SetControllerReference(svc, coreService, r.scheme); err != nil {
+	if err := controllerutil.SetControllerReference(svc, coreService, b.scheme); err != nil {
 		return nil, err
 	}
 
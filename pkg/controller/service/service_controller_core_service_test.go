@@ -0,0 +1,46 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_newServiceForService_annotations(t *testing.T) {
+	defer func(cfg config.RootConfig) { config.Config = cfg }(config.Config)
+	config.Config.CoreService.Annotations = map[string]string{"service.example.com/internal": "true"}
+	config.Config.Ingress.Annotations = map[string]string{"kubernetes.io/ingress.class": "nginx"}
+	config.Config.Deployment.Annotations = map[string]string{"deployment.example.com/owner": "team"}
+
+	b := &objectBuilder{scheme: scheme.Scheme}
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(b.scheme)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "app:1.0",
+			Ports: appsv1alpha1.PortList{{Name: "http", Container: 8080, Service: 80}},
+		},
+	}
+
+	coreService, err := b.newServiceForService(svc)
+	if err != nil {
+		t.Fatalf("newServiceForService() error = %v", err)
+	}
+	if got, want := coreService.Annotations, config.Config.CoreService.Annotations; !reflect.DeepEqual(got, want) {
+		t.Errorf("service annotations = %v, want %v", got, want)
+	}
+
+	dep, err := b.newDeploymentForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newDeploymentForService() error = %v", err)
+	}
+	if got, want := dep.Annotations, config.Config.Deployment.Annotations; !reflect.DeepEqual(got, want) {
+		t.Errorf("deployment annotations = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+// isCronJob returns whether the service runs on a schedule
+func isCronJob(svc *appsv1alpha1.Service) bool {
+	return svc.Spec.Schedule != ""
+}
+
+func (r *ReconcileService) ensureCronJob(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) (*batchv1beta1.CronJob, error) {
+	cronJob, err := r.newCronJobForService(svc, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	name := types.NamespacedName{Name: cronJob.Name, Namespace: cronJob.Namespace}
+	if err := r.ensureObject(reqLogger, svc, cronJob, name); err != nil {
+		return nil, fmt.Errorf("failed to handle cron job: %v", err)
+	}
+
+	return cronJob, nil
+}
+
+func (b *objectBuilder) newCronJobForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) (*batchv1beta1.CronJob, error) {
+	labels := b.makeLabels(svc)
+	options := svc.Spec.CronJob
+	if options == nil {
+		options = &appsv1alpha1.CronJobOptions{}
+	}
+
+	concurrencyPolicy := options.ConcurrencyPolicy
+	if concurrencyPolicy == "" {
+		concurrencyPolicy = batchv1beta1.ForbidConcurrent
+	}
+
+	cronJob := &batchv1beta1.CronJob{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1beta1.SchemeGroupVersion.String(),
+			Kind:       "CronJob",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    labels,
+		},
+		Spec: batchv1beta1.CronJobSpec{
+			Schedule:                   svc.Spec.Schedule,
+			ConcurrencyPolicy:          concurrencyPolicy,
+			Suspend:                    &options.Suspend,
+			StartingDeadlineSeconds:    options.StartingDeadlineSeconds,
+			SuccessfulJobsHistoryLimit: options.SuccessfulJobsHistoryLimit,
+			FailedJobsHistoryLimit:     options.FailedJobsHistoryLimit,
+			JobTemplate: batchv1beta1.JobTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{
+					Labels: labels,
+				},
+				Spec: b.newJobSpecForService(svc, dockerPullSecrets),
+			},
+		},
+	}
+
+	if cronJob.Spec.SuccessfulJobsHistoryLimit == nil {
+		cronJob.Spec.SuccessfulJobsHistoryLimit = ptrInt32(3)
+	}
+	if cronJob.Spec.FailedJobsHistoryLimit == nil {
+		cronJob.Spec.FailedJobsHistoryLimit = ptrInt32(1)
+	}
+
+	if annotations := makeWorkloadAnnotations(svc); len(annotations) > 0 {
+		cronJob.SetAnnotations(annotations)
+	}
+
+	if err := controllerutil.SetControllerReference(svc, cronJob, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return cronJob, nil
+}
+
+// newJobSpecForService creates the spec of a job running the pod template of the service to completion
+func (b *objectBuilder) newJobSpecForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) batchv1.JobSpec {
+	template := b.newPodTemplateForService(svc, dockerPullSecrets)
+	template.Spec.RestartPolicy = corev1.RestartPolicyOnFailure
+
+	spec := batchv1.JobSpec{Template: template}
+	if svc.Spec.CronJob != nil {
+		spec.BackoffLimit = svc.Spec.CronJob.BackoffLimit
+	}
+
+	return spec
+}
+
+// triggerJob creates a one-off job from the cron job if the service is annotated with the trigger annotation. The
+// annotation is removed afterwards.
+func (r *ReconcileService) triggerJob(svc *appsv1alpha1.Service, cronJob *batchv1beta1.CronJob, reqLogger logr.Logger) error {
+	value, ok := svc.Annotations[triggerAnnotation]
+	if !ok {
+		return nil
+	}
+
+	job, err := r.newTriggeredJobForService(svc, cronJob, value)
+	if err != nil {
+		return err
+	}
+
+	// the job name is derived from the annotation, so a job is never created twice for the same trigger
+	if err := r.client.Create(context.TODO(), job); err != nil && !errors.IsAlreadyExists(err) {
+		return fmt.Errorf("failed to create triggered job: %v", err)
+	}
+
+	delete(svc.Annotations, triggerAnnotation)
+
+	message := fmt.Sprintf("Created job %s", job.Name)
+	reqLogger.Info(message)
+	r.recorder.Event(svc, corev1.EventTypeNormal, "Triggered", message)
+
+	return nil
+}
+
+func (b *objectBuilder) newTriggeredJobForService(svc *appsv1alpha1.Service, cronJob *batchv1beta1.CronJob, trigger string) (*batchv1.Job, error) {
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, "manual", strings.ToLower(trigger)),
+			Namespace:   svc.Namespace,
+			Labels:      cronJob.Spec.JobTemplate.Labels,
+			Annotations: map[string]string{"cronjob.kubernetes.io/instantiate": "manual"},
+		},
+		Spec: *cronJob.Spec.JobTemplate.Spec.DeepCopy(),
+	}
+
+	if err := controllerutil.SetControllerReference(svc, job, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
@@ -0,0 +1,96 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	batchv1beta1 "k8s.io/api/batch/v1beta1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_newCronJobForService(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "report", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image:    "report:1.0",
+			Schedule: "0 3 * * *",
+			CronJob:  &appsv1alpha1.CronJobOptions{Suspend: true, BackoffLimit: ptrInt32(2)},
+		},
+	}
+
+	cronJob, err := r.newCronJobForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newCronJobForService() error = %v", err)
+	}
+
+	if cronJob.Spec.Schedule != "0 3 * * *" || cronJob.Spec.ConcurrencyPolicy != batchv1beta1.ForbidConcurrent {
+		t.Errorf("unexpected schedule %q and concurrency policy %q", cronJob.Spec.Schedule, cronJob.Spec.ConcurrencyPolicy)
+	}
+	if !*cronJob.Spec.Suspend || *cronJob.Spec.SuccessfulJobsHistoryLimit != 3 || *cronJob.Spec.FailedJobsHistoryLimit != 1 {
+		t.Errorf("unexpected suspend and history limits in %+v", cronJob.Spec)
+	}
+
+	jobSpec := cronJob.Spec.JobTemplate.Spec
+	if jobSpec.Template.Spec.RestartPolicy != corev1.RestartPolicyOnFailure || *jobSpec.BackoffLimit != 2 {
+		t.Errorf("unexpected job spec %+v", jobSpec)
+	}
+	if got := jobSpec.Template.Spec.Containers[0].Image; got != "report:1.0" {
+		t.Errorf("image = %v, want report:1.0", got)
+	}
+}
+
+func TestReconcileService_triggerJob(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	c := fake.NewFakeClientWithScheme(s)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "report",
+			Namespace:   "default",
+			Annotations: map[string]string{triggerAnnotation: "1600000000"},
+		},
+		Spec: appsv1alpha1.ServiceSpec{Image: "report:1.0", Schedule: "0 3 * * *"},
+	}
+
+	cronJob, err := r.newCronJobForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newCronJobForService() error = %v", err)
+	}
+
+	for i := 0; i < 2; i++ {
+		svc.Annotations[triggerAnnotation] = "1600000000"
+		if err := r.triggerJob(svc, cronJob, log); err != nil {
+			t.Fatalf("triggerJob() error = %v", err)
+		}
+	}
+
+	if _, ok := svc.Annotations[triggerAnnotation]; ok {
+		t.Errorf("expected trigger annotation to be removed")
+	}
+
+	job := &batchv1.Job{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "report-manual-1600000000", Namespace: "default"}, job); err != nil {
+		t.Fatalf("expected triggered job to be created: %v", err)
+	}
+	if len(job.OwnerReferences) != 1 {
+		t.Errorf("expected job to be owned by the service, got %v", job.OwnerReferences)
+	}
+}
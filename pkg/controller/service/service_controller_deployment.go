@@ -1,22 +1,31 @@
 package service
 
 import (
+	"context"
 	"fmt"
+	"strconv"
 
 	"github.com/go-logr/logr"
 	appsv1 "k8s.io/api/apps/v1"
 	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
 
 	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
 	"github.com/kubelix/deployer/pkg/config"
-	"github.com/kubelix/deployer/pkg/names"
 )
 
 func (r *ReconcileService) ensureDeployment(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) (*appsv1.Deployment, error) {
-	dep, err := r.newDeploymentForService(svc, dockerPullSecrets)
+	// while a canary rollout is in progress the deployment keeps running the stable revision
+	stable, err := r.stableService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	dep, err := r.newDeploymentForService(stable, dockerPullSecrets)
 	if err != nil {
 		return nil, err
 	}
@@ -29,9 +38,41 @@ func (r *ReconcileService) ensureDeployment(svc *appsv1alpha1.Service, dockerPul
 	return dep, nil
 }
 
-func (r *ReconcileService) newDeploymentForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) (*appsv1.Deployment, error) {
-	labels := r.makeLabels(svc)
-	filesConfigMapName := names.FormatDashFromParts(svc.Name, "mounted-files")
+// cleanupOrphanedReplicaSets removes the replica sets left behind by deployments recreated with a different selector.
+// Their pods keep serving until the workload replacing them runs the latest revision on all replicas.
+func (r *ReconcileService) cleanupOrphanedReplicaSets(svc *appsv1alpha1.Service, reqLogger logr.Logger) error {
+	list := &appsv1.ReplicaSetList{}
+	if err := r.client.List(context.TODO(), list, client.InNamespace(svc.Namespace), client.MatchingLabels(r.makeKubelixLabels(svc))); err != nil {
+		return fmt.Errorf("failed to list replica sets: %v", err)
+	}
+
+	orphaned := make([]*appsv1.ReplicaSet, 0)
+	for i := range list.Items {
+		if metav1.GetControllerOf(&list.Items[i]) == nil {
+			orphaned = append(orphaned, &list.Items[i])
+		}
+	}
+	if len(orphaned) == 0 {
+		return nil
+	}
+
+	ready, err := r.workloadReady(svc)
+	if err != nil || !ready {
+		return err
+	}
+
+	for _, rs := range orphaned {
+		reqLogger.Info(fmt.Sprintf("deleting orphaned replica set %s", rs.Name))
+		if err := r.client.Delete(context.TODO(), rs, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete orphaned replica set %s: %v", rs.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (b *objectBuilder) newDeploymentForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) (*appsv1.Deployment, error) {
+	labels := b.makeLabels(svc)
 
 	dep := &appsv1.Deployment{
 		TypeMeta: metav1.TypeMeta{
@@ -44,83 +85,16 @@ func (r *ReconcileService) newDeploymentForService(svc *appsv1alpha1.Service, do
 			Labels:    labels,
 		},
 		Spec: appsv1.DeploymentSpec{
-			RevisionHistoryLimit: ptrInt32(3),
+			RevisionHistoryLimit:    ptrInt32(3),
+			ProgressDeadlineSeconds: svc.Spec.ProgressDeadlineSeconds,
 			Selector: &metav1.LabelSelector{
-				MatchLabels: labels,
-			},
-			Template: corev1.PodTemplateSpec{
-				ObjectMeta: metav1.ObjectMeta{
-					Labels: labels,
-				},
-				Spec: corev1.PodSpec{
-					ServiceAccountName: svc.Spec.ServiceAccountName,
-					Affinity: &corev1.Affinity{
-						PodAntiAffinity: &corev1.PodAntiAffinity{
-							PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
-								{
-									Weight: 100,
-									PodAffinityTerm: corev1.PodAffinityTerm{
-										TopologyKey: "kubernetes.io/hostname",
-										LabelSelector: &metav1.LabelSelector{
-											MatchLabels: r.makeKubelixLabels(svc),
-										},
-									},
-								},
-							},
-						},
-					},
-					TerminationGracePeriodSeconds: ptrInt64(30),
-					ImagePullSecrets:              secretsToReferences(dockerPullSecrets),
-					Containers: []corev1.Container{
-						{
-							ImagePullPolicy: corev1.PullAlways,
-							Name:            svc.Name,
-							Image:           svc.Spec.Image,
-							Command:         svc.Spec.Command,
-							Args:            svc.Spec.Args,
-							Env:             svc.Spec.Env.ToEnvVars(),
-							Resources:       svc.Spec.Resources,
-							Ports:           svc.Spec.Ports.ToPodPorts(),
-							VolumeMounts:    filesToVolumeMounts(svc),
-
-							/**
-							livenessProbe:
-							  failureThreshold: 3
-							  httpGet:
-								path: /healthz
-								port: app
-								scheme: HTTP
-							  periodSeconds: 10
-							  successThreshold: 1
-							  timeoutSeconds: 1
-							readinessProbe:
-							  failureThreshold: 3
-							  httpGet:
-								path: /healthz
-								port: app
-								scheme: HTTP
-							  periodSeconds: 10
-							  successThreshold: 1
-							  timeoutSeconds: 1
-							*/
-						},
-					},
-					Volumes: []corev1.Volume{
-						{
-							Name: "files",
-							VolumeSource: corev1.VolumeSource{
-								ConfigMap: &corev1.ConfigMapVolumeSource{
-									LocalObjectReference: corev1.LocalObjectReference{
-										Name: filesConfigMapName,
-									},
-								},
-							},
-						},
-					},
-				},
+				// the stable track keeps the selector apart from the canary deployment
+				MatchLabels: mergeLabels(b.makePodLabels(svc), map[string]string{workloadLabel: workloadDeployment}),
 			},
+			Template: b.newPodTemplateForService(svc, dockerPullSecrets),
 		},
 	}
+	dep.Spec.Template.Labels = mergeLabels(dep.Spec.Template.Labels, map[string]string{workloadLabel: workloadDeployment})
 
 	if svc.Spec.Singleton {
 		dep.Spec.Replicas = ptrOne
@@ -130,17 +104,110 @@ func (r *ReconcileService) newDeploymentForService(svc *appsv1alpha1.Service, do
 		dep.Spec.Strategy.Type = appsv1.RollingUpdateDeploymentStrategyType
 	}
 
-	if len(config.Config.Deployment.Annotations) > 0 {
-		dep.SetAnnotations(config.Config.Ingress.Annotations)
+	if annotations := makeWorkloadAnnotations(svc); len(annotations) > 0 {
+		dep.SetAnnotations(annotations)
 	}
 
-	if err := controllerutil.SetControllerReference(svc, dep, r.scheme); err != nil {
+	if err := controllerutil.SetControllerReference(svc, dep, b.scheme); err != nil {
 		return nil, err
 	}
 
 	return dep, nil
 }
 
+// makeWorkloadAnnotations returns the configured annotations of workloads together with the revision they run
+func makeWorkloadAnnotations(svc *appsv1alpha1.Service) map[string]string {
+	annotations := make(map[string]string, len(config.Config.Deployment.Annotations)+1)
+	for k, v := range config.Config.Deployment.Annotations {
+		annotations[k] = v
+	}
+	if latest := svc.Status.Revisions.Latest(); latest != nil {
+		annotations[revisionAnnotation] = strconv.FormatInt(latest.Revision, 10)
+	}
+
+	return annotations
+}
+
+// newPodTemplateForService creates the pod template shared by all workloads of the service
+func (b *objectBuilder) newPodTemplateForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) corev1.PodTemplateSpec {
+	securityContext := makeSecurityContext(svc)
+
+	return corev1.PodTemplateSpec{
+		ObjectMeta: metav1.ObjectMeta{
+			Labels:      b.makePodLabels(svc),
+			Annotations: b.makePodAnnotations(svc, securityContext),
+		},
+		Spec: corev1.PodSpec{
+			ServiceAccountName:            serviceAccountName(svc),
+			SecurityContext:               podSecurityContext(securityContext),
+			Affinity:                      b.makeAffinity(svc),
+			NodeSelector:                  makeNodeSelector(svc),
+			Tolerations:                   makeTolerations(svc),
+			TopologySpreadConstraints:     b.makeTopologySpreadConstraints(svc),
+			PriorityClassName:             priorityClassName(svc),
+			TerminationGracePeriodSeconds: ptrInt64(30),
+			ImagePullSecrets:              secretsToReferences(dockerPullSecrets),
+			Containers: []corev1.Container{
+				{
+					ImagePullPolicy: imagePullPolicy(svc),
+					Name:            svc.Name,
+					Image:           serviceImage(svc),
+					Command:         svc.Spec.Command,
+					Args:            svc.Spec.Args,
+					Env:             svc.Spec.Env.ToEnvVars(),
+					Resources:       svc.Spec.Resources,
+					Ports:           svc.Spec.Ports.ToPodPorts(),
+					VolumeMounts:    append(filesToVolumeMounts(svc), volumesToVolumeMounts(svc)...),
+					SecurityContext: containerSecurityContext(securityContext),
+
+					/**
+					livenessProbe:
+					  failureThreshold: 3
+					  httpGet:
+						path: /healthz
+						port: app
+						scheme: HTTP
+					  periodSeconds: 10
+					  successThreshold: 1
+					  timeoutSeconds: 1
+					readinessProbe:
+					  failureThreshold: 3
+					  httpGet:
+						path: /healthz
+						port: app
+						scheme: HTTP
+					  periodSeconds: 10
+					  successThreshold: 1
+					  timeoutSeconds: 1
+					*/
+				},
+			},
+			Volumes: append([]corev1.Volume{
+				{
+					Name: "files",
+					VolumeSource: corev1.VolumeSource{
+						ConfigMap: &corev1.ConfigMapVolumeSource{
+							LocalObjectReference: corev1.LocalObjectReference{
+								Name: filesConfigMapName(svc),
+							},
+						},
+					},
+				},
+			}, volumesToPodVolumes(svc)...),
+		},
+	}
+}
+
+// makePodAnnotations returns the annotations of the pod template, nil if there are none
+func (b *objectBuilder) makePodAnnotations(svc *appsv1alpha1.Service, securityContext appsv1alpha1.SecurityContext) map[string]string {
+	annotations := mergeLabels(makeSeccompAnnotations(securityContext), b.makeScrapeAnnotations(svc))
+	if len(annotations) == 0 {
+		return nil
+	}
+
+	return annotations
+}
+
 func filesToVolumeMounts(svc *appsv1alpha1.Service) []corev1.VolumeMount {
 	volumeMounts := make([]corev1.VolumeMount, 0)
 
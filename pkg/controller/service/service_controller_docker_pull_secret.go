@@ -1,41 +1,91 @@
 package service
 
 import (
+	"context"
 	"encoding/base64"
 	"fmt"
+	"sort"
+	"time"
 
 	"github.com/go-logr/logr"
 	corev1 "k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
 	"k8s.io/apimachinery/pkg/types"
+	coreinformers "k8s.io/client-go/informers/core/v1"
+	"k8s.io/client-go/kubernetes"
+	toolscache "k8s.io/client-go/tools/cache"
+	"sigs.k8s.io/controller-runtime/pkg/client"
 	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+	"sigs.k8s.io/controller-runtime/pkg/handler"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
 
 	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
 	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/credentials"
 	"github.com/kubelix/deployer/pkg/names"
+	"github.com/kubelix/deployer/pkg/registry"
 )
 
-func (r *ReconcileService) ensureDockerPullSecrets(svc *appsv1alpha1.Service, reqLogger logr.Logger) ([]*corev1.Secret, error) {
-	secrets, err := r.newDockerPullSecretsForService(svc)
+// dockerConfig is the resolved content of a kubernetes.io/dockerconfigjson secret for a single registry
+type dockerConfig struct {
+	Registry string
+	Content  string
+
+	// Auth holds the credentials for requests to the registry API
+	Auth registry.Auth
+
+	// ExpiresAt is the point in time the credentials become invalid; the zero value means they never expire
+	ExpiresAt time.Time
+}
+
+// ensureDockerPullSecrets creates the docker pull secrets of the service and returns them together with the point
+// in time the first of them expires, if any
+func (r *ReconcileService) ensureDockerPullSecrets(svc *appsv1alpha1.Service, configs []dockerConfig, reqLogger logr.Logger) ([]*corev1.Secret, time.Time, error) {
+	secrets, err := r.newDockerPullSecretsForService(svc, configs)
 	if err != nil {
-		return nil, err
+		return nil, time.Time{}, err
 	}
 
 	for _, secret := range secrets {
 		depName := types.NamespacedName{Name: secret.Name, Namespace: secret.Namespace}
 		if err := r.ensureObject(reqLogger, svc, secret, depName); err != nil {
-			return nil, fmt.Errorf("failed to handle secret: %v", err)
+			return nil, time.Time{}, fmt.Errorf("failed to handle secret: %v", err)
 		}
 	}
-	return secrets, nil
+
+	return secrets, earliestExpiry(configs), nil
 }
 
-func (r *ReconcileService) newDockerPullSecretsForService(svc *appsv1alpha1.Service) ([]*corev1.Secret, error) {
-	labels := r.makeLabels(svc)
+func earliestExpiry(configs []dockerConfig) time.Time {
+	var expiresAt time.Time
+
+	for _, cfg := range configs {
+		if cfg.ExpiresAt.IsZero() {
+			continue
+		}
+		if expiresAt.IsZero() || cfg.ExpiresAt.Before(expiresAt) {
+			expiresAt = cfg.ExpiresAt
+		}
+	}
+
+	return expiresAt
+}
+
+func (b *objectBuilder) newDockerPullSecretsForService(svc *appsv1alpha1.Service, configs []dockerConfig) ([]*corev1.Secret, error) {
+	shared := config.Config.DockerPullSecretScope == config.DockerPullSecretScopeNamespace
+	labels := b.makeLabels(svc)
+	if shared {
+		labels = b.makeSharedLabels(svc)
+	}
+
 	secrets := make([]*corev1.Secret, 0)
 
-	for _, reg := range config.Config.DockerPullSecretes {
-		name := names.FormatDashFromParts(svc.Name, "docker-pull", reg.Registry)
+	for _, cfg := range configs {
+		name := names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, svc.Name, "docker-pull", cfg.Registry)
+		if shared {
+			name = names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, "kubelix-docker-pull", cfg.Registry)
+		}
 
 		secret := &corev1.Secret{
 			TypeMeta: metav1.TypeMeta{
@@ -49,11 +99,13 @@ func (r *ReconcileService) newDockerPullSecretsForService(svc *appsv1alpha1.Serv
 			},
 			Type: corev1.SecretTypeDockerConfigJson,
 			StringData: map[string]string{
-				corev1.DockerConfigJsonKey: formatDockerPullSecret(reg.Registry, reg.Username, reg.Password),
+				corev1.DockerConfigJsonKey: cfg.Content,
 			},
 		}
 
-		if err := controllerutil.SetControllerReference(svc, secret, r.scheme); err != nil {
+		if shared {
+			secret.SetOwnerReferences([]metav1.OwnerReference{newOwnerReference(svc)})
+		} else if err := controllerutil.SetControllerReference(svc, secret, b.scheme); err != nil {
 			return nil, err
 		}
 
@@ -63,7 +115,278 @@ func (r *ReconcileService) newDockerPullSecretsForService(svc *appsv1alpha1.Serv
 	return secrets, nil
 }
 
+// loadDockerConfigs resolves the credentials of all registries the service pulls images from and which are allowed
+// in the namespace of the service, reading referenced secrets from the operator namespace where necessary
+func (r *ReconcileService) loadDockerConfigs(svc *appsv1alpha1.Service) ([]dockerConfig, error) {
+	configs := make([]dockerConfig, 0)
+
+	namespaceLabels, err := r.loadNamespaceLabels(svc.Namespace)
+	if err != nil {
+		return nil, err
+	}
+
+	for index, reg := range config.Config.DockerPullSecretes {
+		if !dockerPullSecretMatchesService(reg, svc, namespaceLabels) {
+			continue
+		}
+
+		cfg, err := r.loadDockerConfig(index, reg)
+		if err != nil {
+			return nil, fmt.Errorf("failed to load docker pull secret for registry %s: %v", reg.Registry, err)
+		}
+
+		configs = append(configs, *cfg)
+	}
+
+	return configs, nil
+}
+
+// loadNamespaceLabels reads the labels of the given namespace, if any configured registry needs them for matching
+func (r *ReconcileService) loadNamespaceLabels(name string) (map[string]string, error) {
+	needsLabels := false
+	for _, reg := range config.Config.DockerPullSecretes {
+		if reg.NamespaceSelector != nil {
+			needsLabels = true
+			break
+		}
+	}
+
+	if !needsLabels {
+		return nil, nil
+	}
+
+	namespace := &corev1.Namespace{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Name: name}, namespace); err != nil {
+		return nil, fmt.Errorf("failed to get namespace %s: %v", name, err)
+	}
+
+	return namespace.Labels, nil
+}
+
+// dockerPullSecretMatchesService returns true when the service runs an image from the registry and the
+// credentials are allowed to be used in its namespace. The host of the image has to equal the registry, e.g.
+// gitlab.com does not match registry.gitlab.com. With the matching mode all, only the namespace is checked.
+func dockerPullSecretMatchesService(reg config.DockerPullSecret, svc *appsv1alpha1.Service, namespaceLabels map[string]string) bool {
+	if !reg.AllowsNamespace(svc.Namespace, namespaceLabels) {
+		return false
+	}
+
+	if config.Config.DockerPullSecretMatching == config.DockerPullSecretMatchingAll {
+		return true
+	}
+
+	for _, image := range serviceImages(svc) {
+		if registry.MatchesHost(image, reg.Registry) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// serviceImages returns all images the pods of the service are running. Pods run a single container, hooks and
+// cron jobs run the image of the service as well.
+func serviceImages(svc *appsv1alpha1.Service) []string {
+	return []string{svc.Spec.Image}
+}
+
+func (r *ReconcileService) loadDockerConfig(index int, reg config.DockerPullSecret) (*dockerConfig, error) {
+	if reg.SecretRef != nil {
+		secret, err := r.getOperatorSecret(reg.SecretRef.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		if secret.Type != corev1.SecretTypeDockerConfigJson {
+			return nil, fmt.Errorf("secret %s has type %s, expected %s", secret.Name, secret.Type, corev1.SecretTypeDockerConfigJson)
+		}
+
+		content, ok := secret.Data[corev1.DockerConfigJsonKey]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key %s", secret.Name, corev1.DockerConfigJsonKey)
+		}
+
+		auth, err := registry.AuthFromDockerConfig(content, reg.Registry)
+		if err != nil {
+			return nil, fmt.Errorf("secret %s: %v", secret.Name, err)
+		}
+
+		return &dockerConfig{Registry: reg.Registry, Content: string(content), Auth: auth}, nil
+	}
+
+	provider, err := r.credentialProvider(index, reg)
+	if err != nil {
+		return nil, err
+	}
+
+	credential, err := provider.Credential(context.TODO())
+	if err != nil {
+		return nil, err
+	}
+
+	return &dockerConfig{
+		Registry:  reg.Registry,
+		Content:   formatDockerPullSecret(reg.Registry, credential.Username, credential.Password),
+		Auth:      registry.Auth{Username: credential.Username, Password: credential.Password},
+		ExpiresAt: credential.ExpiresAt,
+	}, nil
+}
+
+// credentialProvider returns the provider for the registry at the given index of the config. Providers of static
+// and plugin credentials are kept for the lifetime of the operator, so fetched tokens are cached until they expire.
+func (r *ReconcileService) credentialProvider(index int, reg config.DockerPullSecret) (credentials.Provider, error) {
+	if reg.PasswordSecretRef != nil {
+		// the password is read on every call so changes of the secret are picked up
+		secret, err := r.getOperatorSecret(reg.PasswordSecretRef.Name)
+		if err != nil {
+			return nil, err
+		}
+
+		password, ok := secret.Data[reg.PasswordSecretRef.Key]
+		if !ok {
+			return nil, fmt.Errorf("secret %s has no key %s", secret.Name, reg.PasswordSecretRef.Key)
+		}
+
+		return credentials.NewStaticProvider(reg.Username, string(password)), nil
+	}
+
+	if r.credentials == nil {
+		return newCredentialProvider(reg), nil
+	}
+
+	r.credentials.Lock()
+	defer r.credentials.Unlock()
+
+	if r.credentials.providers == nil {
+		r.credentials.providers = make(map[int]credentials.Provider)
+	}
+
+	if provider, ok := r.credentials.providers[index]; ok {
+		return provider, nil
+	}
+
+	provider := newCredentialProvider(reg)
+	r.credentials.providers[index] = provider
+
+	return provider, nil
+}
+
+func newCredentialProvider(reg config.DockerPullSecret) credentials.Provider {
+	if reg.Exec == nil {
+		return credentials.NewStaticProvider(reg.Username, reg.Password)
+	}
+
+	return credentials.NewCachingProvider(credentials.NewExecProvider(credentials.ExecOptions{
+		Registry: reg.Registry,
+		Username: reg.Username,
+		Command:  reg.Exec.Command,
+		Args:     reg.Exec.Args,
+		Env:      reg.Exec.Env,
+		Timeout:  time.Duration(reg.Exec.TimeoutSeconds) * time.Second,
+	}))
+}
+
+func (r *ReconcileService) getOperatorSecret(name string) (*corev1.Secret, error) {
+	if config.Env.OperatorNamespace == "" {
+		return nil, fmt.Errorf("OPERATOR_NAMESPACE must be set to read secret %s", name)
+	}
+
+	secret := &corev1.Secret{}
+	key := types.NamespacedName{Namespace: config.Env.OperatorNamespace, Name: name}
+	if err := r.client.Get(context.TODO(), key, secret); err != nil {
+		return nil, fmt.Errorf("failed to get secret %s: %v", key, err)
+	}
+
+	return secret, nil
+}
+
 func formatDockerPullSecret(registry, username, password string) string {
 	auth := base64.StdEncoding.EncodeToString([]byte(fmt.Sprintf("%v:%v", username, password)))
 	return fmt.Sprintf(dockerConfigContent, registry, auth)
 }
+
+// referencesDockerPullSecret returns true when the given object is a secret used as source for docker pull
+// credentials
+func referencesDockerPullSecret(meta metav1.Object) bool {
+	if config.Env.OperatorNamespace == "" || meta.GetNamespace() != config.Env.OperatorNamespace {
+		return false
+	}
+
+	for _, reg := range config.Config.DockerPullSecretes {
+		if reg.ReferencesSecret(meta.GetName()) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// dockerPullSecretSources returns the names of the secrets in the operator namespace the credentials of docker pull
+// secrets are read from
+func dockerPullSecretSources() []string {
+	if config.Env.OperatorNamespace == "" {
+		return nil
+	}
+
+	seen := map[string]bool{}
+	names := make([]string, 0)
+	for _, reg := range config.Config.DockerPullSecretes {
+		for _, name := range []string{secretRefName(reg.SecretRef), secretKeyRefName(reg.PasswordSecretRef)} {
+			if name != "" && !seen[name] {
+				seen[name] = true
+				names = append(names, name)
+			}
+		}
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+func secretRefName(ref *config.SecretRef) string {
+	if ref == nil {
+		return ""
+	}
+
+	return ref.Name
+}
+
+func secretKeyRefName(ref *config.SecretKeyRef) string {
+	if ref == nil {
+		return ""
+	}
+
+	return ref.Name
+}
+
+// newOperatorSecretInformer watches the secret with the given name in the operator namespace only
+func newOperatorSecretInformer(clientset kubernetes.Interface, name string) toolscache.SharedIndexInformer {
+	return coreinformers.NewFilteredSecretInformer(clientset, config.Env.OperatorNamespace, 0, toolscache.Indexers{}, func(opts *metav1.ListOptions) {
+		opts.FieldSelector = fields.OneTermEqualSelector("metadata.name", name).String()
+	})
+}
+
+// newDockerPullSecretMapper enqueues all services whenever a secret referenced as docker pull credentials changes,
+// so rotated credentials are propagated to all namespaces
+func newDockerPullSecretMapper(c client.Client) handler.ToRequestsFunc {
+	return func(obj handler.MapObject) []reconcile.Request {
+		if !referencesDockerPullSecret(obj.Meta) {
+			return nil
+		}
+
+		services := &appsv1alpha1.ServiceList{}
+		if err := c.List(context.TODO(), services); err != nil {
+			log.Error(err, "failed to list services for changed docker pull secret", "Secret.Name", obj.Meta.GetName())
+			return nil
+		}
+
+		requests := make([]reconcile.Request, 0, len(services.Items))
+		for _, svc := range services.Items {
+			requests = append(requests, reconcile.Request{
+				NamespacedName: types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name},
+			})
+		}
+
+		return requests
+	}
+}
@@ -0,0 +1,197 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/scheme"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_loadDockerConfig(t *testing.T) {
+	config.Env.OperatorNamespace = "deployer"
+	defer func() { config.Env.OperatorNamespace = "" }()
+
+	c := fake.NewFakeClientWithScheme(scheme.Scheme,
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "password", Namespace: "deployer"},
+			Data:       map[string][]byte{"password": []byte("secret")},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "dockerconfig", Namespace: "deployer"},
+			Type:       corev1.SecretTypeDockerConfigJson,
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)},
+		},
+		&corev1.Secret{
+			ObjectMeta: metav1.ObjectMeta{Name: "opaque", Namespace: "deployer"},
+			Data:       map[string][]byte{corev1.DockerConfigJsonKey: []byte(`{"auths": {}}`)},
+		},
+	)
+
+	tests := []struct {
+		name    string
+		give    config.DockerPullSecret
+		want    string
+		wantErr bool
+	}{
+		{
+			name: "plain",
+			give: config.DockerPullSecret{Registry: "gitlab.com", Username: "user", Password: "secret"},
+			want: formatDockerPullSecret("gitlab.com", "user", "secret"),
+		},
+		{
+			name: "password_secret_ref",
+			give: config.DockerPullSecret{
+				Registry:          "gitlab.com",
+				Username:          "user",
+				PasswordSecretRef: &config.SecretKeyRef{Name: "password", Key: "password"},
+			},
+			want: formatDockerPullSecret("gitlab.com", "user", "secret"),
+		},
+		{
+			name: "password_secret_ref_missing_key",
+			give: config.DockerPullSecret{
+				Registry:          "gitlab.com",
+				Username:          "user",
+				PasswordSecretRef: &config.SecretKeyRef{Name: "password", Key: "missing"},
+			},
+			wantErr: true,
+		},
+		{
+			name: "secret_ref",
+			give: config.DockerPullSecret{Registry: "gitlab.com", SecretRef: &config.SecretRef{Name: "dockerconfig"}},
+			want: `{"auths": {}}`,
+		},
+		{
+			name:    "secret_ref_wrong_type",
+			give:    config.DockerPullSecret{Registry: "gitlab.com", SecretRef: &config.SecretRef{Name: "opaque"}},
+			wantErr: true,
+		},
+		{
+			name:    "secret_ref_not_found",
+			give:    config.DockerPullSecret{Registry: "gitlab.com", SecretRef: &config.SecretRef{Name: "missing"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: scheme.Scheme}}
+			got, err := r.loadDockerConfig(0, tt.give)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("loadDockerConfig() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err == nil && got.Content != tt.want {
+				t.Errorf("loadDockerConfig() got = %v, want %v", got.Content, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dockerPullSecretMatchesService(t *testing.T) {
+	defer func(matching config.DockerPullSecretMatching) {
+		config.Config.DockerPullSecretMatching = matching
+	}(config.Config.DockerPullSecretMatching)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "test", Namespace: "team-a"},
+		Spec:       appsv1alpha1.ServiceSpec{Image: "registry.gitlab.com/team-a/app:1.0"},
+	}
+
+	tests := []struct {
+		name            string
+		give            config.DockerPullSecret
+		matching        config.DockerPullSecretMatching
+		namespaceLabels map[string]string
+		want            bool
+	}{
+		{
+			name: "registry_matches",
+			give: config.DockerPullSecret{Registry: "registry.gitlab.com"},
+			want: true,
+		},
+		{
+			name: "registry_differs",
+			give: config.DockerPullSecret{Registry: "quay.io"},
+			want: false,
+		},
+		{
+			name: "parent_domain_differs",
+			give: config.DockerPullSecret{Registry: "gitlab.com"},
+			want: false,
+		},
+		{
+			name:     "all_ignores_registry",
+			give:     config.DockerPullSecret{Registry: "quay.io"},
+			matching: config.DockerPullSecretMatchingAll,
+			want:     true,
+		},
+		{
+			name:     "all_checks_namespace",
+			give:     config.DockerPullSecret{Registry: "quay.io", Namespaces: []string{"team-b"}},
+			matching: config.DockerPullSecretMatchingAll,
+			want:     false,
+		},
+		{
+			name: "namespace_allowed",
+			give: config.DockerPullSecret{Registry: "registry.gitlab.com", Namespaces: []string{"team-b", "team-a"}},
+			want: true,
+		},
+		{
+			name: "namespace_not_allowed",
+			give: config.DockerPullSecret{Registry: "registry.gitlab.com", Namespaces: []string{"team-b"}},
+			want: false,
+		},
+		{
+			name: "selector_matches",
+			give: config.DockerPullSecret{
+				Registry:          "registry.gitlab.com",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "a"}},
+			},
+			namespaceLabels: map[string]string{"team": "a"},
+			want:            true,
+		},
+		{
+			name: "selector_differs",
+			give: config.DockerPullSecret{
+				Registry:          "registry.gitlab.com",
+				NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"team": "b"}},
+			},
+			namespaceLabels: map[string]string{"team": "a"},
+			want:            false,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			config.Config.DockerPullSecretMatching = tt.matching
+			if got := dockerPullSecretMatchesService(tt.give, svc, tt.namespaceLabels); got != tt.want {
+				t.Errorf("dockerPullSecretMatchesService() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func Test_dockerPullSecretSources(t *testing.T) {
+	defer func(cfg config.RootConfig, ns string) { config.Config, config.Env.OperatorNamespace = cfg, ns }(config.Config, config.Env.OperatorNamespace)
+
+	config.Config.DockerPullSecretes = []config.DockerPullSecret{
+		{Registry: "gitlab.com", Username: "user", Password: "secret"},
+		{Registry: "quay.io", SecretRef: &config.SecretRef{Name: "quay"}},
+		{Registry: "registry.example.com", PasswordSecretRef: &config.SecretKeyRef{Name: "credentials", Key: "a"}},
+		{Registry: "registry.example.org", PasswordSecretRef: &config.SecretKeyRef{Name: "credentials", Key: "b"}},
+	}
+
+	if got := dockerPullSecretSources(); len(got) != 0 {
+		t.Errorf("dockerPullSecretSources() = %v, want none without operator namespace", got)
+	}
+
+	config.Env.OperatorNamespace = "deployer"
+	if got, want := dockerPullSecretSources(), []string{"credentials", "quay"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("dockerPullSecretSources() = %v, want %v", got, want)
+	}
+}
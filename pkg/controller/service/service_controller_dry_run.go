@@ -0,0 +1,250 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	jsonpatch "github.com/evanphx/json-patch"
+	"github.com/go-logr/logr"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/apiutil"
+	"sigs.k8s.io/controller-runtime/pkg/reconcile"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+const (
+	dryRunCreate = "create"
+	dryRunUpdate = "update"
+	dryRunDelete = "delete"
+)
+
+// isDryRun returns whether the changes of the service are only reported, either because of the --dry-run flag or
+// the dry-run annotation of the service
+func isDryRun(svc *appsv1alpha1.Service) bool {
+	return config.DryRun || svc.Annotations[appsv1alpha1.DryRunAnnotation] == "true"
+}
+
+// reconcileDryRun runs a reconcile of a copy of the service against a client that only records writes. The changes
+// are logged and reported in the status of the service, which is the only object written.
+func (r *ReconcileService) reconcileDryRun(svc *appsv1alpha1.Service, reqLogger logr.Logger) (reconcile.Result, error) {
+	dryRunLogger := reqLogger.WithValues("DryRun", true)
+	recorder := &dryRunClient{Client: r.client, scheme: r.scheme}
+
+	dryRun := &ReconcileService{
+		client:        recorder,
+		recorder:      &dryRunEventRecorder{logger: dryRunLogger},
+		registry:      r.registry,
+		objectBuilder: r.objectBuilder,
+		credentials:   r.credentials,
+		dryRun:        true,
+	}
+
+	result, err := dryRun.reconcile(svc.DeepCopy(), dryRunLogger)
+	if err != nil {
+		return result, err
+	}
+
+	for _, change := range recorder.changes {
+		dryRunLogger.Info("Dry run found change",
+			"Change.Action", change.Action,
+			"Change.Kind", change.Kind,
+			"Change.Name", change.Name,
+			"Change.Diff", change.Diff,
+		)
+	}
+
+	// the time of the first dry run finding the changes is kept, otherwise each status update would trigger the
+	// next reconcile
+	if svc.Status.DryRun != nil && reflect.DeepEqual(svc.Status.DryRun.Changes, recorder.changes) {
+		return result, nil
+	}
+
+	svc.Status.DryRun = &appsv1alpha1.DryRunStatus{
+		Time:    metav1.Now(),
+		Changes: recorder.changes,
+	}
+	if err := r.client.Status().Update(context.TODO(), svc); err != nil {
+		return reconcile.Result{}, fmt.Errorf("failed to update status: %v", err)
+	}
+
+	return result, nil
+}
+
+// dryRunClient reads from the wrapped client and records all writes instead of applying them. Status updates are
+// ignored.
+type dryRunClient struct {
+	client.Client
+	scheme  *runtime.Scheme
+	changes []appsv1alpha1.DryRunChange
+}
+
+// blank assignment to verify that dryRunClient implements client.Client
+var _ client.Client = &dryRunClient{}
+
+func (c *dryRunClient) Create(ctx context.Context, obj runtime.Object, opts ...client.CreateOption) error {
+	return c.record(obj, dryRunCreate, nil)
+}
+
+func (c *dryRunClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	key, err := client.ObjectKeyFromObject(obj)
+	if err != nil {
+		return err
+	}
+
+	live := obj.DeepCopyObject()
+	if err := c.Client.Get(ctx, key, live); err != nil {
+		if errors.IsNotFound(err) {
+			return c.record(obj, dryRunCreate, nil)
+		}
+		return err
+	}
+
+	return c.record(obj, dryRunUpdate, live)
+}
+
+func (c *dryRunClient) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return c.record(obj, dryRunUpdate, nil)
+}
+
+func (c *dryRunClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	return c.record(obj, dryRunDelete, nil)
+}
+
+func (c *dryRunClient) DeleteAllOf(ctx context.Context, obj runtime.Object, opts ...client.DeleteAllOfOption) error {
+	return c.record(obj, dryRunDelete, nil)
+}
+
+func (c *dryRunClient) Status() client.StatusWriter {
+	return dryRunStatusWriter{}
+}
+
+// record adds the change of the object. The diff of created objects contains the whole object, updates only contain
+// the fields that differ from the live object.
+func (c *dryRunClient) record(obj runtime.Object, action string, live runtime.Object) error {
+	gvk, err := apiutil.GVKForObject(obj, c.scheme)
+	if err != nil {
+		return err
+	}
+
+	change := appsv1alpha1.DryRunChange{
+		APIVersion: gvk.GroupVersion().String(),
+		Kind:       gvk.Kind,
+		Action:     action,
+	}
+	if meta, ok := obj.(metav1.Object); ok {
+		change.Name = meta.GetName()
+	}
+
+	if action != dryRunDelete {
+		if live != nil {
+			// objects read from the cache do not carry their type
+			live.GetObjectKind().SetGroupVersionKind(gvk)
+		}
+
+		diff, err := dryRunDiff(live, obj)
+		if err != nil {
+			return fmt.Errorf("failed to diff %s %s: %v", gvk.Kind, change.Name, err)
+		}
+
+		// the checksum may differ although the object is up to date, e.g. after an update of the deployer
+		if action == dryRunUpdate && diff == "" {
+			return nil
+		}
+		change.Diff = diff
+	}
+
+	c.changes = append(c.changes, change)
+	return nil
+}
+
+// dryRunDiff creates a json merge patch from the live to the desired object and removes all deletions from it. The
+// desired objects never contain the fields populated by the API server, which would show up as deletions otherwise.
+func dryRunDiff(live, desired runtime.Object) (string, error) {
+	desiredJSON, err := json.Marshal(desired)
+	if err != nil {
+		return "", err
+	}
+
+	liveJSON := []byte("{}")
+	if live != nil {
+		if liveJSON, err = json.Marshal(live); err != nil {
+			return "", err
+		}
+	}
+
+	patch, err := jsonpatch.CreateMergePatch(liveJSON, desiredJSON)
+	if err != nil {
+		return "", err
+	}
+
+	var fields map[string]interface{}
+	if err := json.Unmarshal(patch, &fields); err != nil {
+		return "", err
+	}
+	removeDeletions(fields)
+
+	if len(fields) == 0 {
+		return "", nil
+	}
+
+	diff, err := json.Marshal(fields)
+	if err != nil {
+		return "", err
+	}
+
+	return string(diff), nil
+}
+
+// removeDeletions removes all null values and objects left empty from the merge patch
+func removeDeletions(fields map[string]interface{}) {
+	for key, value := range fields {
+		switch v := value.(type) {
+		case nil:
+			delete(fields, key)
+		case map[string]interface{}:
+			removeDeletions(v)
+			if len(v) == 0 {
+				delete(fields, key)
+			}
+		}
+	}
+}
+
+// dryRunEventRecorder logs events instead of emitting them
+type dryRunEventRecorder struct {
+	logger logr.Logger
+}
+
+func (e *dryRunEventRecorder) Event(object runtime.Object, eventtype, reason, message string) {
+	e.logger.Info("Dry run suppressed event", "Event.Type", eventtype, "Event.Reason", reason, "Event.Message", message)
+}
+
+func (e *dryRunEventRecorder) Eventf(object runtime.Object, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Event(object, eventtype, reason, fmt.Sprintf(messageFmt, args...))
+}
+
+func (e *dryRunEventRecorder) PastEventf(object runtime.Object, timestamp metav1.Time, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+func (e *dryRunEventRecorder) AnnotatedEventf(object runtime.Object, annotations map[string]string, eventtype, reason, messageFmt string, args ...interface{}) {
+	e.Eventf(object, eventtype, reason, messageFmt, args...)
+}
+
+// dryRunStatusWriter ignores all status updates
+type dryRunStatusWriter struct{}
+
+func (dryRunStatusWriter) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	return nil
+}
+
+func (dryRunStatusWriter) Patch(ctx context.Context, obj runtime.Object, patch client.Patch, opts ...client.PatchOption) error {
+	return nil
+}
@@ -0,0 +1,165 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_reconcileDryRun(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "shop",
+			Namespace:   "default",
+			Annotations: map[string]string{appsv1alpha1.DryRunAnnotation: "true"},
+		},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "shop:1.0",
+			Ports: appsv1alpha1.PortList{{Name: "http", Container: 8080}},
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	if !isDryRun(svc) {
+		t.Fatal("expected the annotation to enable the dry run")
+	}
+
+	if _, err := r.reconcileDryRun(svc, log); err != nil {
+		t.Fatalf("reconcileDryRun() error = %v", err)
+	}
+
+	deployment := &appsv1.Deployment{}
+	err := c.Get(context.TODO(), types.NamespacedName{Name: "shop", Namespace: "default"}, deployment)
+	if !errors.IsNotFound(err) {
+		t.Fatalf("expected no deployment to be created, got %v", err)
+	}
+
+	updated := &appsv1alpha1.Service{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop", Namespace: "default"}, updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.DryRun == nil {
+		t.Fatal("expected the changes to be reported in the status")
+	}
+	if len(updated.Status.ManagedObjects) != 0 || len(updated.Status.Revisions) != 0 {
+		t.Errorf("expected the status to be left untouched apart from the dry run, got %+v", updated.Status)
+	}
+
+	found := make(map[string]appsv1alpha1.DryRunChange)
+	for _, change := range updated.Status.DryRun.Changes {
+		found[change.Kind] = change
+	}
+	for _, kind := range []string{"Deployment", "Service", "ConfigMap"} {
+		change, ok := found[kind]
+		if !ok {
+			t.Errorf("expected a change of the %s, got %+v", kind, updated.Status.DryRun.Changes)
+			continue
+		}
+		if change.Action != dryRunCreate || change.Diff == "" {
+			t.Errorf("expected the %s to be created, got %+v", kind, change)
+		}
+	}
+
+	// an unchanged result keeps the time of the first dry run
+	reported := updated.Status.DryRun.Time
+	if _, err := r.reconcileDryRun(updated, log); err != nil {
+		t.Fatalf("reconcileDryRun() error = %v", err)
+	}
+	if !updated.Status.DryRun.Time.Equal(&reported) {
+		t.Errorf("expected the status to be kept, got %v, want %v", updated.Status.DryRun.Time, reported)
+	}
+}
+
+func TestReconcileService_reconcileDryRun_preDeployHook(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "shop",
+			Namespace:   "default",
+			Annotations: map[string]string{appsv1alpha1.DryRunAnnotation: "true"},
+		},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "shop:1.0",
+			Hooks: &appsv1alpha1.Hooks{
+				PreDeploy: &appsv1alpha1.Hook{Command: []string{"migrate"}},
+			},
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	if _, err := r.reconcileDryRun(svc, log); err != nil {
+		t.Fatalf("reconcileDryRun() error = %v", err)
+	}
+
+	updated := &appsv1alpha1.Service{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop", Namespace: "default"}, updated); err != nil {
+		t.Fatal(err)
+	}
+	if updated.Status.DryRun == nil {
+		t.Fatal("expected the changes to be reported in the status")
+	}
+
+	// the hook would hold back the deployment, which is reported nevertheless
+	found := make(map[string]appsv1alpha1.DryRunChange)
+	for _, change := range updated.Status.DryRun.Changes {
+		found[change.Kind] = change
+	}
+	for _, kind := range []string{"Job", "Deployment"} {
+		if change, ok := found[kind]; !ok || change.Action != dryRunCreate {
+			t.Errorf("expected the %s to be created, got %+v", kind, updated.Status.DryRun.Changes)
+		}
+	}
+}
+
+func Test_dryRunDiff(t *testing.T) {
+	live := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "shop",
+			ResourceVersion: "42",
+			Labels:          map[string]string{"app": "shop"},
+		},
+		Data: map[string]string{"a": "1", "b": "2"},
+	}
+
+	desired := &corev1.ConfigMap{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "v1", Kind: "ConfigMap"},
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Labels: map[string]string{"app": "shop"}},
+		Data:       map[string]string{"a": "1", "b": "3"},
+	}
+
+	diff, err := dryRunDiff(live, desired)
+	if err != nil {
+		t.Fatalf("dryRunDiff() error = %v", err)
+	}
+	if want := `{"data":{"b":"3"}}`; diff != want {
+		t.Errorf("dryRunDiff() = %s, want %s", diff, want)
+	}
+
+	diff, err = dryRunDiff(live, live)
+	if err != nil || diff != "" {
+		t.Errorf("dryRunDiff() = %q, %v, want no diff", diff, err)
+	}
+}
@@ -27,9 +27,9 @@ func (r *ReconcileService) ensureFilesConfigMap(svc *appsv1alpha1.Service, reqLo
 	return config, nil
 }
 
-func (r *ReconcileService) newFilesConfigMapForService(svc *appsv1alpha1.Service) (*corev1.ConfigMap, error) {
-	labels := r.makeLabels(svc)
-	name := names.FormatDashFromParts(svc.Name, "mounted-files")
+func (b *objectBuilder) newFilesConfigMapForService(svc *appsv1alpha1.Service) (*corev1.ConfigMap, error) {
+	labels := b.makeLabels(svc)
+	name := filesConfigMapName(svc)
 
 	config := &corev1.ConfigMap{
 		TypeMeta: metav1.TypeMeta{
@@ -44,13 +44,13 @@ func (r *ReconcileService) newFilesConfigMapForService(svc *appsv1alpha1.Service
 		Data: map[string]string{},
 	}
 
-	if err := controllerutil.SetControllerReference(svc, config, r.scheme); err != nil {
+	if err := controllerutil.SetControllerReference(svc, config, b.scheme); err != nil {
 		return nil, err
 	}
 
 	for _, file := range svc.Spec.Files {
 		if _, ok := config.Data[file.Name]; ok {
-			return nil, fmt.Errorf("each file needs to have a unique name")
+			return nil, validationFailed(svc, "files", fmt.Errorf("each file needs to have a unique name"))
 		}
 
 		config.Data[file.Name] = file.Content
@@ -58,3 +58,7 @@ func (r *ReconcileService) newFilesConfigMapForService(svc *appsv1alpha1.Service
 
 	return config, nil
 }
+
+func filesConfigMapName(svc *appsv1alpha1.Service) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, svc.Name, "mounted-files")
+}
@@ -0,0 +1,223 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+// ensurePreDeployHook runs the pre-deploy hook of the latest revision. It returns whether the hook succeeded, so the
+// workloads may be updated to the latest revision. The hook only runs again when its pod changes, e.g. with a new
+// image or environment, not for revisions that only change replicas or the rollout. Jobs of finished hooks are removed.
+func (r *ReconcileService) ensurePreDeployHook(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) (bool, error) {
+	latest := svc.Status.Revisions.Latest()
+	if preDeployHook(svc) == nil || latest == nil {
+		return true, r.cleanupHookJobs(svc)
+	}
+
+	job, err := r.newPreDeployJobForService(svc, dockerPullSecrets, latest.Revision)
+	if err != nil {
+		return false, err
+	}
+	hookChecksum := job.Annotations[hookChecksumAnnotation]
+
+	// the hook already succeeded for the revision, or for the same pod when only e.g. the replicas or the rollout
+	// strategy changed
+	if svc.Status.PreDeployRevision == latest.Revision || svc.Status.PreDeployChecksum == hookChecksum {
+		svc.Status.PreDeployRevision = latest.Revision
+		svc.Status.PreDeployChecksum = hookChecksum
+		return true, r.cleanupHookJobs(svc)
+	}
+
+	found, err := r.findHookJob(svc, hookChecksum)
+	if err != nil {
+		return false, err
+	}
+
+	if found == nil {
+		// hooks of revisions that were replaced before they finished are not needed anymore
+		if err := r.cleanupHookJobs(svc); err != nil {
+			return false, err
+		}
+
+		if err := r.client.Create(context.TODO(), job); err != nil {
+			return false, fmt.Errorf("failed to create pre-deploy job: %v", err)
+		}
+
+		r.recordHookEvent(svc, reqLogger, corev1.EventTypeNormal, "PreDeployStarted", "Started pre-deploy job %s for revision %d", job.Name, latest.Revision)
+		return false, nil
+	}
+
+	if found.Status.Succeeded > 0 {
+		svc.Status.PreDeployRevision = latest.Revision
+		svc.Status.PreDeployChecksum = hookChecksum
+		svc.Status.Conditions.Set(appsv1alpha1.Condition{
+			Type:   appsv1alpha1.ConditionPreDeployFailed,
+			Status: corev1.ConditionFalse,
+			Reason: "Succeeded",
+		})
+
+		r.recordHookEvent(svc, reqLogger, corev1.EventTypeNormal, "PreDeploySucceeded", "Pre-deploy job %s for revision %d succeeded", found.Name, latest.Revision)
+		return true, r.cleanupHookJobs(svc)
+	}
+
+	if condition := jobFailedCondition(found); condition != nil {
+		// failed jobs are kept for inspection until the pod of the hook changes
+		changed := svc.Status.Conditions.Set(appsv1alpha1.Condition{
+			Type:    appsv1alpha1.ConditionPreDeployFailed,
+			Status:  corev1.ConditionTrue,
+			Reason:  condition.Reason,
+			Message: fmt.Sprintf("Pre-deploy job %s for revision %d failed: %s", found.Name, latest.Revision, condition.Message),
+		})
+		if changed {
+			r.recordHookEvent(svc, reqLogger, corev1.EventTypeWarning, "PreDeployFailed", "Pre-deploy job %s for revision %d failed: %s", found.Name, latest.Revision, condition.Message)
+		}
+	}
+
+	return false, nil
+}
+
+// findHookJob returns the pre-deploy job running the pod with the checksum, nil if there is none. A job started for an
+// earlier revision is reused as long as the pod of the hook did not change.
+func (r *ReconcileService) findHookJob(svc *appsv1alpha1.Service, hookChecksum string) (*batchv1.Job, error) {
+	jobs, err := r.listHookJobs(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	for i := range jobs.Items {
+		if jobs.Items[i].Annotations[hookChecksumAnnotation] == hookChecksum {
+			return &jobs.Items[i], nil
+		}
+	}
+
+	return nil, nil
+}
+
+func (r *ReconcileService) recordHookEvent(svc *appsv1alpha1.Service, reqLogger logr.Logger, eventType, reason, messageFmt string, args ...interface{}) {
+	message := fmt.Sprintf(messageFmt, args...)
+	reqLogger.Info(message)
+	r.recorder.Event(svc, eventType, reason, message)
+}
+
+// preDeployHook returns the pre-deploy hook of the service, nil if there is none. Scheduled services have no rollouts,
+// so they never run hooks.
+func preDeployHook(svc *appsv1alpha1.Service) *appsv1alpha1.Hook {
+	if svc.Spec.Hooks == nil || isCronJob(svc) {
+		return nil
+	}
+
+	return svc.Spec.Hooks.PreDeploy
+}
+
+func (b *objectBuilder) newPreDeployJobForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, revision int64) (*batchv1.Job, error) {
+	hook := preDeployHook(svc)
+	labels := b.makeHookLabels(svc, hookPreDeploy)
+
+	template := b.newPodTemplateForService(svc, dockerPullSecrets)
+	template.Labels = labels
+	template.Spec.RestartPolicy = corev1.RestartPolicyNever
+	// the hook shares the labels of the service, a required anti-affinity would keep it off all nodes running the app
+	template.Spec.Affinity = nil
+	template.Spec.Containers[0].Command = hook.Command
+	template.Spec.Containers[0].Args = hook.Args
+
+	backoffLimit := hook.BackoffLimit
+	if backoffLimit == nil {
+		backoffLimit = ptrInt32(0)
+	}
+
+	// the metadata of the pod, e.g. its scrape annotations, does not change what the hook does
+	hookChecksum, err := checksum(template.Spec)
+	if err != nil {
+		return nil, err
+	}
+
+	job := &batchv1.Job{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: batchv1.SchemeGroupVersion.String(),
+			Kind:       "Job",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, hookPreDeploy, strconv.FormatInt(revision, 10)),
+			Namespace: svc.Namespace,
+			Labels:    labels,
+			Annotations: map[string]string{
+				revisionAnnotation:     strconv.FormatInt(revision, 10),
+				hookChecksumAnnotation: hookChecksum,
+			},
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          backoffLimit,
+			ActiveDeadlineSeconds: hook.ActiveDeadlineSeconds,
+			Template:              template,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(svc, job, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return job, nil
+}
+
+// cleanupHookJobs deletes all hook jobs of the service
+func (r *ReconcileService) cleanupHookJobs(svc *appsv1alpha1.Service) error {
+	jobs, err := r.listHookJobs(svc)
+	if err != nil {
+		return err
+	}
+
+	for i := range jobs.Items {
+		job := &jobs.Items[i]
+
+		// the pods of the job are removed as well
+		if err := r.client.Delete(context.TODO(), job, client.PropagationPolicy(metav1.DeletePropagationBackground)); err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("failed to delete hook job %s: %v", job.Name, err)
+		}
+	}
+
+	return nil
+}
+
+func (r *ReconcileService) listHookJobs(svc *appsv1alpha1.Service) (*batchv1.JobList, error) {
+	jobs := &batchv1.JobList{}
+	opts := []client.ListOption{
+		client.InNamespace(svc.Namespace),
+		client.MatchingLabels(r.makeHookLabels(svc, hookPreDeploy)),
+	}
+	if err := r.client.List(context.TODO(), jobs, opts...); err != nil {
+		return nil, fmt.Errorf("failed to list hook jobs: %v", err)
+	}
+
+	return jobs, nil
+}
+
+// makeHookLabels returns the labels of hook jobs and their pods. They must not match the selector of the service, so
+// hook pods never receive any traffic.
+func (b *objectBuilder) makeHookLabels(svc *appsv1alpha1.Service, hook string) map[string]string {
+	return mergeLabels(b.makeKubelixLabels(svc), map[string]string{hookLabel: hook})
+}
+
+// jobFailedCondition returns the failed condition of the job, nil if the job did not fail
+func jobFailedCondition(job *batchv1.Job) *batchv1.JobCondition {
+	for i := range job.Status.Conditions {
+		condition := &job.Status.Conditions[i]
+		if condition.Type == batchv1.JobFailed && condition.Status == corev1.ConditionTrue {
+			return condition
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,139 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	batchv1 "k8s.io/api/batch/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_ensurePreDeployHook(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	c := fake.NewFakeClientWithScheme(s)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "shop:1.0",
+			Hooks: &appsv1alpha1.Hooks{
+				PreDeploy: &appsv1alpha1.Hook{Command: []string{"migrate"}},
+			},
+		},
+		Status: appsv1alpha1.ServiceStatus{
+			Revisions: appsv1alpha1.RevisionList{{Revision: 1, Checksum: "one"}},
+		},
+	}
+
+	succeeded, err := r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want false, nil", succeeded, err)
+	}
+
+	jobName := types.NamespacedName{Name: "shop-pre-deploy-1", Namespace: "default"}
+	job := &batchv1.Job{}
+	if err := c.Get(context.TODO(), jobName, job); err != nil {
+		t.Fatalf("failed to get pre-deploy job: %v", err)
+	}
+
+	container := job.Spec.Template.Spec.Containers[0]
+	if len(container.Command) != 1 || container.Command[0] != "migrate" || container.Image != "shop:1.0" {
+		t.Errorf("unexpected hook container %+v", container)
+	}
+	if *job.Spec.BackoffLimit != 0 || job.Spec.Template.Spec.RestartPolicy != corev1.RestartPolicyNever {
+		t.Errorf("unexpected job spec %+v", job.Spec)
+	}
+	if job.Spec.Template.Labels[hookLabel] != hookPreDeploy {
+		t.Errorf("hook pods must not match the selector of the service, labels = %v", job.Spec.Template.Labels)
+	}
+
+	// a failed job holds back the rollout and is reported
+	job.Status.Conditions = []batchv1.JobCondition{{Type: batchv1.JobFailed, Status: corev1.ConditionTrue, Reason: "BackoffLimitExceeded"}}
+	if err := c.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want false, nil", succeeded, err)
+	}
+	if !svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionPreDeployFailed) {
+		t.Errorf("expected the %s condition to be set", appsv1alpha1.ConditionPreDeployFailed)
+	}
+
+	// once the job succeeded the rollout continues and the job is removed
+	job.Status.Conditions = nil
+	job.Status.Succeeded = 1
+	if err := c.Update(context.TODO(), job); err != nil {
+		t.Fatal(err)
+	}
+
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || !succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want true, nil", succeeded, err)
+	}
+	if svc.Status.PreDeployRevision != 1 {
+		t.Errorf("PreDeployRevision = %v, want 1", svc.Status.PreDeployRevision)
+	}
+	if svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionPreDeployFailed) {
+		t.Errorf("expected the %s condition to be cleared", appsv1alpha1.ConditionPreDeployFailed)
+	}
+	if err := c.Get(context.TODO(), jobName, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the finished job to be removed, got %v", err)
+	}
+
+	// the hook does not run again for the same revision
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || !succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want true, nil", succeeded, err)
+	}
+
+	// nor for a revision that leaves the pod of the hook unchanged
+	svc.Spec.Strategy = appsv1alpha1.StrategyCanary
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 2, Checksum: "two"})
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || !succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want true, nil", succeeded, err)
+	}
+	if svc.Status.PreDeployRevision != 2 {
+		t.Errorf("PreDeployRevision = %v, want 2", svc.Status.PreDeployRevision)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop-pre-deploy-2", Namespace: "default"}, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no job for an unchanged pod, got %v", err)
+	}
+
+	// a new image runs the hook again
+	svc.Spec.Image = "shop:1.1"
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 3, Checksum: "three"})
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want false, nil", succeeded, err)
+	}
+
+	// the running job is kept for a revision that does not change its pod
+	svc.Spec.Strategy = appsv1alpha1.StrategyRollingUpdate
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{Revision: 4, Checksum: "four"})
+	succeeded, err = r.ensurePreDeployHook(svc, nil, log)
+	if err != nil || succeeded {
+		t.Fatalf("ensurePreDeployHook() = %v, %v, want false, nil", succeeded, err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop-pre-deploy-3", Namespace: "default"}, &batchv1.Job{}); err != nil {
+		t.Errorf("expected the running job to be kept, got %v", err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "shop-pre-deploy-4", Namespace: "default"}, &batchv1.Job{}); !errors.IsNotFound(err) {
+		t.Errorf("expected no second job for the same pod, got %v", err)
+	}
+}
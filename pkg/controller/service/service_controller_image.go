@@ -0,0 +1,80 @@
+package service
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/registry"
+)
+
+// resolveImage records the digest of the image in the status whenever the image of a service with pinned digests
+// changes. The digest is kept until the image changes again, so restarted pods keep running the same image.
+func (r *ReconcileService) resolveImage(svc *appsv1alpha1.Service, configs []dockerConfig, reqLogger logr.Logger) error {
+	if !svc.Spec.PinDigest {
+		svc.Status.ResolvedImage = nil
+		return nil
+	}
+
+	if svc.Status.ResolvedImage != nil && svc.Status.ResolvedImage.Image == svc.Spec.Image {
+		return nil
+	}
+
+	ref, err := registry.ParseReference(svc.Spec.Image)
+	if err != nil {
+		return err
+	}
+
+	digest, err := r.registry.Digest(context.TODO(), ref, registryAuth(svc.Spec.Image, configs))
+	if err != nil {
+		return fmt.Errorf("failed to resolve digest of image %s: %v", svc.Spec.Image, err)
+	}
+
+	reqLogger.Info("Resolved image digest", "Image", svc.Spec.Image, "Digest", digest)
+
+	svc.Status.ResolvedImage = &appsv1alpha1.ResolvedImage{
+		Image:      svc.Spec.Image,
+		Digest:     digest,
+		ResolvedAt: metav1.Now(),
+	}
+
+	return nil
+}
+
+// registryAuth returns the credentials of the registry the image is pulled from
+func registryAuth(image string, configs []dockerConfig) registry.Auth {
+	for _, cfg := range configs {
+		if registry.MatchesHost(image, cfg.Registry) {
+			return cfg.Auth
+		}
+	}
+
+	return registry.Auth{}
+}
+
+// serviceImage returns the image to deploy, pinned to the resolved digest if available
+func serviceImage(svc *appsv1alpha1.Service) string {
+	resolved := svc.Status.ResolvedImage
+	if !svc.Spec.PinDigest || resolved == nil || resolved.Image != svc.Spec.Image {
+		return svc.Spec.Image
+	}
+
+	ref, err := registry.ParseReference(svc.Spec.Image)
+	if err != nil || ref.Digest != "" {
+		return svc.Spec.Image
+	}
+
+	return svc.Spec.Image + "@" + resolved.Digest
+}
+
+func imagePullPolicy(svc *appsv1alpha1.Service) corev1.PullPolicy {
+	if svc.Spec.ImagePullPolicy == "" {
+		return corev1.PullAlways
+	}
+
+	return svc.Spec.ImagePullPolicy
+}
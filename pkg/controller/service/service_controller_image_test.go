@@ -0,0 +1,72 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/registry"
+)
+
+type fakeRegistry struct {
+	digests map[string]string
+	auths   []registry.Auth
+}
+
+func (f *fakeRegistry) Digest(ctx context.Context, ref registry.Reference, auth registry.Auth) (string, error) {
+	f.auths = append(f.auths, auth)
+	return f.digests[ref.String()], nil
+}
+
+func (f *fakeRegistry) Tags(ctx context.Context, ref registry.Reference, auth registry.Auth) ([]string, error) {
+	return nil, nil
+}
+
+func TestReconcileService_resolveImage(t *testing.T) {
+	reg := &fakeRegistry{digests: map[string]string{
+		"registry.example.com/app:1.0": "sha256:first",
+		"registry.example.com/app:2.0": "sha256:second",
+	}}
+	r := &ReconcileService{registry: reg}
+	configs := []dockerConfig{{Registry: "registry.example.com", Auth: registry.Auth{Username: "user", Password: "pw"}}}
+
+	svc := &appsv1alpha1.Service{Spec: appsv1alpha1.ServiceSpec{Image: "registry.example.com/app:1.0", PinDigest: true}}
+
+	if err := r.resolveImage(svc, configs, log); err != nil {
+		t.Fatalf("resolveImage() error = %v", err)
+	}
+	if got, want := serviceImage(svc), "registry.example.com/app:1.0@sha256:first"; got != want {
+		t.Errorf("serviceImage() = %v, want %v", got, want)
+	}
+	if len(reg.auths) != 1 || reg.auths[0].Username != "user" {
+		t.Errorf("expected registry to be called once with credentials, got %v", reg.auths)
+	}
+
+	// the digest is kept as long as the image does not change
+	reg.digests["registry.example.com/app:1.0"] = "sha256:moved"
+	if err := r.resolveImage(svc, configs, log); err != nil {
+		t.Fatalf("resolveImage() error = %v", err)
+	}
+	if got, want := serviceImage(svc), "registry.example.com/app:1.0@sha256:first"; got != want {
+		t.Errorf("serviceImage() = %v, want %v", got, want)
+	}
+
+	svc.Spec.Image = "registry.example.com/app:2.0"
+	if got, want := serviceImage(svc), "registry.example.com/app:2.0"; got != want {
+		t.Errorf("serviceImage() of unresolved image = %v, want %v", got, want)
+	}
+	if err := r.resolveImage(svc, configs, log); err != nil {
+		t.Fatalf("resolveImage() error = %v", err)
+	}
+	if got, want := serviceImage(svc), "registry.example.com/app:2.0@sha256:second"; got != want {
+		t.Errorf("serviceImage() = %v, want %v", got, want)
+	}
+
+	svc.Spec.PinDigest = false
+	if err := r.resolveImage(svc, configs, log); err != nil {
+		t.Fatalf("resolveImage() error = %v", err)
+	}
+	if svc.Status.ResolvedImage != nil {
+		t.Errorf("expected resolved image to be removed, got %v", svc.Status.ResolvedImage)
+	}
+}
@@ -31,8 +31,8 @@ func (r *ReconcileService) ensureIngresses(svc *appsv1alpha1.Service, reqLogger
 	return ingresses, nil
 }
 
-func (r *ReconcileService) newIngressesForService(svc *appsv1alpha1.Service) ([]*networkingv1beta1.Ingress, error) {
-	labels := r.makeLabels(svc)
+func (b *objectBuilder) newIngressesForService(svc *appsv1alpha1.Service) ([]*networkingv1beta1.Ingress, error) {
+	labels := b.makeLabels(svc)
 	ingresses := make([]*networkingv1beta1.Ingress, 0)
 
 	for _, p := range svc.Spec.Ports {
@@ -41,7 +41,8 @@ func (r *ReconcileService) newIngressesForService(svc *appsv1alpha1.Service) ([]
 		}
 
 		for _, ing := range p.Ingresses {
-			name := names.FormatDashFromParts(svc.Name, p.Name, ing.Host)
+			// the name is also used for the tls secret, so leave room for the suffix
+			name := names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength-len(tlsSecretSuffix), svc.Name, p.Name, ing.Host)
 
 			ingress := &networkingv1beta1.Ingress{
 				TypeMeta: metav1.TypeMeta{
@@ -54,11 +55,11 @@ func (r *ReconcileService) newIngressesForService(svc *appsv1alpha1.Service) ([]
 					Labels:    labels,
 				},
 				Spec: networkingv1beta1.IngressSpec{
-					Rules: r.makeIngressRules(svc, p, ing),
+					Rules: b.makeIngressRules(svc, p, ing),
 					TLS: []networkingv1beta1.IngressTLS{
 						{
 							Hosts:      []string{ing.Host},
-							SecretName: name + "-tls",
+							SecretName: name + tlsSecretSuffix,
 						},
 					},
 				},
@@ -68,7 +69,7 @@ func (r *ReconcileService) newIngressesForService(svc *appsv1alpha1.Service) ([]
 				ingress.SetAnnotations(config.Config.Ingress.Annotations)
 			}
 
-			if err := controllerutil.SetControllerReference(svc, ingress, r.scheme); err != nil {
+			if err := controllerutil.SetControllerReference(svc, ingress, b.scheme); err != nil {
 				return nil, err
 			}
 
@@ -79,7 +80,7 @@ func (r *ReconcileService) newIngressesForService(svc *appsv1alpha1.Service) ([]
 	return ingresses, nil
 }
 
-func (r *ReconcileService) makeIngressRules(svc *appsv1alpha1.Service, p appsv1alpha1.Port, ing appsv1alpha1.PortIngress) []networkingv1beta1.IngressRule {
+func (b *objectBuilder) makeIngressRules(svc *appsv1alpha1.Service, p appsv1alpha1.Port, ing appsv1alpha1.PortIngress) []networkingv1beta1.IngressRule {
 	rules := make([]networkingv1beta1.IngressRule, 0)
 	paths := make([]networkingv1beta1.HTTPIngressPath, 0)
 
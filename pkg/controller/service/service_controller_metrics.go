@@ -0,0 +1,152 @@
+package service
+
+import (
+	"fmt"
+	"strconv"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	"github.com/go-logr/logr"
+	"github.com/operator-framework/operator-sdk/pkg/k8sutil"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/rest"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+// serviceMonitorsAvailable checks whether the ServiceMonitor CRD of the prometheus operator is installed. Without it,
+// pods are annotated for scraping instead.
+func serviceMonitorsAvailable(cfg *rest.Config) bool {
+	dc, err := discovery.NewDiscoveryClientForConfig(cfg)
+	if err != nil {
+		log.Error(err, "failed to create discovery client, falling back to scrape annotations")
+		return false
+	}
+
+	exists, err := k8sutil.ResourceExists(dc, monitoringv1.SchemeGroupVersion.String(), monitoringv1.ServiceMonitorsKind)
+	if err != nil {
+		log.Error(err, "failed to discover ServiceMonitors, falling back to scrape annotations")
+		return false
+	}
+
+	log.Info(fmt.Sprintf("ServiceMonitors available: %v", exists))
+	return exists
+}
+
+// ensureServiceMonitor creates the ServiceMonitor of the service, nil if the service exposes no metrics or the
+// prometheus operator is not installed
+func (r *ReconcileService) ensureServiceMonitor(svc *appsv1alpha1.Service, reqLogger logr.Logger) (*monitoringv1.ServiceMonitor, error) {
+	if svc.Spec.Metrics == nil {
+		return nil, nil
+	}
+
+	if err := validateMetrics(svc); err != nil {
+		return nil, validationFailed(svc, "metrics", err)
+	}
+
+	if !r.serviceMonitors {
+		return nil, nil
+	}
+
+	serviceMonitor, err := r.newServiceMonitorForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	name := types.NamespacedName{Name: serviceMonitor.Name, Namespace: serviceMonitor.Namespace}
+	if err := r.ensureObject(reqLogger, svc, serviceMonitor, name); err != nil {
+		return nil, fmt.Errorf("failed to handle service monitor: %v", err)
+	}
+
+	return serviceMonitor, nil
+}
+
+func (b *objectBuilder) newServiceMonitorForService(svc *appsv1alpha1.Service) (*monitoringv1.ServiceMonitor, error) {
+	metrics := svc.Spec.Metrics
+	labels := b.makeLabels(svc)
+
+	serviceMonitor := &monitoringv1.ServiceMonitor{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: monitoringv1.SchemeGroupVersion.String(),
+			Kind:       monitoringv1.ServiceMonitorsKind,
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    labels,
+		},
+		Spec: monitoringv1.ServiceMonitorSpec{
+			// the canary and preview services select pods of their own, the headless service of a statefulset selects
+			// the same pods as the service
+			Selector: metav1.LabelSelector{
+				MatchLabels: labels,
+				MatchExpressions: []metav1.LabelSelectorRequirement{
+					{Key: headlessLabel, Operator: metav1.LabelSelectorOpDoesNotExist},
+				},
+			},
+			Endpoints: []monitoringv1.Endpoint{
+				{
+					Port:          metrics.Port,
+					Path:          metricsPath(svc),
+					Interval:      metrics.Interval,
+					ScrapeTimeout: metrics.ScrapeTimeout,
+				},
+			},
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(svc, serviceMonitor, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return serviceMonitor, nil
+}
+
+// makeScrapeAnnotations returns the scrape annotations of the pods if the prometheus operator is not installed.
+// Interval and timeout can not be set by annotations.
+func (b *objectBuilder) makeScrapeAnnotations(svc *appsv1alpha1.Service) map[string]string {
+	if b.serviceMonitors || svc.Spec.Metrics == nil {
+		return nil
+	}
+
+	port := metricsPort(svc)
+	if port == nil {
+		return nil
+	}
+
+	return map[string]string{
+		prometheusScrapeAnnotation: "true",
+		prometheusPortAnnotation:   strconv.Itoa(int(port.Container)),
+		prometheusPathAnnotation:   metricsPath(svc),
+	}
+}
+
+// validateMetrics checks that the metrics are served on a port of the service
+func validateMetrics(svc *appsv1alpha1.Service) error {
+	if svc.Spec.Metrics != nil && metricsPort(svc) == nil {
+		return fmt.Errorf("metrics.port %q is not a port of the service", svc.Spec.Metrics.Port)
+	}
+
+	return nil
+}
+
+// metricsPort returns the port serving the metrics, nil if there is no such port
+func metricsPort(svc *appsv1alpha1.Service) *appsv1alpha1.Port {
+	for i := range svc.Spec.Ports {
+		if svc.Spec.Ports[i].Name == svc.Spec.Metrics.Port {
+			return &svc.Spec.Ports[i]
+		}
+	}
+
+	return nil
+}
+
+func metricsPath(svc *appsv1alpha1.Service) string {
+	if svc.Spec.Metrics.Path != "" {
+		return svc.Spec.Metrics.Path
+	}
+
+	return defaultMetricsPath
+}
@@ -0,0 +1,63 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	monitoringv1 "github.com/coreos/prometheus-operator/pkg/apis/monitoring/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_newServiceMonitorForService(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = monitoringv1.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s, serviceMonitors: true}}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image:   "shop:1.0",
+			Ports:   appsv1alpha1.PortList{{Name: "metrics", Container: 9090, Service: 9090}},
+			Metrics: &appsv1alpha1.Metrics{Port: "metrics", Interval: "30s"},
+		},
+	}
+
+	serviceMonitor, err := r.newServiceMonitorForService(svc)
+	if err != nil {
+		t.Fatalf("newServiceMonitorForService() error = %v", err)
+	}
+
+	want := monitoringv1.Endpoint{Port: "metrics", Path: "/metrics", Interval: "30s"}
+	if !reflect.DeepEqual(serviceMonitor.Spec.Endpoints, []monitoringv1.Endpoint{want}) {
+		t.Errorf("endpoints = %+v, want %+v", serviceMonitor.Spec.Endpoints, want)
+	}
+	if !reflect.DeepEqual(serviceMonitor.Spec.Selector.MatchLabels, r.makeLabels(svc)) {
+		t.Errorf("unexpected selector %+v", serviceMonitor.Spec.Selector)
+	}
+
+	// pods are only annotated without the prometheus operator
+	if annotations := r.newPodTemplateForService(svc, nil).Annotations; annotations[prometheusScrapeAnnotation] != "" {
+		t.Errorf("unexpected scrape annotations %v", annotations)
+	}
+
+	r.serviceMonitors = false
+	wantAnnotations := map[string]string{
+		prometheusScrapeAnnotation: "true",
+		prometheusPortAnnotation:   "9090",
+		prometheusPathAnnotation:   "/metrics",
+	}
+	if annotations := r.newPodTemplateForService(svc, nil).Annotations; !reflect.DeepEqual(annotations, wantAnnotations) {
+		t.Errorf("annotations = %v, want %v", annotations, wantAnnotations)
+	}
+
+	svc.Spec.Metrics.Port = "http"
+	if _, err := r.ensureServiceMonitor(svc, log); err == nil {
+		t.Errorf("expected an error for an unknown metrics port")
+	}
+}
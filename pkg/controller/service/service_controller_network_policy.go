@@ -0,0 +1,213 @@
+package service
+
+import (
+	"fmt"
+	"net"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/intstr"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+// ensureNetworkPolicy creates the network policy of the service, nil if the service declares no peers
+func (r *ReconcileService) ensureNetworkPolicy(svc *appsv1alpha1.Service, reqLogger logr.Logger) (*networkingv1.NetworkPolicy, error) {
+	if len(svc.Spec.IngressFrom) == 0 && len(svc.Spec.EgressTo) == 0 {
+		return nil, nil
+	}
+
+	if err := validateNetworkPeers("ingressFrom", svc.Spec.IngressFrom); err != nil {
+		return nil, validationFailed(svc, "networkPeers", err)
+	}
+	if err := validateNetworkPeers("egressTo", svc.Spec.EgressTo); err != nil {
+		return nil, validationFailed(svc, "networkPeers", err)
+	}
+
+	policy, err := r.newNetworkPolicyForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	policyName := types.NamespacedName{Name: policy.Name, Namespace: policy.Namespace}
+	if err := r.ensureObject(reqLogger, svc, policy, policyName); err != nil {
+		return nil, fmt.Errorf("failed to handle network policy: %v", err)
+	}
+
+	return policy, nil
+}
+
+func (b *objectBuilder) newNetworkPolicyForService(svc *appsv1alpha1.Service) (*networkingv1.NetworkPolicy, error) {
+	policy := &networkingv1.NetworkPolicy{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: networkingv1.SchemeGroupVersion.String(),
+			Kind:       "NetworkPolicy",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    b.makeLabels(svc),
+		},
+		Spec: networkingv1.NetworkPolicySpec{
+			// all pods of the service, including canaries, blue/green deployments and jobs
+			PodSelector: metav1.LabelSelector{
+				MatchLabels: b.makeKubelixLabels(svc),
+			},
+		},
+	}
+
+	if len(svc.Spec.IngressFrom) > 0 {
+		policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networkingv1.PolicyTypeIngress)
+		policy.Spec.Ingress = []networkingv1.NetworkPolicyIngressRule{
+			{From: networkPeersToPolicyPeers(svc, svc.Spec.IngressFrom)},
+		}
+
+		if rule := ingressControllerRule(svc); rule != nil {
+			policy.Spec.Ingress = append(policy.Spec.Ingress, *rule)
+		}
+	}
+
+	if len(svc.Spec.EgressTo) > 0 {
+		policy.Spec.PolicyTypes = append(policy.Spec.PolicyTypes, networkingv1.PolicyTypeEgress)
+		policy.Spec.Egress = []networkingv1.NetworkPolicyEgressRule{
+			{To: networkPeersToPolicyPeers(svc, svc.Spec.EgressTo)},
+			dnsEgressRule(),
+		}
+	}
+
+	if err := controllerutil.SetControllerReference(svc, policy, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return policy, nil
+}
+
+// networkPeersToPolicyPeers converts the peers of the service. Other projects are selected by the name of their
+// namespace, as the labels of pods can be set to anything by whoever creates them in another namespace.
+func networkPeersToPolicyPeers(svc *appsv1alpha1.Service, peers []appsv1alpha1.NetworkPeer) []networkingv1.NetworkPolicyPeer {
+	policyPeers := make([]networkingv1.NetworkPolicyPeer, 0, len(peers))
+
+	for _, peer := range peers {
+		switch {
+		case peer.CIDR != "":
+			policyPeers = append(policyPeers, networkingv1.NetworkPolicyPeer{
+				IPBlock: &networkingv1.IPBlock{CIDR: peer.CIDR},
+			})
+
+		case peer.IngressController:
+			policyPeers = append(policyPeers, ingressControllerPeer())
+
+		case peer.Project != "" && peer.Project != svc.Namespace:
+			policyPeer := networkingv1.NetworkPolicyPeer{
+				NamespaceSelector: &metav1.LabelSelector{
+					MatchLabels: map[string]string{namespaceNameLabel: peer.Project},
+				},
+			}
+			if peer.Service != "" {
+				policyPeer.PodSelector = &metav1.LabelSelector{
+					MatchLabels: map[string]string{"apps.kubelix.io/service": peer.Service},
+				}
+			}
+
+			policyPeers = append(policyPeers, policyPeer)
+
+		default:
+			podLabels := map[string]string{"apps.kubelix.io/project": svc.Namespace}
+			if peer.Service != "" {
+				podLabels["apps.kubelix.io/service"] = peer.Service
+			}
+
+			policyPeers = append(policyPeers, networkingv1.NetworkPolicyPeer{
+				PodSelector: &metav1.LabelSelector{MatchLabels: podLabels},
+			})
+		}
+	}
+
+	return policyPeers
+}
+
+// ingressControllerRule allows the ingress controller to connect to all ports exposed by ingresses, nil if no port is
+// exposed or no ingress controller is configured
+func ingressControllerRule(svc *appsv1alpha1.Service) *networkingv1.NetworkPolicyIngressRule {
+	if config.Config.NetworkPolicy.IngressController == nil {
+		return nil
+	}
+
+	ports := make([]networkingv1.NetworkPolicyPort, 0)
+	for _, p := range svc.Spec.Ports {
+		if len(p.Ingresses) == 0 {
+			continue
+		}
+
+		port := intstr.FromInt(int(p.Container))
+		ports = append(ports, networkingv1.NetworkPolicyPort{Port: &port})
+	}
+
+	if len(ports) == 0 {
+		return nil
+	}
+
+	return &networkingv1.NetworkPolicyIngressRule{
+		From:  []networkingv1.NetworkPolicyPeer{ingressControllerPeer()},
+		Ports: ports,
+	}
+}
+
+func ingressControllerPeer() networkingv1.NetworkPolicyPeer {
+	ic := config.Config.NetworkPolicy.IngressController
+
+	return networkingv1.NetworkPolicyPeer{
+		NamespaceSelector: ic.NamespaceSelector.DeepCopy(),
+		PodSelector:       ic.PodSelector.DeepCopy(),
+	}
+}
+
+// dnsEgressRule allows DNS lookups, without them hardly any service can connect to its peers
+func dnsEgressRule() networkingv1.NetworkPolicyEgressRule {
+	udp, tcp := corev1.ProtocolUDP, corev1.ProtocolTCP
+	port := intstr.FromInt(53)
+
+	return networkingv1.NetworkPolicyEgressRule{
+		Ports: []networkingv1.NetworkPolicyPort{
+			{Protocol: &udp, Port: &port},
+			{Protocol: &tcp, Port: &port},
+		},
+	}
+}
+
+// validateNetworkPeers checks that each peer references exactly one kind of peer
+func validateNetworkPeers(field string, peers []appsv1alpha1.NetworkPeer) error {
+	for i, peer := range peers {
+		kinds := 0
+		if peer.Service != "" || peer.Project != "" {
+			kinds++
+		}
+		if peer.CIDR != "" {
+			kinds++
+		}
+		if peer.IngressController {
+			kinds++
+		}
+
+		if kinds != 1 {
+			return fmt.Errorf("%s[%d] needs either service and / or project, cidr or ingressController", field, i)
+		}
+
+		if peer.CIDR != "" {
+			if _, _, err := net.ParseCIDR(peer.CIDR); err != nil {
+				return fmt.Errorf("%s[%d] has an invalid cidr: %v", field, i, err)
+			}
+		}
+
+		if peer.IngressController && config.Config.NetworkPolicy.IngressController == nil {
+			return fmt.Errorf("%s[%d] references the ingress controller, which is not configured", field, i)
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,116 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	networkingv1 "k8s.io/api/networking/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_newNetworkPolicyForService(t *testing.T) {
+	defer func(cfg config.NetworkPolicyConfig) { config.Config.NetworkPolicy = cfg }(config.Config.NetworkPolicy)
+	config.Config.NetworkPolicy = config.NetworkPolicyConfig{
+		IngressController: &config.NetworkPolicyPeer{
+			NamespaceSelector: &metav1.LabelSelector{MatchLabels: map[string]string{"name": "ingress-nginx"}},
+		},
+	}
+
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "shop", Namespace: "shop"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Ports: appsv1alpha1.PortList{
+				{Name: "http", Container: 8080, Ingresses: []appsv1alpha1.PortIngress{{Host: "shop.example.com"}}},
+				{Name: "metrics", Container: 9090},
+			},
+			IngressFrom: []appsv1alpha1.NetworkPeer{
+				{Service: "frontend"},
+				{Project: "billing", Service: "invoices"},
+				{Project: "monitoring"},
+				{Project: "shop", Service: "worker"},
+			},
+			EgressTo: []appsv1alpha1.NetworkPeer{
+				{CIDR: "10.0.0.0/8"},
+			},
+		},
+	}
+
+	if err := validateNetworkPeers("ingressFrom", svc.Spec.IngressFrom); err != nil {
+		t.Fatalf("validateNetworkPeers() error = %v", err)
+	}
+
+	policy, err := r.newNetworkPolicyForService(svc)
+	if err != nil {
+		t.Fatalf("newNetworkPolicyForService() error = %v", err)
+	}
+
+	wantTypes := []networkingv1.PolicyType{networkingv1.PolicyTypeIngress, networkingv1.PolicyTypeEgress}
+	if !reflect.DeepEqual(policy.Spec.PolicyTypes, wantTypes) {
+		t.Errorf("policy types = %v, want %v", policy.Spec.PolicyTypes, wantTypes)
+	}
+	if len(policy.Spec.Ingress) != 2 {
+		t.Fatalf("expected a rule for the peers and one for the ingress controller, got %+v", policy.Spec.Ingress)
+	}
+
+	from := policy.Spec.Ingress[0].From
+	if from[0].NamespaceSelector != nil || from[0].PodSelector.MatchLabels["apps.kubelix.io/project"] != "shop" {
+		t.Errorf("expected a service of the same project, got %+v", from[0])
+	}
+	wantNamespace := &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "billing"}}
+	wantPods := &metav1.LabelSelector{MatchLabels: map[string]string{"apps.kubelix.io/service": "invoices"}}
+	if !reflect.DeepEqual(from[1].NamespaceSelector, wantNamespace) || !reflect.DeepEqual(from[1].PodSelector, wantPods) {
+		t.Errorf("expected a service of another project selected by its namespace, got %+v", from[1])
+	}
+	wantNamespace = &metav1.LabelSelector{MatchLabels: map[string]string{namespaceNameLabel: "monitoring"}}
+	if !reflect.DeepEqual(from[2].NamespaceSelector, wantNamespace) || from[2].PodSelector != nil {
+		t.Errorf("expected all pods of another project, got %+v", from[2])
+	}
+	if from[3].NamespaceSelector != nil || from[3].PodSelector.MatchLabels["apps.kubelix.io/service"] != "worker" {
+		t.Errorf("expected the own project to be selected by pod labels, got %+v", from[3])
+	}
+
+	controller := policy.Spec.Ingress[1]
+	if len(controller.Ports) != 1 || controller.Ports[0].Port.IntValue() != 8080 {
+		t.Errorf("expected the ingress controller to reach the exposed port only, got %+v", controller.Ports)
+	}
+	if controller.From[0].NamespaceSelector.MatchLabels["name"] != "ingress-nginx" {
+		t.Errorf("unexpected ingress controller peer %+v", controller.From[0])
+	}
+
+	if len(policy.Spec.Egress) != 2 || policy.Spec.Egress[0].To[0].IPBlock.CIDR != "10.0.0.0/8" {
+		t.Errorf("expected the cidr and dns to be allowed, got %+v", policy.Spec.Egress)
+	}
+}
+
+func Test_validateNetworkPeers(t *testing.T) {
+	tests := []struct {
+		name    string
+		peer    appsv1alpha1.NetworkPeer
+		wantErr bool
+	}{
+		{name: "service", peer: appsv1alpha1.NetworkPeer{Service: "frontend"}},
+		{name: "project", peer: appsv1alpha1.NetworkPeer{Project: "billing"}},
+		{name: "cidr", peer: appsv1alpha1.NetworkPeer{CIDR: "192.168.0.0/16"}},
+		{name: "empty", peer: appsv1alpha1.NetworkPeer{}, wantErr: true},
+		{name: "invalid cidr", peer: appsv1alpha1.NetworkPeer{CIDR: "192.168.0.0"}, wantErr: true},
+		{name: "several kinds", peer: appsv1alpha1.NetworkPeer{Service: "frontend", CIDR: "10.0.0.0/8"}, wantErr: true},
+		{name: "unconfigured ingress controller", peer: appsv1alpha1.NetworkPeer{IngressController: true}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateNetworkPeers("ingressFrom", []appsv1alpha1.NetworkPeer{tt.peer}); (err != nil) != tt.wantErr {
+				t.Errorf("validateNetworkPeers() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
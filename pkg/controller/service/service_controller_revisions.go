@@ -0,0 +1,273 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+// ensureRevisions records the spec as a new revision whenever it differs from the latest revision and stores the
+// specs of all revisions listed in the status in the revision config map
+func (r *ReconcileService) ensureRevisions(svc *appsv1alpha1.Service, reqLogger logr.Logger) (*corev1.ConfigMap, error) {
+	specs, err := r.loadRevisionSpecs(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := recordRevision(svc, specs); err != nil {
+		return nil, err
+	}
+
+	configMap, err := r.newRevisionsConfigMapForService(svc, specs)
+	if err != nil {
+		return nil, err
+	}
+
+	name := types.NamespacedName{Name: configMap.Name, Namespace: configMap.Namespace}
+	if err := r.ensureObject(reqLogger, svc, configMap, name); err != nil {
+		return nil, fmt.Errorf("failed to handle revisions config map: %v", err)
+	}
+
+	return configMap, nil
+}
+
+// recordRevision adds the current spec to the revisions, if it differs from the latest one
+func recordRevision(svc *appsv1alpha1.Service, specs map[string]string) error {
+	sum, err := checksum(svc.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to get checksum of spec: %v", err)
+	}
+
+	latest := svc.Status.Revisions.Latest()
+	if latest != nil && latest.Checksum == sum {
+		return nil
+	}
+
+	// the image policy resumes once a spec other than the one restored by a rollback is applied
+	if paused, ok := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; ok && paused != sum {
+		delete(svc.Annotations, appsv1alpha1.ImagePolicyPausedAnnotation)
+	}
+
+	next := int64(1)
+	if latest != nil {
+		next = latest.Revision + 1
+	}
+
+	spec, err := json.Marshal(svc.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to encode spec: %v", err)
+	}
+
+	specs[strconv.FormatInt(next, 10)] = string(spec)
+	svc.Status.Revisions = append(svc.Status.Revisions, appsv1alpha1.Revision{
+		Revision:  next,
+		Image:     serviceImage(svc),
+		Checksum:  sum,
+		CreatedAt: metav1.Now(),
+	})
+	svc.Status.Revisions.Prune(config.Config.RevisionHistoryLimit)
+
+	return nil
+}
+
+func (b *objectBuilder) newRevisionsConfigMapForService(svc *appsv1alpha1.Service, specs map[string]string) (*corev1.ConfigMap, error) {
+	configMap := &corev1.ConfigMap{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ConfigMap",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      revisionsConfigMapName(svc),
+			Namespace: svc.Namespace,
+			Labels:    b.makeLabels(svc),
+		},
+		Data: map[string]string{},
+	}
+
+	// only keep the specs of revisions that were not pruned from the status
+	for _, revision := range svc.Status.Revisions {
+		key := strconv.FormatInt(revision.Revision, 10)
+		if spec, ok := specs[key]; ok {
+			configMap.Data[key] = spec
+		}
+	}
+
+	if err := controllerutil.SetControllerReference(svc, configMap, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return configMap, nil
+}
+
+// loadRevisionSpecs reads the specs of all revisions from the revision config map
+func (r *ReconcileService) loadRevisionSpecs(svc *appsv1alpha1.Service) (map[string]string, error) {
+	configMap := &corev1.ConfigMap{}
+	name := types.NamespacedName{Namespace: svc.Namespace, Name: revisionsConfigMapName(svc)}
+
+	if err := r.client.Get(context.TODO(), name, configMap); err != nil {
+		if errors.IsNotFound(err) {
+			return map[string]string{}, nil
+		}
+		return nil, fmt.Errorf("failed to get revisions config map: %v", err)
+	}
+
+	specs := make(map[string]string, len(configMap.Data))
+	for k, v := range configMap.Data {
+		specs[k] = v
+	}
+
+	return specs, nil
+}
+
+// rollback restores the revision referenced by the rollback annotation and removes the annotation afterwards
+func (r *ReconcileService) rollback(svc *appsv1alpha1.Service, reqLogger logr.Logger) error {
+	value, ok := svc.Annotations[rollbackAnnotation]
+	if !ok {
+		return nil
+	}
+
+	delete(svc.Annotations, rollbackAnnotation)
+
+	revision, err := strconv.ParseInt(value, 10, 64)
+	if err != nil {
+		r.recorder.Eventf(svc, corev1.EventTypeWarning, "RollbackFailed", "Invalid revision %q", value)
+		return r.client.Update(context.TODO(), svc)
+	}
+
+	resolvedImage, err := r.restoreRevision(svc, revision)
+	if err != nil {
+		reqLogger.Error(err, "failed to roll back", "Revision", revision)
+		r.recorder.Eventf(svc, corev1.EventTypeWarning, "RollbackFailed", "Failed to roll back to revision %d: %v", revision, err)
+		return r.client.Update(context.TODO(), svc)
+	}
+
+	if err := r.client.Update(context.TODO(), svc); err != nil {
+		return fmt.Errorf("failed to roll back to revision %d: %v", revision, err)
+	}
+
+	// the update reloaded the status, so the digest of the revision is restored afterwards
+	if resolvedImage != nil {
+		svc.Status.ResolvedImage = resolvedImage
+	}
+
+	reqLogger.Info("Rolled back", "Revision", revision)
+	r.recorder.Eventf(svc, corev1.EventTypeNormal, "RolledBack", "Rolled back to revision %d", revision)
+
+	return nil
+}
+
+// restoreRevision replaces the spec of the service with the spec of the given revision. If the image of the
+// revision was pinned to a digest the digest is returned, so the very same image is deployed again. The image policy
+// of the restored spec is paused, so it does not update to the image that was rolled back from.
+func (r *ReconcileService) restoreRevision(svc *appsv1alpha1.Service, revision int64) (*appsv1alpha1.ResolvedImage, error) {
+	spec, resolvedImage, err := r.loadRevision(svc, revision)
+	if err != nil {
+		return nil, err
+	}
+
+	svc.Spec = *spec
+	if err := pauseImagePolicy(svc); err != nil {
+		return nil, err
+	}
+
+	return resolvedImage, nil
+}
+
+// pauseImagePolicy keeps the image policy of the service from replacing the image of the restored spec
+func pauseImagePolicy(svc *appsv1alpha1.Service) error {
+	if svc.Spec.ImagePolicy == nil {
+		return nil
+	}
+
+	sum, err := checksum(svc.Spec)
+	if err != nil {
+		return fmt.Errorf("failed to get checksum of spec: %v", err)
+	}
+
+	if svc.Annotations == nil {
+		svc.Annotations = map[string]string{}
+	}
+	svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation] = sum
+
+	return nil
+}
+
+// loadRevision reads the spec of the given revision and the digest its image was pinned to, if any
+func (r *ReconcileService) loadRevision(svc *appsv1alpha1.Service, revision int64) (*appsv1alpha1.ServiceSpec, *appsv1alpha1.ResolvedImage, error) {
+	rev := svc.Status.Revisions.Find(revision)
+	if rev == nil {
+		return nil, nil, fmt.Errorf("revision %d is not part of the history", revision)
+	}
+
+	specs, err := r.loadRevisionSpecs(svc)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, ok := specs[strconv.FormatInt(revision, 10)]
+	if !ok {
+		return nil, nil, fmt.Errorf("spec of revision %d not found", revision)
+	}
+
+	spec := &appsv1alpha1.ServiceSpec{}
+	if err := json.Unmarshal([]byte(raw), spec); err != nil {
+		return nil, nil, fmt.Errorf("failed to decode spec of revision %d: %v", revision, err)
+	}
+
+	i := strings.LastIndex(rev.Image, "@")
+	if !spec.PinDigest || i == -1 {
+		return spec, nil, nil
+	}
+
+	return spec, &appsv1alpha1.ResolvedImage{
+		Image:      spec.Image,
+		Digest:     rev.Image[i+1:],
+		ResolvedAt: metav1.Now(),
+	}, nil
+}
+
+// serviceAtRevision returns a copy of the service with the spec of the given revision. The revisions of the copy end
+// with the given one, so objects generated from it are annotated with that revision.
+func (r *ReconcileService) serviceAtRevision(svc *appsv1alpha1.Service, revision int64) (*appsv1alpha1.Service, error) {
+	if latest := svc.Status.Revisions.Latest(); latest != nil && latest.Revision == revision {
+		return svc, nil
+	}
+
+	spec, resolvedImage, err := r.loadRevision(svc, revision)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load revision %d: %v", revision, err)
+	}
+
+	old := svc.DeepCopy()
+	old.Spec = *spec
+	// the revision may have been applied before the strategy was switched, but its pods need the labels of the
+	// current strategy
+	old.Spec.Strategy = svc.Spec.Strategy
+	old.Status.ResolvedImage = resolvedImage
+
+	old.Status.Revisions = appsv1alpha1.RevisionList{}
+	for _, rev := range svc.Status.Revisions {
+		if rev.Revision <= revision {
+			old.Status.Revisions = append(old.Status.Revisions, rev)
+		}
+	}
+
+	return old, nil
+}
+
+func revisionsConfigMapName(svc *appsv1alpha1.Service) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, svc.Name, "revisions")
+}
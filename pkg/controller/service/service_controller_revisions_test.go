@@ -0,0 +1,130 @@
+package service
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func Test_recordRevision(t *testing.T) {
+	limit := config.Config.RevisionHistoryLimit
+	config.Config.RevisionHistoryLimit = 2
+	defer func() { config.Config.RevisionHistoryLimit = limit }()
+
+	svc := &appsv1alpha1.Service{Spec: appsv1alpha1.ServiceSpec{Image: "app:1"}}
+	specs := map[string]string{}
+
+	for _, image := range []string{"app:1", "app:1", "app:2", "app:3"} {
+		svc.Spec.Image = image
+		if err := recordRevision(svc, specs); err != nil {
+			t.Fatalf("recordRevision() error = %v", err)
+		}
+	}
+
+	if len(svc.Status.Revisions) != 2 {
+		t.Fatalf("expected 2 revisions, got %v", svc.Status.Revisions)
+	}
+	if got := svc.Status.Revisions.Latest(); got.Revision != 3 || got.Image != "app:3" {
+		t.Errorf("Latest() = %v, want revision 3 with image app:3", got)
+	}
+	if svc.Status.Revisions.Find(1) != nil {
+		t.Errorf("expected revision 1 to be pruned")
+	}
+}
+
+func TestReconcileService_restoreRevision(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1alpha1.ServiceSpec{Image: "app:2"},
+		Status: appsv1alpha1.ServiceStatus{Revisions: appsv1alpha1.RevisionList{
+			{Revision: 1, Image: "app:1@sha256:first"},
+			{Revision: 2, Image: "app:2"},
+		}},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-revisions", Namespace: "default"},
+		Data:       map[string]string{"1": `{"image":"app:1","pinDigest":true}`},
+	})
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}}
+
+	resolved, err := r.restoreRevision(svc, 1)
+	if err != nil {
+		t.Fatalf("restoreRevision() error = %v", err)
+	}
+	if svc.Spec.Image != "app:1" || !svc.Spec.PinDigest {
+		t.Errorf("unexpected spec after restore: %+v", svc.Spec)
+	}
+	if resolved == nil || resolved.Digest != "sha256:first" {
+		t.Errorf("restoreRevision() resolved = %v, want digest sha256:first", resolved)
+	}
+
+	if _, err := r.restoreRevision(svc, 2); err == nil {
+		t.Errorf("expected error for revision without stored spec")
+	}
+	if _, err := r.restoreRevision(svc, 5); err == nil {
+		t.Errorf("expected error for unknown revision")
+	}
+
+}
+
+func TestReconcileService_rollback_pausesImagePolicy(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Annotations: map[string]string{rollbackAnnotation: "1"},
+		},
+		Spec: appsv1alpha1.ServiceSpec{Image: "app:1.1.0", ImagePolicy: &appsv1alpha1.ImagePolicy{Semver: "^1.0"}},
+		Status: appsv1alpha1.ServiceStatus{Revisions: appsv1alpha1.RevisionList{
+			{Revision: 1, Image: "app:1.0.0"},
+			{Revision: 2, Image: "app:1.1.0"},
+		}},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc, &corev1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-revisions", Namespace: "default"},
+		Data:       map[string]string{"1": `{"image":"app:1.0.0","imagePolicy":{"semver":"^1.0"}}`},
+	})
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	if err := r.rollback(svc, log); err != nil {
+		t.Fatalf("rollback() error = %v", err)
+	}
+	if _, ok := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; !ok || svc.Spec.Image != "app:1.0.0" {
+		t.Fatalf("expected the restored spec to pause the image policy, got %v", svc.Annotations)
+	}
+
+	// the restored spec is recorded as a new revision without resuming the policy
+	specs := map[string]string{}
+	if err := recordRevision(svc, specs); err != nil {
+		t.Fatalf("recordRevision() error = %v", err)
+	}
+	if _, ok := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; !ok {
+		t.Error("expected the image policy to stay paused for the restored spec")
+	}
+
+	svc.Spec.Args = []string{"--verbose"}
+	if err := recordRevision(svc, specs); err != nil {
+		t.Fatalf("recordRevision() error = %v", err)
+	}
+	if _, ok := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; ok {
+		t.Error("expected a changed spec to resume the image policy")
+	}
+}
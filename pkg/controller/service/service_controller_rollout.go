@@ -0,0 +1,191 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"strconv"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/types"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+// checkRollout inspects the rollout of the latest revision. A completed rollout marks the revision as ready, a rollout
+// that exceeded its progress deadline marks the service as degraded and restores the last ready revision, if
+// autoRollback is enabled.
+func (r *ReconcileService) checkRollout(svc *appsv1alpha1.Service, reqLogger logr.Logger) error {
+	latest := svc.Status.Revisions.Latest()
+	if latest == nil || isCronJob(svc) {
+		return nil
+	}
+
+	if canaryInProgress(svc) {
+		return r.checkCanaryRollout(svc, latest.Revision, reqLogger)
+	}
+
+	if isStatefulSet(svc) {
+		ready, err := r.workloadReady(svc)
+		if err != nil {
+			return err
+		}
+		if ready {
+			svc.Status.LastReadyRevision = latest.Revision
+		}
+
+		// statefulsets have no progress deadline, so their rollout never fails
+		return nil
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: rolloutDeploymentName(svc)}, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return nil
+		}
+		return fmt.Errorf("failed to get deployment: %v", err)
+	}
+
+	// the deployment does not yet run the latest revision
+	if dep.Annotations[revisionAnnotation] != strconv.FormatInt(latest.Revision, 10) {
+		return nil
+	}
+
+	switch {
+	case deploymentComplete(dep):
+		svc.Status.LastReadyRevision = latest.Revision
+		svc.Status.Conditions.Set(appsv1alpha1.Condition{
+			Type:   appsv1alpha1.ConditionDegraded,
+			Status: corev1.ConditionFalse,
+			Reason: "RolloutComplete",
+		})
+
+	case deploymentFailed(dep):
+		return r.handleFailedRollout(svc, latest.Revision, reqLogger)
+	}
+
+	return nil
+}
+
+func (r *ReconcileService) handleFailedRollout(svc *appsv1alpha1.Service, revision int64, reqLogger logr.Logger) error {
+	message := fmt.Sprintf("Rollout of revision %d exceeded its progress deadline", revision)
+	lastReady := svc.Status.LastReadyRevision
+
+	if !svc.Spec.AutoRollback || lastReady == 0 || lastReady == revision {
+		if svc.Status.Conditions.Set(degradedCondition("ProgressDeadlineExceeded", message)) {
+			reqLogger.Info(message)
+			r.recorder.Event(svc, corev1.EventTypeWarning, "ProgressDeadlineExceeded", message)
+		}
+		return nil
+	}
+
+	status := svc.Status.DeepCopy()
+
+	resolvedImage, err := r.restoreRevision(svc, lastReady)
+	if err != nil {
+		message = fmt.Sprintf("%s, failed to roll back to revision %d: %v", message, lastReady, err)
+		if svc.Status.Conditions.Set(degradedCondition("RollbackFailed", message)) {
+			reqLogger.Info(message)
+			r.recorder.Event(svc, corev1.EventTypeWarning, "RollbackFailed", message)
+		}
+		return nil
+	}
+
+	if err := r.client.Update(context.TODO(), svc); err != nil {
+		return fmt.Errorf("failed to roll back to revision %d: %v", lastReady, err)
+	}
+
+	// the update reloaded the status from the API, so the local changes are restored
+	svc.Status = *status
+	if resolvedImage != nil {
+		svc.Status.ResolvedImage = resolvedImage
+	}
+
+	message = fmt.Sprintf("%s, rolled back to revision %d", message, lastReady)
+	svc.Status.Conditions.Set(degradedCondition("RolledBack", message))
+
+	reqLogger.Info(message)
+	r.recorder.Event(svc, corev1.EventTypeWarning, "AutoRollback", message)
+
+	return nil
+}
+
+func degradedCondition(reason, message string) appsv1alpha1.Condition {
+	return appsv1alpha1.Condition{
+		Type:    appsv1alpha1.ConditionDegraded,
+		Status:  corev1.ConditionTrue,
+		Reason:  reason,
+		Message: message,
+	}
+}
+
+// deploymentComplete returns whether all replicas of the deployment are updated and available
+func deploymentComplete(dep *appsv1.Deployment) bool {
+	if dep.Status.ObservedGeneration < dep.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if dep.Spec.Replicas != nil {
+		replicas = *dep.Spec.Replicas
+	}
+
+	return dep.Status.UpdatedReplicas == replicas &&
+		dep.Status.Replicas == replicas &&
+		dep.Status.AvailableReplicas == replicas
+}
+
+// deploymentFailed returns whether the rollout of the deployment exceeded its progress deadline
+func deploymentFailed(dep *appsv1.Deployment) bool {
+	if dep.Status.ObservedGeneration < dep.Generation {
+		return false
+	}
+
+	for _, condition := range dep.Status.Conditions {
+		if condition.Type == appsv1.DeploymentProgressing && condition.Reason == progressDeadlineExceeded {
+			return true
+		}
+	}
+
+	return false
+}
+
+// workloadReady returns whether the workload the latest revision is rolled out to runs that revision on all replicas
+func (r *ReconcileService) workloadReady(svc *appsv1alpha1.Service) (bool, error) {
+	// runs of cron jobs are not rolled out, so there is nothing to wait for
+	latest := svc.Status.Revisions.Latest()
+	if latest == nil || isCronJob(svc) {
+		return true, nil
+	}
+	revision := strconv.FormatInt(latest.Revision, 10)
+
+	if isStatefulSet(svc) {
+		sts := &appsv1.StatefulSet{}
+		if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: svc.Name}, sts); err != nil {
+			if errors.IsNotFound(err) {
+				return false, nil
+			}
+			return false, fmt.Errorf("failed to get statefulset: %v", err)
+		}
+
+		return sts.Annotations[revisionAnnotation] == revision && statefulSetComplete(sts), nil
+	}
+
+	dep := &appsv1.Deployment{}
+	if err := r.client.Get(context.TODO(), types.NamespacedName{Namespace: svc.Namespace, Name: rolloutDeploymentName(svc)}, dep); err != nil {
+		if errors.IsNotFound(err) {
+			return false, nil
+		}
+		return false, fmt.Errorf("failed to get deployment: %v", err)
+	}
+
+	return dep.Annotations[revisionAnnotation] == revision && deploymentComplete(dep), nil
+}
+
+// isWorkload returns whether the managed object runs the pods of the service
+func isWorkload(managedObject *appsv1alpha1.ManagedObject) bool {
+	gvk := managedObject.GroupVersionKind()
+	return gvk.Group == appsv1.GroupName && (gvk.Kind == "Deployment" || gvk.Kind == "StatefulSet")
+}
@@ -0,0 +1,128 @@
+package service
+
+import (
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func newRolloutDeployment(revision string, status appsv1.DeploymentStatus) *appsv1.Deployment {
+	return &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			Annotations: map[string]string{revisionAnnotation: revision},
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: ptrThree},
+		Status: status,
+	}
+}
+
+func TestReconcileService_checkRollout(t *testing.T) {
+	failed := appsv1.DeploymentStatus{
+		Replicas:        4,
+		UpdatedReplicas: 1,
+		Conditions: []appsv1.DeploymentCondition{
+			{Type: appsv1.DeploymentProgressing, Status: corev1.ConditionFalse, Reason: progressDeadlineExceeded},
+		},
+	}
+	complete := appsv1.DeploymentStatus{Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 3}
+
+	tests := []struct {
+		name              string
+		autoRollback      bool
+		deployment        *appsv1.Deployment
+		wantImage         string
+		wantLastReady     int64
+		wantDegraded      bool
+		wantDegradedCause string
+		wantPaused        bool
+	}{
+		{
+			name:          "complete rollout marks revision as ready",
+			deployment:    newRolloutDeployment("2", complete),
+			wantImage:     "app:2",
+			wantLastReady: 2,
+		},
+		{
+			name:          "rollout of older revision is ignored",
+			deployment:    newRolloutDeployment("1", failed),
+			wantImage:     "app:2",
+			wantLastReady: 1,
+		},
+		{
+			name:              "failed rollout without auto rollback",
+			deployment:        newRolloutDeployment("2", failed),
+			wantImage:         "app:2",
+			wantLastReady:     1,
+			wantDegraded:      true,
+			wantDegradedCause: "ProgressDeadlineExceeded",
+		},
+		{
+			name:              "failed rollout with auto rollback",
+			autoRollback:      true,
+			deployment:        newRolloutDeployment("2", failed),
+			wantImage:         "app:1",
+			wantLastReady:     1,
+			wantDegraded:      true,
+			wantDegradedCause: "RolledBack",
+			wantPaused:        true,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := runtime.NewScheme()
+			_ = scheme.AddToScheme(s)
+			_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1alpha1.ServiceSpec{Image: "app:2", AutoRollback: tt.autoRollback},
+				Status: appsv1alpha1.ServiceStatus{
+					LastReadyRevision: 1,
+					Revisions: appsv1alpha1.RevisionList{
+						{Revision: 1, Image: "app:1"},
+						{Revision: 2, Image: "app:2"},
+					},
+				},
+			}
+
+			c := fake.NewFakeClientWithScheme(s, svc.DeepCopy(), tt.deployment, &corev1.ConfigMap{
+				ObjectMeta: metav1.ObjectMeta{Name: "app-revisions", Namespace: "default"},
+				Data:       map[string]string{"1": `{"image":"app:1","autoRollback":true,"imagePolicy":{"semver":"^1"}}`},
+			})
+			r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+			if err := r.checkRollout(svc, log); err != nil {
+				t.Fatalf("checkRollout() error = %v", err)
+			}
+
+			if svc.Spec.Image != tt.wantImage {
+				t.Errorf("image = %v, want %v", svc.Spec.Image, tt.wantImage)
+			}
+			if svc.Status.LastReadyRevision != tt.wantLastReady {
+				t.Errorf("lastReadyRevision = %v, want %v", svc.Status.LastReadyRevision, tt.wantLastReady)
+			}
+			if got := svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionDegraded); got != tt.wantDegraded {
+				t.Errorf("degraded = %v, want %v", got, tt.wantDegraded)
+			}
+			if tt.wantDegraded {
+				if got := svc.Status.Conditions.Find(appsv1alpha1.ConditionDegraded).Reason; got != tt.wantDegradedCause {
+					t.Errorf("reason = %v, want %v", got, tt.wantDegradedCause)
+				}
+			}
+			if _, paused := svc.Annotations[appsv1alpha1.ImagePolicyPausedAnnotation]; paused != tt.wantPaused {
+				t.Errorf("image policy paused = %v, want %v", paused, tt.wantPaused)
+			}
+		})
+	}
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+// antiAffinityMode returns the anti-affinity mode of the service, falling back to the deployment config
+func antiAffinityMode(svc *appsv1alpha1.Service) appsv1alpha1.AntiAffinityMode {
+	if svc.Spec.AntiAffinity != "" {
+		return svc.Spec.AntiAffinity
+	}
+	if config.Config.Deployment.AntiAffinity != "" {
+		return appsv1alpha1.AntiAffinityMode(config.Config.Deployment.AntiAffinity)
+	}
+
+	return appsv1alpha1.AntiAffinityPreferred
+}
+
+// makeAffinity returns the pod anti-affinity keeping the pods of the service on different nodes, nil if it is disabled
+func (b *objectBuilder) makeAffinity(svc *appsv1alpha1.Service) *corev1.Affinity {
+	term := corev1.PodAffinityTerm{
+		TopologyKey: "kubernetes.io/hostname",
+		LabelSelector: &metav1.LabelSelector{
+			MatchLabels: b.makeKubelixLabels(svc),
+		},
+	}
+
+	switch antiAffinityMode(svc) {
+	case appsv1alpha1.AntiAffinityDisabled:
+		return nil
+
+	case appsv1alpha1.AntiAffinityRequired:
+		return &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				RequiredDuringSchedulingIgnoredDuringExecution: []corev1.PodAffinityTerm{term},
+			},
+		}
+
+	default:
+		return &corev1.Affinity{
+			PodAntiAffinity: &corev1.PodAntiAffinity{
+				PreferredDuringSchedulingIgnoredDuringExecution: []corev1.WeightedPodAffinityTerm{
+					{
+						Weight:          100,
+						PodAffinityTerm: term,
+					},
+				},
+			},
+		}
+	}
+}
+
+// makeNodeSelector merges the node selector of the service into the one of the deployment config
+func makeNodeSelector(svc *appsv1alpha1.Service) map[string]string {
+	if len(config.Config.Deployment.NodeSelector) == 0 && len(svc.Spec.NodeSelector) == 0 {
+		return nil
+	}
+
+	return mergeLabels(config.Config.Deployment.NodeSelector, svc.Spec.NodeSelector)
+}
+
+// makeTolerations returns the tolerations of the deployment config together with those of the service
+func makeTolerations(svc *appsv1alpha1.Service) []corev1.Toleration {
+	if len(config.Config.Deployment.Tolerations) == 0 && len(svc.Spec.Tolerations) == 0 {
+		return nil
+	}
+
+	tolerations := make([]corev1.Toleration, 0, len(config.Config.Deployment.Tolerations)+len(svc.Spec.Tolerations))
+	tolerations = append(tolerations, config.Config.Deployment.Tolerations...)
+	return append(tolerations, svc.Spec.Tolerations...)
+}
+
+// makeTopologySpreadConstraints returns the constraints of the service or of the deployment config. Constraints
+// without a label selector select the pods of the service.
+func (b *objectBuilder) makeTopologySpreadConstraints(svc *appsv1alpha1.Service) []corev1.TopologySpreadConstraint {
+	source := svc.Spec.TopologySpreadConstraints
+	if len(source) == 0 {
+		source = config.Config.Deployment.TopologySpreadConstraints
+	}
+	if len(source) == 0 {
+		return nil
+	}
+
+	constraints := make([]corev1.TopologySpreadConstraint, len(source))
+	for i, constraint := range source {
+		constraints[i] = *constraint.DeepCopy()
+		if constraints[i].LabelSelector == nil {
+			constraints[i].LabelSelector = &metav1.LabelSelector{MatchLabels: b.makeKubelixLabels(svc)}
+		}
+	}
+
+	return constraints
+}
+
+// priorityClassName returns the priority class of the service, falling back to the deployment config
+func priorityClassName(svc *appsv1alpha1.Service) string {
+	if svc.Spec.PriorityClassName != "" {
+		return svc.Spec.PriorityClassName
+	}
+
+	return config.Config.Deployment.PriorityClassName
+}
@@ -0,0 +1,71 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_newPodTemplateForService_scheduling(t *testing.T) {
+	defer func(deployment config.DeploymentConfig) { config.Config.Deployment = deployment }(config.Config.Deployment)
+	config.Config.Deployment = config.DeploymentConfig{
+		NodeSelector: map[string]string{"pool": "general", "gpu": "false"},
+		Tolerations:  []corev1.Toleration{{Key: "dedicated", Operator: corev1.TolerationOpEqual, Value: "apps"}},
+		TopologySpreadConstraints: []corev1.TopologySpreadConstraint{
+			{MaxSkew: 1, TopologyKey: "topology.kubernetes.io/zone", WhenUnsatisfiable: corev1.ScheduleAnyway},
+		},
+		PriorityClassName: "apps",
+		AntiAffinity:      config.AntiAffinityRequired,
+	}
+
+	r := &ReconcileService{}
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec:       appsv1alpha1.ServiceSpec{Image: "app:1.0"},
+	}
+
+	spec := r.newPodTemplateForService(svc, nil).Spec
+	if !reflect.DeepEqual(spec.NodeSelector, config.Config.Deployment.NodeSelector) {
+		t.Errorf("node selector = %v, want %v", spec.NodeSelector, config.Config.Deployment.NodeSelector)
+	}
+	if len(spec.Tolerations) != 1 || spec.PriorityClassName != "apps" {
+		t.Errorf("unexpected tolerations %v and priority class %q", spec.Tolerations, spec.PriorityClassName)
+	}
+	if len(spec.TopologySpreadConstraints) != 1 || spec.TopologySpreadConstraints[0].LabelSelector == nil {
+		t.Fatalf("expected the constraint of the config to select the pods of the service, got %v", spec.TopologySpreadConstraints)
+	}
+	if !reflect.DeepEqual(spec.TopologySpreadConstraints[0].LabelSelector.MatchLabels, r.makeKubelixLabels(svc)) {
+		t.Errorf("unexpected label selector %v", spec.TopologySpreadConstraints[0].LabelSelector)
+	}
+	if config.Config.Deployment.TopologySpreadConstraints[0].LabelSelector != nil {
+		t.Errorf("the constraints of the config must not be modified")
+	}
+	if spec.Affinity == nil || len(spec.Affinity.PodAntiAffinity.RequiredDuringSchedulingIgnoredDuringExecution) != 1 {
+		t.Errorf("expected a required anti-affinity, got %+v", spec.Affinity)
+	}
+
+	svc.Spec.NodeSelector = map[string]string{"pool": "batch"}
+	svc.Spec.Tolerations = []corev1.Toleration{{Key: "batch", Operator: corev1.TolerationOpExists}}
+	svc.Spec.PriorityClassName = "low"
+	svc.Spec.AntiAffinity = appsv1alpha1.AntiAffinityDisabled
+
+	spec = r.newPodTemplateForService(svc, nil).Spec
+	if want := map[string]string{"pool": "batch", "gpu": "false"}; !reflect.DeepEqual(spec.NodeSelector, want) {
+		t.Errorf("node selector = %v, want %v", spec.NodeSelector, want)
+	}
+	if len(spec.Tolerations) != 2 || spec.PriorityClassName != "low" || spec.Affinity != nil {
+		t.Errorf("unexpected tolerations %v, priority class %q and affinity %+v", spec.Tolerations, spec.PriorityClassName, spec.Affinity)
+	}
+}
+
+func Test_antiAffinityMode(t *testing.T) {
+	svc := &appsv1alpha1.Service{}
+	if got := antiAffinityMode(svc); got != appsv1alpha1.AntiAffinityPreferred {
+		t.Errorf("antiAffinityMode() = %v, want %v", got, appsv1alpha1.AntiAffinityPreferred)
+	}
+}
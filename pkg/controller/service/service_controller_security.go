@@ -0,0 +1,125 @@
+package service
+
+import (
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+// securityProfile returns the profile providing the default security settings of the service
+func securityProfile(svc *appsv1alpha1.Service) appsv1alpha1.SecurityProfile {
+	if sc := svc.Spec.SecurityContext; sc != nil && sc.Profile != "" {
+		return sc.Profile
+	}
+
+	return appsv1alpha1.SecurityProfile(config.Config.SecurityProfile)
+}
+
+// hardenedSecurityContext returns the settings of the hardened profile, which satisfy the restricted pod security
+// standard
+func hardenedSecurityContext() appsv1alpha1.SecurityContext {
+	return appsv1alpha1.SecurityContext{
+		RunAsNonRoot:             ptrBool(true),
+		AllowPrivilegeEscalation: ptrBool(false),
+		Capabilities: &corev1.Capabilities{
+			Drop: []corev1.Capability{"ALL"},
+		},
+		SeccompProfile: &appsv1alpha1.SeccompProfile{Type: appsv1alpha1.SeccompProfileRuntimeDefault},
+	}
+}
+
+// makeSecurityContext merges the security context of the service into the settings of its profile
+func makeSecurityContext(svc *appsv1alpha1.Service) appsv1alpha1.SecurityContext {
+	sc := appsv1alpha1.SecurityContext{}
+	if securityProfile(svc) == appsv1alpha1.SecurityProfileHardened {
+		sc = hardenedSecurityContext()
+	}
+
+	override := svc.Spec.SecurityContext
+	if override == nil {
+		return sc
+	}
+
+	if override.RunAsUser != nil {
+		sc.RunAsUser = override.RunAsUser
+	}
+	if override.RunAsGroup != nil {
+		sc.RunAsGroup = override.RunAsGroup
+	}
+	if override.RunAsNonRoot != nil {
+		sc.RunAsNonRoot = override.RunAsNonRoot
+	}
+	if override.FSGroup != nil {
+		sc.FSGroup = override.FSGroup
+	}
+	if override.ReadOnlyRootFilesystem != nil {
+		sc.ReadOnlyRootFilesystem = override.ReadOnlyRootFilesystem
+	}
+	if override.AllowPrivilegeEscalation != nil {
+		sc.AllowPrivilegeEscalation = override.AllowPrivilegeEscalation
+	}
+	if override.SeccompProfile != nil {
+		sc.SeccompProfile = override.SeccompProfile
+	}
+
+	// added capabilities do not discard the capabilities dropped by the profile
+	if override.Capabilities != nil {
+		capabilities := &corev1.Capabilities{Add: override.Capabilities.Add, Drop: override.Capabilities.Drop}
+		if capabilities.Drop == nil && sc.Capabilities != nil {
+			capabilities.Drop = sc.Capabilities.Drop
+		}
+		sc.Capabilities = capabilities
+	}
+
+	return sc
+}
+
+// podSecurityContext returns the security context of the pods, nil if there are no settings
+func podSecurityContext(sc appsv1alpha1.SecurityContext) *corev1.PodSecurityContext {
+	if sc.RunAsUser == nil && sc.RunAsGroup == nil && sc.RunAsNonRoot == nil && sc.FSGroup == nil {
+		return nil
+	}
+
+	return &corev1.PodSecurityContext{
+		RunAsUser:    sc.RunAsUser,
+		RunAsGroup:   sc.RunAsGroup,
+		RunAsNonRoot: sc.RunAsNonRoot,
+		FSGroup:      sc.FSGroup,
+	}
+}
+
+// containerSecurityContext returns the security context of the app container, nil if there are no settings
+func containerSecurityContext(sc appsv1alpha1.SecurityContext) *corev1.SecurityContext {
+	if sc.ReadOnlyRootFilesystem == nil && sc.AllowPrivilegeEscalation == nil && sc.Capabilities == nil {
+		return nil
+	}
+
+	return &corev1.SecurityContext{
+		ReadOnlyRootFilesystem:   sc.ReadOnlyRootFilesystem,
+		AllowPrivilegeEscalation: sc.AllowPrivilegeEscalation,
+		Capabilities:             sc.Capabilities,
+	}
+}
+
+// makeSeccompAnnotations returns the pod annotation selecting the seccomp profile, as the supported kubernetes
+// versions have no field for it yet
+func makeSeccompAnnotations(sc appsv1alpha1.SecurityContext) map[string]string {
+	if sc.SeccompProfile == nil {
+		return nil
+	}
+
+	var profile string
+	switch sc.SeccompProfile.Type {
+	case appsv1alpha1.SeccompProfileRuntimeDefault:
+		profile = "runtime/default"
+	case appsv1alpha1.SeccompProfileUnconfined:
+		profile = "unconfined"
+	case appsv1alpha1.SeccompProfileLocalhost:
+		profile = "localhost/" + sc.SeccompProfile.LocalhostProfile
+	default:
+		return nil
+	}
+
+	return map[string]string{seccompPodAnnotation: profile}
+}
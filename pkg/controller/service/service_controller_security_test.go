@@ -0,0 +1,89 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_newPodTemplateForService_securityContext(t *testing.T) {
+	defer func(profile config.SecurityProfile) { config.Config.SecurityProfile = profile }(config.Config.SecurityProfile)
+	config.Config.SecurityProfile = config.SecurityProfileHardened
+
+	r := &ReconcileService{}
+
+	tests := []struct {
+		name            string
+		securityContext *appsv1alpha1.SecurityContext
+		wantPod         *corev1.PodSecurityContext
+		wantContainer   *corev1.SecurityContext
+		wantAnnotations map[string]string
+	}{
+		{
+			name:    "hardened default",
+			wantPod: &corev1.PodSecurityContext{RunAsNonRoot: ptrBool(true)},
+			wantContainer: &corev1.SecurityContext{
+				AllowPrivilegeEscalation: ptrBool(false),
+				Capabilities:             &corev1.Capabilities{Drop: []corev1.Capability{"ALL"}},
+			},
+			wantAnnotations: map[string]string{seccompPodAnnotation: "runtime/default"},
+		},
+		{
+			name: "settings of the service take precedence",
+			securityContext: &appsv1alpha1.SecurityContext{
+				RunAsUser:              ptrInt64(1000),
+				FSGroup:                ptrInt64(2000),
+				ReadOnlyRootFilesystem: ptrBool(true),
+				Capabilities:           &corev1.Capabilities{Add: []corev1.Capability{"NET_BIND_SERVICE"}},
+				SeccompProfile:         &appsv1alpha1.SeccompProfile{Type: appsv1alpha1.SeccompProfileLocalhost, LocalhostProfile: "app.json"},
+			},
+			wantPod: &corev1.PodSecurityContext{RunAsUser: ptrInt64(1000), RunAsNonRoot: ptrBool(true), FSGroup: ptrInt64(2000)},
+			wantContainer: &corev1.SecurityContext{
+				ReadOnlyRootFilesystem:   ptrBool(true),
+				AllowPrivilegeEscalation: ptrBool(false),
+				Capabilities: &corev1.Capabilities{
+					Add:  []corev1.Capability{"NET_BIND_SERVICE"},
+					Drop: []corev1.Capability{"ALL"},
+				},
+			},
+			wantAnnotations: map[string]string{seccompPodAnnotation: "localhost/app.json"},
+		},
+		{
+			name:            "opt out of the profile",
+			securityContext: &appsv1alpha1.SecurityContext{Profile: appsv1alpha1.SecurityProfileNone},
+		},
+		{
+			name: "opt out keeps explicit settings",
+			securityContext: &appsv1alpha1.SecurityContext{
+				Profile:    appsv1alpha1.SecurityProfileNone,
+				RunAsGroup: ptrInt64(3000),
+			},
+			wantPod: &corev1.PodSecurityContext{RunAsGroup: ptrInt64(3000)},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &appsv1alpha1.Service{
+				ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+				Spec:       appsv1alpha1.ServiceSpec{Image: "app:1.0", SecurityContext: tt.securityContext},
+			}
+
+			template := r.newPodTemplateForService(svc, nil)
+			if got := template.Spec.SecurityContext; !reflect.DeepEqual(got, tt.wantPod) {
+				t.Errorf("pod security context = %+v, want %+v", got, tt.wantPod)
+			}
+			if got := template.Spec.Containers[0].SecurityContext; !reflect.DeepEqual(got, tt.wantContainer) {
+				t.Errorf("container security context = %+v, want %+v", got, tt.wantContainer)
+			}
+			if got := template.Annotations; !reflect.DeepEqual(got, tt.wantAnnotations) {
+				t.Errorf("annotations = %v, want %v", got, tt.wantAnnotations)
+			}
+		})
+	}
+}
@@ -0,0 +1,274 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+// ensureServiceAccount creates the service account of the service and the role and role binding granting its rules
+func (r *ReconcileService) ensureServiceAccount(svc *appsv1alpha1.Service, reqLogger logr.Logger) ([]runtime.Object, error) {
+	sa := svc.Spec.ServiceAccount
+	if sa == nil {
+		resolveRulesRejected(svc)
+		return nil, nil
+	}
+
+	if err := validateServiceAccount(svc); err != nil {
+		return nil, validationFailed(svc, "serviceAccount", err)
+	}
+
+	if err := validateRules(sa.Rules); err != nil {
+		return nil, r.rejectRules(reqLogger, svc, validationFailed(svc, "serviceAccount", err))
+	}
+	resolveRulesRejected(svc)
+
+	objects := make([]runtime.Object, 0, 3)
+
+	if sa.Create {
+		serviceAccount, err := r.newServiceAccountForService(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		name := types.NamespacedName{Name: serviceAccount.Name, Namespace: serviceAccount.Namespace}
+		if err := r.ensureObject(reqLogger, svc, serviceAccount, name); err != nil {
+			return nil, fmt.Errorf("failed to handle service account: %v", err)
+		}
+		objects = append(objects, serviceAccount)
+	}
+
+	if len(sa.Rules) > 0 {
+		role, err := r.newRoleForService(svc)
+		if err != nil {
+			return nil, err
+		}
+
+		name := types.NamespacedName{Name: role.Name, Namespace: role.Namespace}
+		if err := r.ensureObject(reqLogger, svc, role, name); err != nil {
+			return nil, fmt.Errorf("failed to handle role: %v", err)
+		}
+		objects = append(objects, role)
+
+		binding, err := r.newRoleBindingForService(svc, role)
+		if err != nil {
+			return nil, err
+		}
+
+		name = types.NamespacedName{Name: binding.Name, Namespace: binding.Namespace}
+		if err := r.ensureObject(reqLogger, svc, binding, name); err != nil {
+			return nil, fmt.Errorf("failed to handle role binding: %v", err)
+		}
+		objects = append(objects, binding)
+	}
+
+	return objects, nil
+}
+
+// validateServiceAccount checks that the rules are granted to a service account
+func validateServiceAccount(svc *appsv1alpha1.Service) error {
+	sa := svc.Spec.ServiceAccount
+	if sa != nil && len(sa.Rules) > 0 && serviceAccountName(svc) == "" {
+		return fmt.Errorf("serviceAccount.rules need either serviceAccount.create or serviceAccountName")
+	}
+
+	return nil
+}
+
+// validateRules checks that each permission granted by the rules is covered by the allowed rules of the config. The
+// deployer may only create roles with permissions it holds itself, which would still include all secrets.
+func validateRules(rules []rbacv1.PolicyRule) error {
+	allowed := config.Config.ServiceAccount.AllowedRules
+
+	for i, rule := range rules {
+		if len(rule.NonResourceURLs) > 0 {
+			return fmt.Errorf("serviceAccount.rules[%d] grants non-resource urls, which roles can not grant", i)
+		}
+
+		for _, group := range rule.APIGroups {
+			for _, resource := range rule.Resources {
+				for _, verb := range rule.Verbs {
+					if !rulesAllow(allowed, group, resource, verb, rule.ResourceNames) {
+						return fmt.Errorf("serviceAccount.rules[%d] grants %s on %s, which is not allowed by the deployer config",
+							i, verb, groupResource(group, resource))
+					}
+				}
+			}
+		}
+	}
+
+	return nil
+}
+
+// rulesAllow returns whether one of the rules grants the verb on the resource. Wildcards of the rules match anything,
+// a wildcard in the request is only matched by a wildcard. Rules limited to resource names only allow these names.
+func rulesAllow(rules []rbacv1.PolicyRule, group, resource, verb string, resourceNames []string) bool {
+	for _, rule := range rules {
+		if !containsOrWildcard(rule.APIGroups, group) || !containsOrWildcard(rule.Resources, resource) || !containsOrWildcard(rule.Verbs, verb) {
+			continue
+		}
+
+		if len(rule.ResourceNames) == 0 {
+			return true
+		}
+		if len(resourceNames) == 0 {
+			continue
+		}
+
+		covered := true
+		for _, name := range resourceNames {
+			covered = covered && containsOrWildcard(rule.ResourceNames, name)
+		}
+		if covered {
+			return true
+		}
+	}
+
+	return false
+}
+
+func containsOrWildcard(values []string, value string) bool {
+	for _, v := range values {
+		if v == rbacv1.ResourceAll || v == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+func groupResource(group, resource string) string {
+	if group == "" {
+		return resource
+	}
+
+	return resource + "." + group
+}
+
+// rejectRules reports rules exceeding the allowed rules in the conditions of the service. No role is created or
+// updated until the rules are fixed.
+func (r *ReconcileService) rejectRules(reqLogger logr.Logger, svc *appsv1alpha1.Service, err error) error {
+	changed := svc.Status.Conditions.Set(appsv1alpha1.Condition{
+		Type:    appsv1alpha1.ConditionRulesRejected,
+		Status:  corev1.ConditionTrue,
+		Reason:  "NotAllowed",
+		Message: err.Error(),
+	})
+	if changed {
+		r.recorder.Event(svc, corev1.EventTypeWarning, "RulesRejected", err.Error())
+	}
+
+	if err := r.update(reqLogger, svc); err != nil {
+		return err
+	}
+
+	return err
+}
+
+// resolveRulesRejected resets the condition once the rules are allowed again
+func resolveRulesRejected(svc *appsv1alpha1.Service) {
+	if svc.Status.Conditions.Find(appsv1alpha1.ConditionRulesRejected) == nil {
+		return
+	}
+
+	svc.Status.Conditions.Set(appsv1alpha1.Condition{
+		Type:   appsv1alpha1.ConditionRulesRejected,
+		Status: corev1.ConditionFalse,
+		Reason: "Resolved",
+	})
+}
+
+// serviceAccountName returns the name of the service account the pods run with, empty for the default account
+func serviceAccountName(svc *appsv1alpha1.Service) string {
+	if svc.Spec.ServiceAccountName != "" {
+		return svc.Spec.ServiceAccountName
+	}
+	if svc.Spec.ServiceAccount != nil && svc.Spec.ServiceAccount.Create {
+		return svc.Name
+	}
+
+	return ""
+}
+
+func (b *objectBuilder) newServiceAccountForService(svc *appsv1alpha1.Service) (*corev1.ServiceAccount, error) {
+	serviceAccount := &corev1.ServiceAccount{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "ServiceAccount",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        serviceAccountName(svc),
+			Namespace:   svc.Namespace,
+			Labels:      b.makeLabels(svc),
+			Annotations: svc.Spec.ServiceAccount.Annotations,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(svc, serviceAccount, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return serviceAccount, nil
+}
+
+func (b *objectBuilder) newRoleForService(svc *appsv1alpha1.Service) (*rbacv1.Role, error) {
+	role := &rbacv1.Role{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "Role",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    b.makeLabels(svc),
+		},
+		Rules: svc.Spec.ServiceAccount.Rules,
+	}
+
+	if err := controllerutil.SetControllerReference(svc, role, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return role, nil
+}
+
+func (b *objectBuilder) newRoleBindingForService(svc *appsv1alpha1.Service, role *rbacv1.Role) (*rbacv1.RoleBinding, error) {
+	binding := &rbacv1.RoleBinding{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: rbacv1.SchemeGroupVersion.String(),
+			Kind:       "RoleBinding",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    b.makeLabels(svc),
+		},
+		Subjects: []rbacv1.Subject{
+			{
+				Kind:      rbacv1.ServiceAccountKind,
+				Name:      serviceAccountName(svc),
+				Namespace: svc.Namespace,
+			},
+		},
+		RoleRef: rbacv1.RoleRef{
+			APIGroup: rbacv1.GroupName,
+			Kind:     "Role",
+			Name:     role.Name,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(svc, binding, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return binding, nil
+}
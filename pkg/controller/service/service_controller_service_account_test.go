@@ -0,0 +1,153 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/config"
+)
+
+func TestReconcileService_ensureServiceAccount(t *testing.T) {
+	defer func(cfg config.ServiceAccountConfig) { config.Config.ServiceAccount = cfg }(config.Config.ServiceAccount)
+	config.Config.ServiceAccount = config.NewConfig().ServiceAccount
+
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Image: "worker:1.0",
+			ServiceAccount: &appsv1alpha1.ServiceAccount{
+				Create:      true,
+				Annotations: map[string]string{"iam.gke.io/gcp-service-account": "worker@example.iam.gserviceaccount.com"},
+				Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get", "watch"}},
+				},
+			},
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	objects, err := r.ensureServiceAccount(svc, log)
+	if err != nil {
+		t.Fatalf("ensureServiceAccount() error = %v", err)
+	}
+	if len(objects) != 3 {
+		t.Fatalf("expected a service account, a role and a role binding, got %d objects", len(objects))
+	}
+
+	name := types.NamespacedName{Name: "worker", Namespace: "default"}
+
+	sa := &corev1.ServiceAccount{}
+	if err := c.Get(context.TODO(), name, sa); err != nil {
+		t.Fatalf("failed to get service account: %v", err)
+	}
+	if sa.Annotations["iam.gke.io/gcp-service-account"] == "" {
+		t.Errorf("expected the annotations of the service account to be set, got %v", sa.Annotations)
+	}
+
+	binding := &rbacv1.RoleBinding{}
+	if err := c.Get(context.TODO(), name, binding); err != nil {
+		t.Fatalf("failed to get role binding: %v", err)
+	}
+	if binding.RoleRef.Name != "worker" || binding.Subjects[0].Name != "worker" {
+		t.Errorf("unexpected role binding %+v", binding)
+	}
+
+	if got := r.newPodTemplateForService(svc, nil).Spec.ServiceAccountName; got != "worker" {
+		t.Errorf("service account of the pods = %q, want worker", got)
+	}
+
+	// rules need a service account to be bound to
+	svc.Spec.ServiceAccount.Create = false
+	if _, err := r.ensureServiceAccount(svc, log); err == nil {
+		t.Errorf("expected an error for rules without a service account")
+	}
+}
+
+func TestReconcileService_ensureServiceAccount_rejectedRules(t *testing.T) {
+	defer func(cfg config.ServiceAccountConfig) { config.Config.ServiceAccount = cfg }(config.Config.ServiceAccount)
+	config.Config.ServiceAccount = config.NewConfig().ServiceAccount
+
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "worker", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			ServiceAccount: &appsv1alpha1.ServiceAccount{
+				Create: true,
+				Rules: []rbacv1.PolicyRule{
+					{APIGroups: []string{""}, Resources: []string{"configmaps"}, Verbs: []string{"get"}},
+					{APIGroups: []string{"*"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+				},
+			},
+		},
+	}
+
+	c := fake.NewFakeClientWithScheme(s, svc)
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+
+	if _, err := r.ensureServiceAccount(svc, log); err == nil {
+		t.Fatal("expected the rules to be rejected")
+	}
+	if !svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionRulesRejected) {
+		t.Errorf("expected the %s condition, got %+v", appsv1alpha1.ConditionRulesRejected, svc.Status.Conditions)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "worker", Namespace: "default"}, &rbacv1.Role{}); err == nil {
+		t.Error("expected no role to be created")
+	}
+
+	svc.Spec.ServiceAccount.Rules = svc.Spec.ServiceAccount.Rules[:1]
+	if _, err := r.ensureServiceAccount(svc, log); err != nil {
+		t.Fatalf("ensureServiceAccount() error = %v", err)
+	}
+	if svc.Status.Conditions.IsTrue(appsv1alpha1.ConditionRulesRejected) {
+		t.Error("expected the condition to be resolved")
+	}
+}
+
+func Test_validateRules(t *testing.T) {
+	defer func(cfg config.ServiceAccountConfig) { config.Config.ServiceAccount = cfg }(config.Config.ServiceAccount)
+	config.Config.ServiceAccount.AllowedRules = []rbacv1.PolicyRule{
+		{APIGroups: []string{""}, Resources: []string{"configmaps", "pods"}, Verbs: []string{"get", "list"}},
+		{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}, ResourceNames: []string{"public"}},
+		{APIGroups: []string{"batch"}, Resources: []string{"*"}, Verbs: []string{"*"}},
+	}
+
+	tests := []struct {
+		name    string
+		rule    rbacv1.PolicyRule
+		wantErr bool
+	}{
+		{name: "covered", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get", "list"}}},
+		{name: "other_verb", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"delete"}}, wantErr: true},
+		{name: "wildcard_request", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"*"}, Verbs: []string{"get"}}, wantErr: true},
+		{name: "wildcard_allowed", rule: rbacv1.PolicyRule{APIGroups: []string{"batch"}, Resources: []string{"jobs"}, Verbs: []string{"*"}}},
+		{name: "resource_name", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}, ResourceNames: []string{"public"}}},
+		{name: "all_resource_names", rule: rbacv1.PolicyRule{APIGroups: []string{""}, Resources: []string{"secrets"}, Verbs: []string{"get"}}, wantErr: true},
+		{name: "non_resource_url", rule: rbacv1.PolicyRule{NonResourceURLs: []string{"/metrics"}, Verbs: []string{"get"}}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if err := validateRules([]rbacv1.PolicyRule{tt.rule}); (err != nil) != tt.wantErr {
+				t.Errorf("validateRules() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
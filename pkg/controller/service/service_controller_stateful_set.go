@@ -0,0 +1,200 @@
+package service
+
+import (
+	"context"
+	"fmt"
+	"reflect"
+
+	"github.com/go-logr/logr"
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+// isStatefulSet returns whether the pods of the service are run by a statefulset
+func isStatefulSet(svc *appsv1alpha1.Service) bool {
+	return svc.Spec.WorkloadKind == appsv1alpha1.WorkloadKindStatefulSet
+}
+
+// rolloutStrategy returns the rollout strategy of the service. Statefulsets and cron jobs are always updated in place.
+func rolloutStrategy(svc *appsv1alpha1.Service) appsv1alpha1.Strategy {
+	if isStatefulSet(svc) || isCronJob(svc) || svc.Spec.Strategy == "" {
+		return appsv1alpha1.StrategyRollingUpdate
+	}
+
+	return svc.Spec.Strategy
+}
+
+// ensureStatefulSet creates the statefulset of the service together with the headless service governing it
+func (r *ReconcileService) ensureStatefulSet(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret, reqLogger logr.Logger) ([]runtime.Object, error) {
+	headless, err := r.newHeadlessServiceForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	sts, err := r.newStatefulSetForService(svc, dockerPullSecrets)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := []runtime.Object{headless, sts}
+	for _, obj := range objects {
+		meta := obj.(metav1.Object)
+		name := types.NamespacedName{Name: meta.GetName(), Namespace: meta.GetNamespace()}
+		if err := r.ensureObject(reqLogger, svc, obj, name); err != nil {
+			return nil, fmt.Errorf("failed to handle statefulset: %v", err)
+		}
+	}
+
+	return objects, nil
+}
+
+func (b *objectBuilder) newStatefulSetForService(svc *appsv1alpha1.Service, dockerPullSecrets []*corev1.Secret) (*appsv1.StatefulSet, error) {
+	labels := b.makeLabels(svc)
+	template := b.newPodTemplateForService(svc, dockerPullSecrets)
+	template.Labels = mergeLabels(template.Labels, map[string]string{workloadLabel: workloadStatefulSet})
+	claims := make([]corev1.PersistentVolumeClaim, 0, len(svc.Spec.VolumeClaimTemplates))
+
+	for _, claim := range svc.Spec.VolumeClaimTemplates {
+		claims = append(claims, corev1.PersistentVolumeClaim{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:   claim.Name,
+				Labels: labels,
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{corev1.ReadWriteOnce},
+				StorageClassName: claim.StorageClassName,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: claim.Size},
+				},
+			},
+		})
+
+		template.Spec.Containers[0].VolumeMounts = append(template.Spec.Containers[0].VolumeMounts, corev1.VolumeMount{
+			Name:      claim.Name,
+			MountPath: claim.MountPath,
+		})
+	}
+
+	sts := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1.SchemeGroupVersion.String(),
+			Kind:       "StatefulSet",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      svc.Name,
+			Namespace: svc.Namespace,
+			Labels:    labels,
+		},
+		Spec: appsv1.StatefulSetSpec{
+			ServiceName:          headlessServiceName(svc),
+			RevisionHistoryLimit: ptrInt32(3),
+			Selector: &metav1.LabelSelector{
+				MatchLabels: mergeLabels(labels, map[string]string{workloadLabel: workloadStatefulSet}),
+			},
+			Template:             template,
+			VolumeClaimTemplates: claims,
+			UpdateStrategy: appsv1.StatefulSetUpdateStrategy{
+				Type: appsv1.RollingUpdateStatefulSetStrategyType,
+			},
+		},
+	}
+
+	if svc.Spec.Singleton {
+		sts.Spec.Replicas = ptrOne
+	} else {
+		sts.Spec.Replicas = ptrThree
+	}
+
+	if annotations := makeWorkloadAnnotations(svc); len(annotations) > 0 {
+		sts.SetAnnotations(annotations)
+	}
+
+	if err := controllerutil.SetControllerReference(svc, sts, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return sts, nil
+}
+
+// newHeadlessServiceForService creates the service giving each pod of the statefulset a stable dns name
+func (b *objectBuilder) newHeadlessServiceForService(svc *appsv1alpha1.Service) (*corev1.Service, error) {
+	labels := b.makeLabels(svc)
+
+	coreService := &corev1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: corev1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      headlessServiceName(svc),
+			Namespace: svc.Namespace,
+			Labels:    mergeLabels(labels, map[string]string{headlessLabel: "true"}),
+		},
+		Spec: corev1.ServiceSpec{
+			ClusterIP: corev1.ClusterIPNone,
+			Ports:     svc.Spec.Ports.ToServicePorts(),
+			Selector:  labels,
+		},
+	}
+
+	if err := controllerutil.SetControllerReference(svc, coreService, b.scheme); err != nil {
+		return nil, err
+	}
+
+	return coreService, nil
+}
+
+// statefulSetComplete returns whether all replicas of the statefulset run the current revision and are ready
+func statefulSetComplete(sts *appsv1.StatefulSet) bool {
+	if sts.Status.ObservedGeneration < sts.Generation {
+		return false
+	}
+
+	replicas := int32(1)
+	if sts.Spec.Replicas != nil {
+		replicas = *sts.Spec.Replicas
+	}
+
+	return sts.Status.UpdateRevision == sts.Status.CurrentRevision &&
+		sts.Status.UpdatedReplicas == replicas &&
+		sts.Status.ReadyReplicas == replicas
+}
+
+func headlessServiceName(svc *appsv1alpha1.Service) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, svc.Name, "headless")
+}
+
+// relabelStatefulSetPods adds the labels of the new selector to the pods of a statefulset that is recreated with a
+// different selector, so the new statefulset adopts the pods instead of failing to create pods with the same names
+func (r *ReconcileService) relabelStatefulSetPods(found, sts *appsv1.StatefulSet) error {
+	if found.Spec.Selector == nil || reflect.DeepEqual(found.Spec.Selector, sts.Spec.Selector) {
+		return nil
+	}
+
+	pods := &corev1.PodList{}
+	if err := r.client.List(context.TODO(), pods, client.InNamespace(found.Namespace), client.MatchingLabels(found.Spec.Selector.MatchLabels)); err != nil {
+		return fmt.Errorf("failed to list pods of statefulset: %v", err)
+	}
+
+	for i := range pods.Items {
+		pod := &pods.Items[i]
+		if controller := metav1.GetControllerOf(pod); controller == nil || controller.UID != found.UID {
+			continue
+		}
+
+		pod.Labels = mergeLabels(pod.Labels, sts.Spec.Selector.MatchLabels)
+		if err := r.client.Update(context.TODO(), pod); err != nil {
+			return fmt.Errorf("failed to relabel pod %s: %v", pod.Name, err)
+		}
+	}
+
+	return nil
+}
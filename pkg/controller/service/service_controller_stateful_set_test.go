@@ -0,0 +1,266 @@
+package service
+
+import (
+	"context"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/apimachinery/pkg/types"
+	"k8s.io/apimachinery/pkg/util/validation/field"
+	"k8s.io/client-go/kubernetes/scheme"
+	"k8s.io/client-go/tools/record"
+	"sigs.k8s.io/controller-runtime/pkg/client"
+	"sigs.k8s.io/controller-runtime/pkg/client/fake"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_newStatefulSetForService(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			WorkloadKind: appsv1alpha1.WorkloadKindStatefulSet,
+			Singleton:    true,
+			VolumeClaimTemplates: []appsv1alpha1.VolumeClaimTemplate{
+				{Name: "data", Size: resource.MustParse("10Gi"), MountPath: "/var/lib/data"},
+			},
+		},
+	}
+
+	sts, err := r.newStatefulSetForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newStatefulSetForService() error = %v", err)
+	}
+
+	if got, want := sts.Spec.ServiceName, "db-headless"; got != want {
+		t.Errorf("serviceName = %v, want %v", got, want)
+	}
+	if *sts.Spec.Replicas != 1 {
+		t.Errorf("replicas = %v, want 1", *sts.Spec.Replicas)
+	}
+	if len(sts.Spec.VolumeClaimTemplates) != 1 {
+		t.Fatalf("expected 1 volume claim template, got %v", sts.Spec.VolumeClaimTemplates)
+	}
+	if size := sts.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "10Gi" {
+		t.Errorf("size = %v, want 10Gi", size.String())
+	}
+
+	mounts := sts.Spec.Template.Spec.Containers[0].VolumeMounts
+	if last := mounts[len(mounts)-1]; last.Name != "data" || last.MountPath != "/var/lib/data" {
+		t.Errorf("unexpected volume mount %v", last)
+	}
+}
+
+func TestReconcileService_cleanupManagedObjects_migration(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+		Spec:       appsv1alpha1.ServiceSpec{WorkloadKind: appsv1alpha1.WorkloadKindStatefulSet},
+		Status: appsv1alpha1.ServiceStatus{
+			Revisions: appsv1alpha1.RevisionList{{Revision: 2}},
+		},
+	}
+
+	name := types.NamespacedName{Name: "db", Namespace: "default"}
+	dep := &appsv1.Deployment{
+		TypeMeta:   metav1.TypeMeta{APIVersion: "apps/v1", Kind: "Deployment"},
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default"},
+	}
+	sts := &appsv1.StatefulSet{
+		TypeMeta: metav1.TypeMeta{APIVersion: "apps/v1", Kind: "StatefulSet"},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "db",
+			Namespace:   "default",
+			Annotations: map[string]string{revisionAnnotation: "2"},
+		},
+		Spec: appsv1.StatefulSetSpec{Replicas: ptrOne},
+	}
+	svc.Status.ManagedObjects.Add(dep, name, "old")
+	svc.Status.ManagedObjects.Add(sts, name, "new")
+
+	c := fake.NewFakeClientWithScheme(s, dep.DeepCopy(), sts.DeepCopy())
+	r := &ReconcileService{client: c, objectBuilder: objectBuilder{scheme: s}}
+
+	if err := r.cleanupManagedObjects(log, svc, []runtime.Object{sts}); err != nil {
+		t.Fatalf("cleanupManagedObjects() error = %v", err)
+	}
+	if err := c.Get(context.TODO(), name, &appsv1.Deployment{}); err != nil {
+		t.Errorf("expected deployment to be kept while the statefulset is not ready, got %v", err)
+	}
+
+	sts.Status = appsv1.StatefulSetStatus{UpdatedReplicas: 1, ReadyReplicas: 1}
+	if err := c.Status().Update(context.TODO(), sts); err != nil {
+		t.Fatalf("failed to update statefulset: %v", err)
+	}
+
+	if err := r.cleanupManagedObjects(log, svc, []runtime.Object{sts}); err != nil {
+		t.Fatalf("cleanupManagedObjects() error = %v", err)
+	}
+	if err := c.Get(context.TODO(), name, &appsv1.Deployment{}); !errors.IsNotFound(err) {
+		t.Errorf("expected deployment to be deleted once the statefulset is ready, got %v", err)
+	}
+	if len(svc.Status.ManagedObjects) != 1 {
+		t.Errorf("expected only the statefulset to be managed, got %v", svc.Status.ManagedObjects)
+	}
+}
+
+// forbiddingClient rejects updates of statefulsets like the API server rejects changes of fields other than the
+// replicas, the template and the update strategy
+type forbiddingClient struct {
+	client.Client
+	deleteOptions *client.DeleteOptions
+}
+
+func (c *forbiddingClient) Update(ctx context.Context, obj runtime.Object, opts ...client.UpdateOption) error {
+	if sts, ok := obj.(*appsv1.StatefulSet); ok {
+		return errors.NewInvalid(schema.GroupKind{Group: appsv1.GroupName, Kind: "StatefulSet"}, sts.Name, field.ErrorList{
+			field.Forbidden(field.NewPath("spec"), "updates to statefulset spec for fields other than 'replicas', 'template', and 'updateStrategy' are forbidden"),
+		})
+	}
+
+	return c.Client.Update(ctx, obj, opts...)
+}
+
+func (c *forbiddingClient) Delete(ctx context.Context, obj runtime.Object, opts ...client.DeleteOption) error {
+	c.deleteOptions = (&client.DeleteOptions{}).ApplyOptions(opts)
+	return c.Client.Delete(ctx, obj, opts...)
+}
+
+func TestReconcileService_ensureObject_statefulSetForbidden(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "db", Namespace: "default", UID: "svc-uid"},
+		Spec: appsv1alpha1.ServiceSpec{
+			WorkloadKind: appsv1alpha1.WorkloadKindStatefulSet,
+			Singleton:    true,
+			VolumeClaimTemplates: []appsv1alpha1.VolumeClaimTemplate{
+				{Name: "data", Size: resource.MustParse("20Gi"), MountPath: "/var/lib/data"},
+			},
+		},
+	}
+
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}, recorder: record.NewFakeRecorder(10)}
+	sts, err := r.newStatefulSetForService(svc, nil)
+	if err != nil {
+		t.Fatalf("newStatefulSetForService() error = %v", err)
+	}
+
+	// the existing statefulset was created with a smaller claim and before the selector had the workload label
+	found := sts.DeepCopy()
+	found.UID = "sts-uid"
+	found.Spec.Selector.MatchLabels = r.makeLabels(svc)
+	found.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage] = resource.MustParse("10Gi")
+	pod := &corev1.Pod{
+		ObjectMeta: metav1.ObjectMeta{Name: "db-0", Namespace: "default", Labels: r.makeLabels(svc)},
+	}
+	if err := controllerutil.SetControllerReference(found, pod, s); err != nil {
+		t.Fatal(err)
+	}
+
+	c := &forbiddingClient{Client: fake.NewFakeClientWithScheme(s, svc, found, pod)}
+	r.client = c
+
+	name := types.NamespacedName{Name: "db", Namespace: "default"}
+	if err := r.ensureObject(log, svc, sts, name); err != nil {
+		t.Fatalf("ensureObject() error = %v", err)
+	}
+
+	if c.deleteOptions == nil || c.deleteOptions.PropagationPolicy == nil || *c.deleteOptions.PropagationPolicy != metav1.DeletePropagationOrphan {
+		t.Errorf("expected the statefulset to be deleted without its pods, got %+v", c.deleteOptions)
+	}
+
+	got := &appsv1.StatefulSet{}
+	if err := c.Get(context.TODO(), name, got); err != nil {
+		t.Fatalf("expected the statefulset to be created again: %v", err)
+	}
+	if size := got.Spec.VolumeClaimTemplates[0].Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "20Gi" {
+		t.Errorf("claim size = %v, want 20Gi", size.String())
+	}
+
+	gotPod := &corev1.Pod{}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "db-0", Namespace: "default"}, gotPod); err != nil {
+		t.Fatalf("expected the pod to be kept: %v", err)
+	}
+	if gotPod.Labels[workloadLabel] != workloadStatefulSet {
+		t.Errorf("expected the pod to be labelled for the new selector, got %v", gotPod.Labels)
+	}
+}
+
+func TestReconcileService_cleanupOrphanedReplicaSets(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default", UID: "svc-uid"},
+		Status: appsv1alpha1.ServiceStatus{
+			Revisions: appsv1alpha1.RevisionList{{Revision: 1}},
+		},
+	}
+
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}}
+	dep := &appsv1.Deployment{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app",
+			Namespace:   "default",
+			UID:         "dep-uid",
+			Annotations: map[string]string{revisionAnnotation: "1"},
+			Generation:  1,
+		},
+		Spec:   appsv1.DeploymentSpec{Replicas: ptrThree},
+		Status: appsv1.DeploymentStatus{ObservedGeneration: 1, Replicas: 3, UpdatedReplicas: 3, AvailableReplicas: 1},
+	}
+	// the replica set of the deployment recreated to change its selector
+	orphaned := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-old", Namespace: "default", Labels: r.makeLabels(svc)},
+	}
+	owned := &appsv1.ReplicaSet{
+		ObjectMeta: metav1.ObjectMeta{Name: "app-new", Namespace: "default", Labels: r.makeLabels(svc)},
+	}
+	if err := controllerutil.SetControllerReference(dep, owned, s); err != nil {
+		t.Fatal(err)
+	}
+
+	c := fake.NewFakeClientWithScheme(s, dep, orphaned, owned)
+	r.client = c
+
+	if err := r.cleanupOrphanedReplicaSets(svc, log); err != nil {
+		t.Fatalf("cleanupOrphanedReplicaSets() error = %v", err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "app-old", Namespace: "default"}, &appsv1.ReplicaSet{}); err != nil {
+		t.Errorf("expected the orphaned replica set to be kept until the deployment is ready, got %v", err)
+	}
+
+	dep.Status.AvailableReplicas = 3
+	if err := c.Status().Update(context.TODO(), dep); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := r.cleanupOrphanedReplicaSets(svc, log); err != nil {
+		t.Fatalf("cleanupOrphanedReplicaSets() error = %v", err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "app-old", Namespace: "default"}, &appsv1.ReplicaSet{}); !errors.IsNotFound(err) {
+		t.Errorf("expected the orphaned replica set to be deleted, got %v", err)
+	}
+	if err := c.Get(context.TODO(), types.NamespacedName{Name: "app-new", Namespace: "default"}, &appsv1.ReplicaSet{}); err != nil {
+		t.Errorf("expected the replica set of the deployment to be kept, got %v", err)
+	}
+}
@@ -44,8 +44,8 @@ func TestReconcileService_makeKubelixLabels(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := &ReconcileService{
-				client: tt.fields.client,
-				scheme: tt.fields.scheme,
+				client:        tt.fields.client,
+				objectBuilder: objectBuilder{scheme: tt.fields.scheme},
 			}
 			if got := r.makeKubelixLabels(tt.args.svc); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("makeKubelixLabels() = %v, want %v", got, tt.want)
@@ -90,8 +90,8 @@ func TestReconcileService_makeLabels(t *testing.T) {
 	for _, tt := range tests {
 		t.Run(tt.name, func(t *testing.T) {
 			r := &ReconcileService{
-				client: tt.fields.client,
-				scheme: tt.fields.scheme,
+				client:        tt.fields.client,
+				objectBuilder: objectBuilder{scheme: tt.fields.scheme},
 			}
 			if got := r.makeLabels(tt.args.svc); !reflect.DeepEqual(got, tt.want) {
 				t.Errorf("makeKubelixLabels() = %v, want %v", got, tt.want)
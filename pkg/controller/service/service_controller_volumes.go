@@ -0,0 +1,212 @@
+package service
+
+import (
+	"fmt"
+
+	"github.com/go-logr/logr"
+	corev1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/types"
+	"sigs.k8s.io/controller-runtime/pkg/controller/controllerutil"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/names"
+)
+
+func (r *ReconcileService) ensureVolumeClaims(svc *appsv1alpha1.Service, reqLogger logr.Logger) ([]runtime.Object, error) {
+	claims, err := r.newVolumeClaimsForService(svc)
+	if err != nil {
+		return nil, err
+	}
+
+	objects := make([]runtime.Object, 0, len(claims))
+	for _, claim := range claims {
+		name := types.NamespacedName{Name: claim.Name, Namespace: claim.Namespace}
+		if err := r.ensureObject(reqLogger, svc, claim, name); err != nil {
+			return nil, fmt.Errorf("failed to handle persistent volume claim: %v", err)
+		}
+		objects = append(objects, claim)
+	}
+
+	return objects, nil
+}
+
+func (b *objectBuilder) newVolumeClaimsForService(svc *appsv1alpha1.Service) ([]*corev1.PersistentVolumeClaim, error) {
+	if err := validateVolumes(svc); err != nil {
+		return nil, validationFailed(svc, "volumes", err)
+	}
+
+	claims := make([]*corev1.PersistentVolumeClaim, 0)
+
+	for _, volume := range svc.Spec.Volumes {
+		if volume.PersistentVolumeClaim == nil {
+			continue
+		}
+
+		accessMode := volume.PersistentVolumeClaim.AccessMode
+		if accessMode == "" {
+			accessMode = corev1.ReadWriteOnce
+		}
+
+		claim := &corev1.PersistentVolumeClaim{
+			TypeMeta: metav1.TypeMeta{
+				APIVersion: corev1.SchemeGroupVersion.String(),
+				Kind:       "PersistentVolumeClaim",
+			},
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      volumeClaimName(svc, volume),
+				Namespace: svc.Namespace,
+				Labels:    b.makeLabels(svc),
+			},
+			Spec: corev1.PersistentVolumeClaimSpec{
+				AccessModes:      []corev1.PersistentVolumeAccessMode{accessMode},
+				StorageClassName: volume.PersistentVolumeClaim.StorageClassName,
+				Resources: corev1.ResourceRequirements{
+					Requests: corev1.ResourceList{corev1.ResourceStorage: volume.PersistentVolumeClaim.Size},
+				},
+			},
+		}
+
+		// retained claims have no owner, so they survive the deletion of the service
+		if volume.PersistentVolumeClaim.ReclaimPolicy == corev1.PersistentVolumeReclaimDelete {
+			if err := controllerutil.SetControllerReference(svc, claim, b.scheme); err != nil {
+				return nil, err
+			}
+		} else {
+			claim.SetAnnotations(map[string]string{retainAnnotation: "true"})
+		}
+
+		claims = append(claims, claim)
+	}
+
+	return claims, nil
+}
+
+// validateVolumes checks that each volume has exactly one source and that claims which can only be mounted by a single
+// node are not shared by several pods
+func validateVolumes(svc *appsv1alpha1.Service) error {
+	for _, volume := range svc.Spec.Volumes {
+		sources := 0
+		if volume.PersistentVolumeClaim != nil {
+			sources++
+		}
+		if volume.ExistingClaimName != "" {
+			sources++
+		}
+		if volume.EmptyDir != nil {
+			sources++
+		}
+
+		if sources != 1 {
+			return fmt.Errorf("volume %q needs exactly one of persistentVolumeClaim, existingClaimName and emptyDir", volume.Name)
+		}
+
+		claim := volume.PersistentVolumeClaim
+		if claim != nil && (claim.AccessMode == "" || claim.AccessMode == corev1.ReadWriteOnce) && runsSeveralPods(svc) {
+			return fmt.Errorf("volume %q is ReadWriteOnce but mounted by several pods, use accessMode ReadWriteMany, "+
+				"volumeClaimTemplates of a StatefulSet or a singleton with the rollingUpdate strategy", volume.Name)
+		}
+	}
+
+	return nil
+}
+
+// runsSeveralPods returns whether several pods of the service may run at the same time, either as replicas or while
+// a new revision is rolled out next to the previous one
+func runsSeveralPods(svc *appsv1alpha1.Service) bool {
+	if isCronJob(svc) {
+		return false
+	}
+
+	return !svc.Spec.Singleton || rolloutStrategy(svc) != appsv1alpha1.StrategyRollingUpdate
+}
+
+func volumesToPodVolumes(svc *appsv1alpha1.Service) []corev1.Volume {
+	volumes := make([]corev1.Volume, 0, len(svc.Spec.Volumes))
+
+	for _, volume := range svc.Spec.Volumes {
+		podVolume := corev1.Volume{Name: podVolumeName(volume)}
+
+		switch {
+		case volume.PersistentVolumeClaim != nil:
+			podVolume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: volumeClaimName(svc, volume),
+				ReadOnly:  volume.ReadOnly,
+			}
+		case volume.ExistingClaimName != "":
+			podVolume.PersistentVolumeClaim = &corev1.PersistentVolumeClaimVolumeSource{
+				ClaimName: volume.ExistingClaimName,
+				ReadOnly:  volume.ReadOnly,
+			}
+		case volume.EmptyDir != nil:
+			podVolume.EmptyDir = &corev1.EmptyDirVolumeSource{SizeLimit: volume.EmptyDir.SizeLimit}
+			if volume.EmptyDir.Tmpfs {
+				podVolume.EmptyDir.Medium = corev1.StorageMediumMemory
+			}
+		default:
+			continue
+		}
+
+		volumes = append(volumes, podVolume)
+	}
+
+	return volumes
+}
+
+func volumesToVolumeMounts(svc *appsv1alpha1.Service) []corev1.VolumeMount {
+	volumeMounts := make([]corev1.VolumeMount, 0, len(svc.Spec.Volumes))
+
+	for _, volume := range svc.Spec.Volumes {
+		volumeMounts = append(volumeMounts, corev1.VolumeMount{
+			Name:      podVolumeName(volume),
+			MountPath: volume.MountPath,
+			ReadOnly:  volume.ReadOnly,
+		})
+	}
+
+	return volumeMounts
+}
+
+// mergeVolumeClaim applies the changes of a generated claim to the existing one. Claims are immutable apart from their
+// size, and are never recreated to not lose any data.
+func mergeVolumeClaim(found, obj runtime.Object) runtime.Object {
+	foundClaim, ok := found.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return obj
+	}
+	claim, ok := obj.(*corev1.PersistentVolumeClaim)
+	if !ok {
+		return obj
+	}
+
+	merged := foundClaim.DeepCopy()
+	merged.TypeMeta = claim.TypeMeta
+	// annotations and labels of the volume controllers, like the binding state, need to be kept
+	merged.Labels = mergeLabels(foundClaim.Labels, claim.Labels)
+	merged.Annotations = mergeLabels(foundClaim.Annotations, claim.Annotations)
+	merged.OwnerReferences = claim.OwnerReferences
+	merged.Spec.Resources = claim.Spec.Resources
+
+	return merged
+}
+
+// recreatable returns whether the object may be deleted and created again if an update changes an immutable field
+func recreatable(obj runtime.Object) bool {
+	_, ok := obj.(*corev1.PersistentVolumeClaim)
+	return !ok
+}
+
+// isRetained returns whether the object is kept when it is no longer generated
+func isRetained(meta metav1.Object) bool {
+	return meta.GetAnnotations()[retainAnnotation] == "true"
+}
+
+// podVolumeName prefixes the volumes of the spec so they never collide with the volumes of the deployer
+func podVolumeName(volume appsv1alpha1.Volume) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSLabelMaxLength, "volume", volume.Name)
+}
+
+func volumeClaimName(svc *appsv1alpha1.Service, volume appsv1alpha1.Volume) string {
+	return names.FormatDashFromPartsWithLimit(names.DNSSubdomainMaxLength, svc.Name, volume.Name)
+}
@@ -0,0 +1,146 @@
+package service
+
+import (
+	"testing"
+
+	corev1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+func TestReconcileService_newVolumeClaimsForService(t *testing.T) {
+	s := runtime.NewScheme()
+	_ = scheme.AddToScheme(s)
+	_ = appsv1alpha1.SchemeBuilder.AddToScheme(s)
+	r := &ReconcileService{objectBuilder: objectBuilder{scheme: s}}
+
+	svc := &appsv1alpha1.Service{
+		ObjectMeta: metav1.ObjectMeta{Name: "app", Namespace: "default"},
+		Spec: appsv1alpha1.ServiceSpec{
+			Singleton: true,
+			Volumes: []appsv1alpha1.Volume{
+				{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appsv1alpha1.VolumeClaim{Size: resource.MustParse("1Gi")}},
+				{Name: "cache", MountPath: "/cache", PersistentVolumeClaim: &appsv1alpha1.VolumeClaim{
+					Size:          resource.MustParse("1Gi"),
+					AccessMode:    corev1.ReadWriteMany,
+					ReclaimPolicy: corev1.PersistentVolumeReclaimDelete,
+				}},
+				{Name: "shared", MountPath: "/shared", ExistingClaimName: "shared-data", ReadOnly: true},
+				{Name: "tmp", MountPath: "/tmp", EmptyDir: &appsv1alpha1.EmptyDirVolume{Tmpfs: true}},
+			},
+		},
+	}
+
+	claims, err := r.newVolumeClaimsForService(svc)
+	if err != nil {
+		t.Fatalf("newVolumeClaimsForService() error = %v", err)
+	}
+	if len(claims) != 2 {
+		t.Fatalf("expected 2 claims, got %d", len(claims))
+	}
+
+	data, cache := claims[0], claims[1]
+	if data.Name != "app-data" || data.Spec.AccessModes[0] != corev1.ReadWriteOnce {
+		t.Errorf("unexpected claim %s with access modes %v", data.Name, data.Spec.AccessModes)
+	}
+	if !isRetained(data) || len(data.OwnerReferences) != 0 {
+		t.Errorf("expected claim %s to be retained without owner", data.Name)
+	}
+	if isRetained(cache) || len(cache.OwnerReferences) != 1 {
+		t.Errorf("expected claim %s to be owned by the service", cache.Name)
+	}
+
+	volumes := volumesToPodVolumes(svc)
+	if got := volumes[2].PersistentVolumeClaim; got == nil || got.ClaimName != "shared-data" || !got.ReadOnly {
+		t.Errorf("unexpected volume for existing claim %v", volumes[2])
+	}
+	if got := volumes[3].EmptyDir; got == nil || got.Medium != corev1.StorageMediumMemory {
+		t.Errorf("unexpected volume for tmpfs %v", volumes[3])
+	}
+
+	svc.Spec.Volumes = append(svc.Spec.Volumes, appsv1alpha1.Volume{Name: "invalid", MountPath: "/invalid"})
+	if _, err := r.newVolumeClaimsForService(svc); err == nil {
+		t.Errorf("expected error for volume without source")
+	}
+}
+
+func Test_validateVolumes_readWriteOnce(t *testing.T) {
+	tests := []struct {
+		name       string
+		accessMode corev1.PersistentVolumeAccessMode
+		singleton  bool
+		strategy   appsv1alpha1.Strategy
+		schedule   string
+		wantErr    bool
+	}{
+		{name: "replicas", wantErr: true},
+		{name: "explicit_replicas", accessMode: corev1.ReadWriteOnce, wantErr: true},
+		{name: "read_write_many", accessMode: corev1.ReadWriteMany},
+		{name: "singleton", singleton: true},
+		{name: "singleton_blue_green", singleton: true, strategy: appsv1alpha1.StrategyBlueGreen, wantErr: true},
+		{name: "singleton_canary", singleton: true, strategy: appsv1alpha1.StrategyCanary, wantErr: true},
+		{name: "cron_job", schedule: "0 * * * *"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			svc := &appsv1alpha1.Service{
+				Spec: appsv1alpha1.ServiceSpec{
+					Singleton: tt.singleton,
+					Strategy:  tt.strategy,
+					Schedule:  tt.schedule,
+					Volumes: []appsv1alpha1.Volume{
+						{Name: "data", MountPath: "/data", PersistentVolumeClaim: &appsv1alpha1.VolumeClaim{
+							Size:       resource.MustParse("1Gi"),
+							AccessMode: tt.accessMode,
+						}},
+					},
+				},
+			}
+
+			if err := validateVolumes(svc); (err != nil) != tt.wantErr {
+				t.Errorf("validateVolumes() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func Test_mergeVolumeClaim(t *testing.T) {
+	found := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:            "app-data",
+			ResourceVersion: "42",
+			Annotations:     map[string]string{"pv.kubernetes.io/bind-completed": "yes"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			VolumeName: "pv-1",
+			Resources:  corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("1Gi")}},
+		},
+	}
+	claim := &corev1.PersistentVolumeClaim{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:        "app-data",
+			Annotations: map[string]string{retainAnnotation: "true"},
+		},
+		Spec: corev1.PersistentVolumeClaimSpec{
+			Resources: corev1.ResourceRequirements{Requests: corev1.ResourceList{corev1.ResourceStorage: resource.MustParse("2Gi")}},
+		},
+	}
+
+	merged := mergeVolumeClaim(found, claim).(*corev1.PersistentVolumeClaim)
+	if merged.Spec.VolumeName != "pv-1" || merged.ResourceVersion != "42" {
+		t.Errorf("expected volume name and resource version to be kept, got %+v", merged)
+	}
+	if size := merged.Spec.Resources.Requests[corev1.ResourceStorage]; size.String() != "2Gi" {
+		t.Errorf("size = %v, want 2Gi", size.String())
+	}
+	if len(merged.Annotations) != 2 {
+		t.Errorf("expected annotations to be merged, got %v", merged.Annotations)
+	}
+	if recreatable(claim) {
+		t.Errorf("expected claims to never be recreated")
+	}
+}
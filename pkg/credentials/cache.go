@@ -0,0 +1,43 @@
+package credentials
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// NewCachingProvider wraps the provider and returns the last credential until it is about to expire
+func NewCachingProvider(provider Provider) Provider {
+	return &cachingProvider{provider: provider, now: time.Now}
+}
+
+type cachingProvider struct {
+	provider Provider
+	now      func() time.Time
+
+	mu     sync.Mutex
+	cached *Credential
+}
+
+func (p *cachingProvider) Credential(ctx context.Context) (*Credential, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.cached != nil && !p.stale(p.cached) {
+		credential := *p.cached
+		return &credential, nil
+	}
+
+	credential, err := p.provider.Credential(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	p.cached = credential
+	result := *credential
+	return &result, nil
+}
+
+func (p *cachingProvider) stale(credential *Credential) bool {
+	return credential.Expires() && !p.now().Before(credential.ExpiresAt.Add(-RefreshBefore))
+}
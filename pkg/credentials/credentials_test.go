@@ -0,0 +1,124 @@
+package credentials
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type countingProvider struct {
+	calls     int
+	expiresAt time.Time
+}
+
+func (p *countingProvider) Credential(ctx context.Context) (*Credential, error) {
+	p.calls++
+	return &Credential{Username: "user", Password: "token", ExpiresAt: p.expiresAt}, nil
+}
+
+type failingProvider struct{}
+
+func (p *failingProvider) Credential(ctx context.Context) (*Credential, error) {
+	return nil, errors.New("failed")
+}
+
+func TestStaticProvider(t *testing.T) {
+	got, err := NewStaticProvider("user", "password").Credential(context.Background())
+	if err != nil {
+		t.Fatalf("Credential() error = %v", err)
+	}
+
+	if got.Username != "user" || got.Password != "password" || got.Expires() {
+		t.Errorf("Credential() = %#v", got)
+	}
+}
+
+func TestCachingProvider(t *testing.T) {
+	now := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	upstream := &countingProvider{expiresAt: now.Add(time.Hour)}
+	provider := &cachingProvider{provider: upstream, now: func() time.Time { return now }}
+
+	for i := 0; i < 3; i++ {
+		if _, err := provider.Credential(context.Background()); err != nil {
+			t.Fatalf("Credential() error = %v", err)
+		}
+	}
+	if upstream.calls != 1 {
+		t.Errorf("expected upstream to be called once, got %d calls", upstream.calls)
+	}
+
+	now = now.Add(time.Hour - RefreshBefore)
+	if _, err := provider.Credential(context.Background()); err != nil {
+		t.Fatalf("Credential() error = %v", err)
+	}
+	if upstream.calls != 2 {
+		t.Errorf("expected stale credential to be refreshed, got %d calls", upstream.calls)
+	}
+}
+
+func TestCachingProvider_error(t *testing.T) {
+	provider := NewCachingProvider(&failingProvider{})
+	if _, err := provider.Credential(context.Background()); err == nil {
+		t.Error("expected error from failing provider")
+	}
+}
+
+func TestExecProvider(t *testing.T) {
+	tests := []struct {
+		name    string
+		opts    ExecOptions
+		want    Credential
+		wantErr bool
+	}{
+		{
+			name: "full",
+			opts: ExecOptions{
+				Command: "sh",
+				Args:    []string{"-c", `echo '{"username": "AWS", "password": "token", "expiresAt": "2020-01-01T12:00:00Z"}'`},
+			},
+			want: Credential{Username: "AWS", Password: "token", ExpiresAt: time.Date(2020, 1, 1, 12, 0, 0, 0, time.UTC)},
+		},
+		{
+			name: "configured_username_and_env",
+			opts: ExecOptions{
+				Registry: "registry.example.com",
+				Username: "deploy",
+				Command:  "sh",
+				Args:     []string{"-c", `echo "{\"password\": \"$KUBELIX_REGISTRY-$SUFFIX\"}"`},
+				Env:      map[string]string{"SUFFIX": "token"},
+			},
+			want: Credential{Username: "deploy", Password: "registry.example.com-token"},
+		},
+		{
+			name:    "no_password",
+			opts:    ExecOptions{Command: "sh", Args: []string{"-c", `echo '{}'`}},
+			wantErr: true,
+		},
+		{
+			name:    "invalid_json",
+			opts:    ExecOptions{Command: "sh", Args: []string{"-c", "echo nope"}},
+			wantErr: true,
+		},
+		{
+			name:    "failing",
+			opts:    ExecOptions{Command: "sh", Args: []string{"-c", "exit 1"}},
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := NewExecProvider(tt.opts).Credential(context.Background())
+			if (err != nil) != tt.wantErr {
+				t.Errorf("Credential() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if err != nil {
+				return
+			}
+			if got.Username != tt.want.Username || got.Password != tt.want.Password || !got.ExpiresAt.Equal(tt.want.ExpiresAt) {
+				t.Errorf("Credential() got = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+package credentials
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// DefaultExecTimeout limits the runtime of credential plugins without a configured timeout
+const DefaultExecTimeout = 30 * time.Second
+
+// ExecOptions configures a credential plugin
+type ExecOptions struct {
+	// Registry is passed to the plugin in the KUBELIX_REGISTRY environment variable
+	Registry string
+	// Username is used when the plugin does not return one
+	Username string
+	Command  string
+	Args     []string
+	Env      map[string]string
+	Timeout  time.Duration
+}
+
+// NewExecProvider returns a provider that runs an external binary to fetch credentials. The binary has to print a
+// json object with the fields username, password and expiresAt (RFC 3339) to stdout.
+func NewExecProvider(opts ExecOptions) Provider {
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultExecTimeout
+	}
+
+	return &execProvider{opts: opts}
+}
+
+type execProvider struct {
+	opts ExecOptions
+}
+
+func (p *execProvider) Credential(ctx context.Context) (*Credential, error) {
+	ctx, cancel := context.WithTimeout(ctx, p.opts.Timeout)
+	defer cancel()
+
+	stdout := &bytes.Buffer{}
+	stderr := &bytes.Buffer{}
+
+	cmd := exec.CommandContext(ctx, p.opts.Command, p.opts.Args...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	cmd.Env = append(os.Environ(), fmt.Sprintf("KUBELIX_REGISTRY=%s", p.opts.Registry))
+	for k, v := range p.opts.Env {
+		cmd.Env = append(cmd.Env, fmt.Sprintf("%s=%s", k, v))
+	}
+
+	if err := cmd.Run(); err != nil {
+		return nil, fmt.Errorf("failed to run credential plugin %s: %v: %s", p.opts.Command, err, strings.TrimSpace(stderr.String()))
+	}
+
+	credential := &Credential{}
+	if err := json.Unmarshal(stdout.Bytes(), credential); err != nil {
+		return nil, fmt.Errorf("failed to parse output of credential plugin %s: %v", p.opts.Command, err)
+	}
+
+	if credential.Username == "" {
+		credential.Username = p.opts.Username
+	}
+
+	if credential.Password == "" {
+		return nil, fmt.Errorf("credential plugin %s returned no password", p.opts.Command)
+	}
+
+	return credential, nil
+}
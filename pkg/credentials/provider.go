@@ -0,0 +1,43 @@
+// Package credentials provides docker registry credentials from static configuration or external plugins
+package credentials
+
+import (
+	"context"
+	"time"
+)
+
+// RefreshBefore is the duration before expiry at which credentials are considered stale and fetched again
+const RefreshBefore = 5 * time.Minute
+
+// Credential is a username / password pair used to authenticate against a docker registry
+type Credential struct {
+	Username string `json:"username"`
+	Password string `json:"password"`
+
+	// ExpiresAt is the point in time the credential becomes invalid; the zero value means it never expires
+	ExpiresAt time.Time `json:"expiresAt,omitempty"`
+}
+
+// Expires returns true when the credential is only valid for a limited time
+func (c *Credential) Expires() bool {
+	return !c.ExpiresAt.IsZero()
+}
+
+// Provider provides credentials for a single registry
+type Provider interface {
+	Credential(ctx context.Context) (*Credential, error)
+}
+
+// NewStaticProvider returns a provider that always returns the given credentials
+func NewStaticProvider(username, password string) Provider {
+	return &staticProvider{credential: Credential{Username: username, Password: password}}
+}
+
+type staticProvider struct {
+	credential Credential
+}
+
+func (p *staticProvider) Credential(ctx context.Context) (*Credential, error) {
+	credential := p.credential
+	return &credential, nil
+}
@@ -0,0 +1,428 @@
+// Package importer converts the existing manifests of an app into a kubelix Service
+package importer
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+
+	appsv1 "k8s.io/api/apps/v1"
+	corev1 "k8s.io/api/core/v1"
+	extensionsv1beta1 "k8s.io/api/extensions/v1beta1"
+	networkingv1beta1 "k8s.io/api/networking/v1beta1"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/yaml"
+	"k8s.io/client-go/kubernetes/scheme"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/controller/service"
+)
+
+// Options configure Import
+type Options struct {
+	// Namespace of the Service if the Deployment has none
+	Namespace string
+
+	// Adopt annotates the existing Deployment and Service, so the deployer takes them over in place
+	Adopt bool
+}
+
+// Result of an import
+type Result struct {
+	Service *appsv1alpha1.Service
+
+	// Adopted are the existing objects annotated for adoption, only set with Options.Adopt
+	Adopted []runtime.Object
+
+	// Warnings report everything the Service can not express
+	Warnings []string
+}
+
+// Decode parses multi-document yaml or json manifests, including lists like the output of kubectl get. Objects of
+// unknown kinds are skipped with a warning.
+func Decode(content []byte) ([]runtime.Object, []string, error) {
+	decoder := yaml.NewYAMLOrJSONDecoder(bytes.NewReader(content), 4096)
+	objects := make([]runtime.Object, 0)
+	warnings := make([]string, 0)
+
+	for {
+		raw := runtime.RawExtension{}
+		if err := decoder.Decode(&raw); err != nil {
+			if err == io.EOF {
+				break
+			}
+			return nil, nil, fmt.Errorf("failed to parse manifests: %v", err)
+		}
+
+		decoded, decodeWarnings, err := decodeObject(raw.Raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, decoded...)
+		warnings = append(warnings, decodeWarnings...)
+	}
+
+	return objects, warnings, nil
+}
+
+func decodeObject(raw []byte) ([]runtime.Object, []string, error) {
+	raw = bytes.TrimSpace(raw)
+	if len(raw) == 0 || string(raw) == "null" {
+		return nil, nil, nil
+	}
+
+	obj, gvk, err := scheme.Codecs.UniversalDeserializer().Decode(raw, nil, nil)
+	if err != nil {
+		if runtime.IsNotRegisteredError(err) && gvk != nil {
+			partial := &metav1.PartialObjectMetadata{}
+			_ = json.Unmarshal(raw, partial)
+			return nil, []string{fmt.Sprintf("%s %s is not imported", gvk.Kind, partial.Name)}, nil
+		}
+		return nil, nil, fmt.Errorf("failed to decode manifest: %v", err)
+	}
+
+	list, ok := obj.(*corev1.List)
+	if !ok {
+		return []runtime.Object{obj}, nil, nil
+	}
+
+	objects := make([]runtime.Object, 0, len(list.Items))
+	warnings := make([]string, 0)
+	for _, item := range list.Items {
+		decoded, decodeWarnings, err := decodeObject(item.Raw)
+		if err != nil {
+			return nil, nil, err
+		}
+		objects = append(objects, decoded...)
+		warnings = append(warnings, decodeWarnings...)
+	}
+
+	return objects, warnings, nil
+}
+
+// importer collects the warnings of a single import
+type importer struct {
+	opts     Options
+	warnings []string
+}
+
+func (i *importer) warn(format string, args ...interface{}) {
+	i.warnings = append(i.warnings, fmt.Sprintf(format, args...))
+}
+
+// Import converts a Deployment together with the Services selecting its pods and the Ingresses routing to them into a
+// Service
+func Import(objects []runtime.Object, opts Options) (*Result, error) {
+	i := &importer{opts: opts}
+
+	deployments := make([]*appsv1.Deployment, 0, 1)
+	services := make([]*corev1.Service, 0)
+	ingresses := make([]*networkingv1beta1.Ingress, 0)
+
+	for _, obj := range objects {
+		switch o := obj.(type) {
+		case *appsv1.Deployment:
+			deployments = append(deployments, o)
+		case *corev1.Service:
+			services = append(services, o)
+		case *networkingv1beta1.Ingress:
+			ingresses = append(ingresses, o)
+		case *extensionsv1beta1.Ingress:
+			ingress, err := convertIngress(o)
+			if err != nil {
+				return nil, err
+			}
+			ingresses = append(ingresses, ingress)
+		default:
+			i.warn("%s %s is not imported", obj.GetObjectKind().GroupVersionKind().Kind, objectName(obj))
+		}
+	}
+
+	if len(deployments) != 1 {
+		return nil, fmt.Errorf("expected exactly one Deployment, found %d", len(deployments))
+	}
+	dep := deployments[0]
+
+	svc := i.importDeployment(dep)
+	coreService := i.importServices(svc, dep, services)
+	i.importIngresses(svc, coreService, ingresses)
+
+	result := &Result{Service: svc}
+
+	if opts.Adopt {
+		result.Adopted = append(result.Adopted, adoptable(dep))
+		if coreService != nil && coreService.Name == svc.Name {
+			result.Adopted = append(result.Adopted, adoptable(coreService))
+		}
+	} else {
+		i.warn("the Deployment %s is not taken over by the deployer unless it is adopted, use --adopt to take it over in place or delete it before applying the Service", dep.Name)
+	}
+
+	result.Warnings = i.warnings
+	return result, nil
+}
+
+func (i *importer) importDeployment(dep *appsv1.Deployment) *appsv1alpha1.Service {
+	namespace := dep.Namespace
+	if namespace == "" {
+		namespace = i.opts.Namespace
+	}
+
+	svc := &appsv1alpha1.Service{
+		TypeMeta: metav1.TypeMeta{
+			APIVersion: appsv1alpha1.SchemeGroupVersion.String(),
+			Kind:       "Service",
+		},
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      dep.Name,
+			Namespace: namespace,
+		},
+	}
+
+	replicas := dep.Spec.Replicas
+	switch {
+	case replicas != nil && *replicas == 1:
+		svc.Spec.Singleton = true
+		if dep.Spec.Strategy.Type != appsv1.RecreateDeploymentStrategyType {
+			i.warn("the Deployment runs a single replica and is imported as singleton, which rolls out by recreating the pod")
+		}
+	case replicas != nil:
+		i.warn("replicas are not imported, the deployer config sets the replicas of all services")
+	}
+
+	if dep.Spec.Strategy.Type == appsv1.RecreateDeploymentStrategyType && !svc.Spec.Singleton {
+		i.warn("the Recreate strategy is not imported, only singletons are recreated")
+	}
+
+	if deadline := dep.Spec.ProgressDeadlineSeconds; deadline != nil && *deadline != 600 {
+		svc.Spec.ProgressDeadlineSeconds = deadline
+	}
+
+	i.importPodTemplate(svc, &dep.Spec.Template)
+
+	return svc
+}
+
+// importServices imports the ports of the Service selecting the pods of the Deployment, preferring the one named like
+// it, and returns it
+func (i *importer) importServices(svc *appsv1alpha1.Service, dep *appsv1.Deployment, services []*corev1.Service) *corev1.Service {
+	var imported *corev1.Service
+
+	for _, s := range services {
+		if !selectsPods(s, dep.Spec.Template.Labels) {
+			i.warn("Service %s does not select the pods of the Deployment and is not imported", s.Name)
+			continue
+		}
+
+		if imported == nil || (s.Name == dep.Name && imported.Name != dep.Name) {
+			imported = s
+		}
+	}
+
+	for _, s := range services {
+		if s != imported && selectsPods(s, dep.Spec.Template.Labels) {
+			i.warn("Service %s is not imported, the deployer creates a single Service", s.Name)
+		}
+	}
+
+	if imported == nil {
+		return nil
+	}
+
+	if imported.Name != svc.Name {
+		i.warn("Service %s is replaced by the Service %s generated by the deployer", imported.Name, svc.Name)
+	}
+	if imported.Spec.Type != "" && imported.Spec.Type != corev1.ServiceTypeClusterIP {
+		i.warn("type %s of Service %s is not imported, the deployer creates ClusterIP services", imported.Spec.Type, imported.Name)
+	}
+
+	for _, sp := range imported.Spec.Ports {
+		port := targetPort(svc, sp)
+		if port == nil {
+			i.warn("port %d of Service %s targets no port of the container and is not imported", sp.Port, imported.Name)
+			continue
+		}
+
+		port.Service = uint16(sp.Port)
+	}
+
+	return imported
+}
+
+// importIngresses adds the hosts and paths of the Ingresses routing to the Service to the ports they target
+func (i *importer) importIngresses(svc *appsv1alpha1.Service, coreService *corev1.Service, ingresses []*networkingv1beta1.Ingress) {
+	for _, ingress := range ingresses {
+		if coreService == nil {
+			i.warn("Ingress %s is not imported, the Deployment is not exposed by a Service", ingress.Name)
+			continue
+		}
+
+		i.warn("Ingress %s is replaced by the ingresses generated per port and host, delete it once they serve the traffic", ingress.Name)
+		if len(ingress.Annotations) > 0 {
+			i.warn("annotations of Ingress %s are not imported, the deployer config sets the annotations of all ingresses", ingress.Name)
+		}
+		if len(ingress.Spec.TLS) > 0 {
+			i.warn("TLS of Ingress %s is not imported, the deployer generates a TLS secret per host", ingress.Name)
+		}
+		if ingress.Spec.Backend != nil {
+			i.warn("default backend of Ingress %s is not imported", ingress.Name)
+		}
+
+		for _, rule := range ingress.Spec.Rules {
+			if rule.HTTP == nil {
+				continue
+			}
+
+			for _, path := range rule.HTTP.Paths {
+				if path.Backend.ServiceName != coreService.Name {
+					i.warn("path %s%s of Ingress %s routes to Service %s and is not imported", rule.Host, path.Path, ingress.Name, path.Backend.ServiceName)
+					continue
+				}
+
+				port := servicePort(svc, coreService, path.Backend.ServicePort.String())
+				if port == nil {
+					i.warn("path %s%s of Ingress %s targets no port of the container and is not imported", rule.Host, path.Path, ingress.Name)
+					continue
+				}
+
+				addIngressPath(port, rule.Host, path.Path)
+			}
+		}
+	}
+}
+
+// addIngressPath adds the path to the ingress of the host. The deployer routes / if no paths are set, so / is only
+// listed together with other paths.
+func addIngressPath(port *appsv1alpha1.Port, host, path string) {
+	if path == "" {
+		path = "/"
+	}
+
+	for idx := range port.Ingresses {
+		ingress := &port.Ingresses[idx]
+		if ingress.Host != host {
+			continue
+		}
+		if len(ingress.Paths) == 0 {
+			ingress.Paths = []string{"/"}
+		}
+		ingress.Paths = append(ingress.Paths, path)
+		return
+	}
+
+	ingress := appsv1alpha1.PortIngress{Host: host}
+	if path != "/" {
+		ingress.Paths = []string{path}
+	}
+	port.Ingresses = append(port.Ingresses, ingress)
+}
+
+// selectsPods returns true when the selector of the service matches the labels
+func selectsPods(s *corev1.Service, labels map[string]string) bool {
+	if len(s.Spec.Selector) == 0 {
+		return false
+	}
+
+	for k, v := range s.Spec.Selector {
+		if labels[k] != v {
+			return false
+		}
+	}
+
+	return true
+}
+
+// targetPort returns the port of the container the service port sends traffic to, nil if there is none
+func targetPort(svc *appsv1alpha1.Service, sp corev1.ServicePort) *appsv1alpha1.Port {
+	for idx := range svc.Spec.Ports {
+		port := &svc.Spec.Ports[idx]
+
+		switch {
+		case sp.TargetPort.StrVal != "":
+			if port.Name == sp.TargetPort.StrVal {
+				return port
+			}
+		case sp.TargetPort.IntVal != 0:
+			if int32(port.Container) == sp.TargetPort.IntVal {
+				return port
+			}
+		case int32(port.Container) == sp.Port:
+			return port
+		}
+	}
+
+	return nil
+}
+
+// servicePort returns the port of the container an ingress backend sends traffic to, by name or number of the port of
+// the service
+func servicePort(svc *appsv1alpha1.Service, coreService *corev1.Service, backendPort string) *appsv1alpha1.Port {
+	for _, sp := range coreService.Spec.Ports {
+		if sp.Name == backendPort || fmt.Sprint(sp.Port) == backendPort {
+			return targetPort(svc, sp)
+		}
+	}
+
+	return nil
+}
+
+// adoptable returns a copy of the object annotated for adoption, without the fields set by the API server
+func adoptable(obj runtime.Object) runtime.Object {
+	obj = obj.DeepCopyObject()
+
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return obj
+	}
+
+	annotations := m.GetAnnotations()
+	if annotations == nil {
+		annotations = make(map[string]string)
+	}
+	annotations[service.AdoptAnnotation] = "true"
+	m.SetAnnotations(annotations)
+
+	m.SetResourceVersion("")
+	m.SetUID("")
+	m.SetSelfLink("")
+	m.SetGeneration(0)
+	m.SetCreationTimestamp(metav1.Time{})
+	m.SetManagedFields(nil)
+
+	switch o := obj.(type) {
+	case *appsv1.Deployment:
+		o.Status = appsv1.DeploymentStatus{}
+	case *corev1.Service:
+		o.Status = corev1.ServiceStatus{}
+	}
+
+	return obj
+}
+
+// convertIngress converts the deprecated extensions/v1beta1 Ingress, which has the same fields
+func convertIngress(in *extensionsv1beta1.Ingress) (*networkingv1beta1.Ingress, error) {
+	b, err := json.Marshal(in)
+	if err != nil {
+		return nil, err
+	}
+
+	out := &networkingv1beta1.Ingress{}
+	if err := json.Unmarshal(b, out); err != nil {
+		return nil, err
+	}
+	out.APIVersion = networkingv1beta1.SchemeGroupVersion.String()
+
+	return out, nil
+}
+
+func objectName(obj runtime.Object) string {
+	m, err := meta.Accessor(obj)
+	if err != nil {
+		return ""
+	}
+
+	return m.GetName()
+}
@@ -0,0 +1,168 @@
+package importer
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+
+	appsv1 "k8s.io/api/apps/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+	"github.com/kubelix/deployer/pkg/controller/service"
+)
+
+const manifests = `
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: shop
+  resourceVersion: "42"
+spec:
+  replicas: 1
+  selector:
+    matchLabels:
+      app: shop
+  template:
+    metadata:
+      labels:
+        app: shop
+      annotations:
+        prometheus.io/scrape: "true"
+        prometheus.io/port: "9090"
+    spec:
+      containers:
+        - name: shop
+          image: shop:1.0
+          ports:
+            - name: http
+              containerPort: 8080
+            - name: metrics
+              containerPort: 9090
+          env:
+            - name: MODE
+              value: prod
+            - name: PASSWORD
+              valueFrom:
+                secretKeyRef: {name: db, key: password}
+          volumeMounts:
+            - name: cache
+              mountPath: /cache
+            - name: settings
+              mountPath: /etc/shop
+      volumes:
+        - name: cache
+          emptyDir: {medium: Memory}
+        - name: settings
+          configMap: {name: settings}
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: shop
+spec:
+  selector:
+    app: shop
+  ports:
+    - name: http
+      port: 80
+      targetPort: http
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: other
+spec:
+  selector:
+    app: other
+  ports:
+    - port: 80
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: shop
+spec:
+  rules:
+    - host: shop.example.com
+      http:
+        paths:
+          - backend:
+              serviceName: shop
+              servicePort: 80
+          - path: /api
+            backend:
+              serviceName: shop
+              servicePort: http
+---
+apiVersion: example.com/v1
+kind: Widget
+metadata:
+  name: unknown
+`
+
+func TestImport(t *testing.T) {
+	objects, warnings, err := Decode([]byte(manifests))
+	if err != nil {
+		t.Fatalf("Decode() error = %v", err)
+	}
+	if len(objects) != 4 || len(warnings) != 1 || !strings.Contains(warnings[0], "Widget unknown") {
+		t.Fatalf("Decode() = %d objects, warnings %v, want 4 objects and a warning about the unknown kind", len(objects), warnings)
+	}
+
+	result, err := Import(objects, Options{Namespace: "shop", Adopt: true})
+	if err != nil {
+		t.Fatalf("Import() error = %v", err)
+	}
+
+	svc := result.Service
+	if svc.Name != "shop" || svc.Namespace != "shop" || svc.Spec.Image != "shop:1.0" || !svc.Spec.Singleton {
+		t.Errorf("unexpected service %+v", svc)
+	}
+
+	wantPorts := appsv1alpha1.PortList{
+		{
+			Name:      "http",
+			Container: 8080,
+			Service:   80,
+			Ingresses: []appsv1alpha1.PortIngress{{Host: "shop.example.com", Paths: []string{"/", "/api"}}},
+		},
+		{Name: "metrics", Container: 9090, Service: 9090},
+	}
+	if !reflect.DeepEqual(svc.Spec.Ports, wantPorts) {
+		t.Errorf("ports = %+v, want %+v", svc.Spec.Ports, wantPorts)
+	}
+
+	if !reflect.DeepEqual(svc.Spec.Env, appsv1alpha1.Environment{"MODE": "prod"}) {
+		t.Errorf("env = %v, want only the plain value", svc.Spec.Env)
+	}
+	if len(svc.Spec.Volumes) != 1 || svc.Spec.Volumes[0].EmptyDir == nil || !svc.Spec.Volumes[0].EmptyDir.Tmpfs {
+		t.Errorf("expected the tmpfs volume to be imported, got %+v", svc.Spec.Volumes)
+	}
+	if svc.Spec.Metrics == nil || svc.Spec.Metrics.Port != "metrics" {
+		t.Errorf("expected the scrape annotations to be imported, got %+v", svc.Spec.Metrics)
+	}
+
+	for _, want := range []string{"PASSWORD", "settings", "Service other", "Ingress shop"} {
+		found := false
+		for _, warning := range result.Warnings {
+			found = found || strings.Contains(warning, want)
+		}
+		if !found {
+			t.Errorf("expected a warning about %s, got %v", want, result.Warnings)
+		}
+	}
+
+	if len(result.Adopted) != 2 {
+		t.Fatalf("expected the deployment and service to be adopted, got %d objects", len(result.Adopted))
+	}
+	dep := result.Adopted[0].(*appsv1.Deployment)
+	if dep.Annotations[service.AdoptAnnotation] != "true" || dep.ResourceVersion != "" {
+		t.Errorf("expected an annotated deployment without server fields, got %+v", dep.ObjectMeta)
+	}
+}
+
+func TestImport_noDeployment(t *testing.T) {
+	if _, err := Import(nil, Options{}); err == nil {
+		t.Error("expected an error without deployment")
+	}
+}
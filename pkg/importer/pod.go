@@ -0,0 +1,231 @@
+package importer
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+
+	corev1 "k8s.io/api/core/v1"
+
+	appsv1alpha1 "github.com/kubelix/deployer/pkg/apis/apps/v1alpha1"
+)
+
+const (
+	seccompPodAnnotation       = "seccomp.security.alpha.kubernetes.io/pod"
+	prometheusScrapeAnnotation = "prometheus.io/scrape"
+	prometheusPortAnnotation   = "prometheus.io/port"
+	prometheusPathAnnotation   = "prometheus.io/path"
+)
+
+// importPodTemplate imports the first container of the pod template as the app container of the service
+func (i *importer) importPodTemplate(svc *appsv1alpha1.Service, template *corev1.PodTemplateSpec) {
+	pod := template.Spec
+
+	if len(pod.Containers) == 0 {
+		i.warn("the Deployment has no containers")
+		return
+	}
+	for _, c := range pod.Containers[1:] {
+		i.warn("container %s is not imported, the deployer runs a single container", c.Name)
+	}
+	for _, c := range pod.InitContainers {
+		i.warn("init container %s is not imported", c.Name)
+	}
+
+	container := pod.Containers[0]
+	i.importContainer(svc, container)
+	i.importVolumes(svc, pod.Volumes, container.VolumeMounts)
+	i.importSecurityContext(svc, template, container.SecurityContext)
+	i.importMetrics(svc, template.Annotations)
+
+	if pod.ServiceAccountName != "" && pod.ServiceAccountName != "default" {
+		svc.Spec.ServiceAccountName = pod.ServiceAccountName
+	}
+
+	svc.Spec.NodeSelector = pod.NodeSelector
+	svc.Spec.Tolerations = pod.Tolerations
+	svc.Spec.TopologySpreadConstraints = pod.TopologySpreadConstraints
+	svc.Spec.PriorityClassName = pod.PriorityClassName
+
+	if pod.Affinity != nil {
+		i.warn("the affinity of the pods is not imported, the deployer spreads the pods by antiAffinity")
+	}
+	if len(pod.ImagePullSecrets) > 0 {
+		i.warn("image pull secrets are not imported, the deployer config provides the credentials of registries")
+	}
+	if pod.HostNetwork || pod.HostPID || pod.HostIPC {
+		i.warn("host namespaces of the pods are not imported")
+	}
+	if pod.TerminationGracePeriodSeconds != nil {
+		i.warn("the termination grace period of the pods is not imported")
+	}
+}
+
+func (i *importer) importContainer(svc *appsv1alpha1.Service, container corev1.Container) {
+	svc.Spec.Image = container.Image
+	svc.Spec.Command = container.Command
+	svc.Spec.Args = container.Args
+	svc.Spec.ImagePullPolicy = container.ImagePullPolicy
+	svc.Spec.Resources = container.Resources
+
+	for _, p := range container.Ports {
+		name := p.Name
+		if name == "" {
+			name = fmt.Sprintf("port-%d", p.ContainerPort)
+		}
+		if p.Protocol != "" && p.Protocol != corev1.ProtocolTCP {
+			i.warn("protocol %s of port %s is not imported, all ports use TCP", p.Protocol, name)
+		}
+
+		svc.Spec.Ports = append(svc.Spec.Ports, appsv1alpha1.Port{
+			Name:      name,
+			Container: uint16(p.ContainerPort),
+			// ports not exposed by a Service are still exposed by the one of the deployer
+			Service: uint16(p.ContainerPort),
+		})
+	}
+
+	for _, env := range container.Env {
+		if env.ValueFrom != nil {
+			i.warn("env var %s is read from a reference and is not imported", env.Name)
+			continue
+		}
+		if svc.Spec.Env == nil {
+			svc.Spec.Env = appsv1alpha1.Environment{}
+		}
+		svc.Spec.Env[env.Name] = env.Value
+	}
+	if len(container.EnvFrom) > 0 {
+		i.warn("envFrom of the container is not imported")
+	}
+
+	if container.LivenessProbe != nil || container.ReadinessProbe != nil || container.StartupProbe != nil {
+		i.warn("probes of the container are not imported")
+	}
+	if container.Lifecycle != nil {
+		i.warn("lifecycle hooks of the container are not imported")
+	}
+	if container.WorkingDir != "" {
+		i.warn("the working dir of the container is not imported")
+	}
+}
+
+// importVolumes imports the mounted emptyDir and persistentVolumeClaim volumes. Claims are mounted as existing claims,
+// so they are kept if the service is deleted.
+func (i *importer) importVolumes(svc *appsv1alpha1.Service, volumes []corev1.Volume, mounts []corev1.VolumeMount) {
+	for _, mount := range mounts {
+		volume := findVolume(volumes, mount.Name)
+		if volume == nil {
+			continue
+		}
+		if mount.SubPath != "" || mount.SubPathExpr != "" {
+			i.warn("the sub path of volume %s is not imported", mount.Name)
+		}
+
+		imported := appsv1alpha1.Volume{Name: mount.Name, MountPath: mount.MountPath, ReadOnly: mount.ReadOnly}
+
+		switch {
+		case volume.EmptyDir != nil:
+			imported.EmptyDir = &appsv1alpha1.EmptyDirVolume{
+				Tmpfs:     volume.EmptyDir.Medium == corev1.StorageMediumMemory,
+				SizeLimit: volume.EmptyDir.SizeLimit,
+			}
+		case volume.PersistentVolumeClaim != nil:
+			imported.ExistingClaimName = volume.PersistentVolumeClaim.ClaimName
+		default:
+			i.warn("volume %s mounted at %s is not imported, only emptyDir and persistentVolumeClaim volumes are supported", mount.Name, mount.MountPath)
+			continue
+		}
+
+		svc.Spec.Volumes = append(svc.Spec.Volumes, imported)
+	}
+}
+
+func findVolume(volumes []corev1.Volume, name string) *corev1.Volume {
+	for idx := range volumes {
+		if volumes[idx].Name == name {
+			return &volumes[idx]
+		}
+	}
+
+	return nil
+}
+
+// importSecurityContext keeps the security settings of the pods. The hardened profile of the deployer is disabled, as
+// the app may not be able to run with it.
+func (i *importer) importSecurityContext(svc *appsv1alpha1.Service, template *corev1.PodTemplateSpec, container *corev1.SecurityContext) {
+	sc := &appsv1alpha1.SecurityContext{Profile: appsv1alpha1.SecurityProfileNone}
+	i.warn("the hardened security profile is disabled to keep the security context of the pods, consider enabling it")
+
+	if pod := template.Spec.SecurityContext; pod != nil {
+		sc.RunAsUser = pod.RunAsUser
+		sc.RunAsGroup = pod.RunAsGroup
+		sc.RunAsNonRoot = pod.RunAsNonRoot
+		sc.FSGroup = pod.FSGroup
+	}
+
+	if container != nil {
+		if container.RunAsUser != nil {
+			sc.RunAsUser = container.RunAsUser
+		}
+		if container.RunAsGroup != nil {
+			sc.RunAsGroup = container.RunAsGroup
+		}
+		if container.RunAsNonRoot != nil {
+			sc.RunAsNonRoot = container.RunAsNonRoot
+		}
+		sc.ReadOnlyRootFilesystem = container.ReadOnlyRootFilesystem
+		sc.AllowPrivilegeEscalation = container.AllowPrivilegeEscalation
+		sc.Capabilities = container.Capabilities
+
+		if container.Privileged != nil && *container.Privileged {
+			i.warn("the privileged mode of the container is not imported")
+		}
+	}
+
+	if profile, ok := template.Annotations[seccompPodAnnotation]; ok {
+		switch {
+		case profile == "runtime/default" || profile == "docker/default":
+			sc.SeccompProfile = &appsv1alpha1.SeccompProfile{Type: appsv1alpha1.SeccompProfileRuntimeDefault}
+		case profile == "unconfined":
+			sc.SeccompProfile = &appsv1alpha1.SeccompProfile{Type: appsv1alpha1.SeccompProfileUnconfined}
+		case strings.HasPrefix(profile, "localhost/"):
+			sc.SeccompProfile = &appsv1alpha1.SeccompProfile{
+				Type:             appsv1alpha1.SeccompProfileLocalhost,
+				LocalhostProfile: strings.TrimPrefix(profile, "localhost/"),
+			}
+		}
+	}
+
+	svc.Spec.SecurityContext = sc
+}
+
+// importMetrics converts the prometheus scrape annotations of the pods, all other annotations are reported
+func (i *importer) importMetrics(svc *appsv1alpha1.Service, annotations map[string]string) {
+	keys := make([]string, 0, len(annotations))
+	for key := range annotations {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		switch key {
+		case seccompPodAnnotation, prometheusScrapeAnnotation, prometheusPortAnnotation, prometheusPathAnnotation:
+		default:
+			i.warn("annotation %s of the pods is not imported", key)
+		}
+	}
+
+	if annotations[prometheusScrapeAnnotation] != "true" {
+		return
+	}
+
+	for _, p := range svc.Spec.Ports {
+		if fmt.Sprint(p.Container) == annotations[prometheusPortAnnotation] {
+			svc.Spec.Metrics = &appsv1alpha1.Metrics{Port: p.Name, Path: annotations[prometheusPathAnnotation]}
+			return
+		}
+	}
+
+	i.warn("the scrape annotations of the pods reference no port of the container and are not imported")
+}
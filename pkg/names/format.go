@@ -1,29 +1,68 @@
 package names
 
 import (
+	"crypto/sha256"
+	"encoding/hex"
 	"regexp"
 	"strings"
 )
 
+const (
+	// DNSLabelMaxLength is the maximum length of names that need to be valid DNS labels (RFC 1123), e.g. services
+	DNSLabelMaxLength = 63
+	// DNSSubdomainMaxLength is the maximum length of names that need to be valid DNS subdomains, e.g. secrets
+	DNSSubdomainMaxLength = 253
+
+	// hashLength is the number of hex chars of the hash appended to truncated names
+	hashLength = 8
+)
+
 var nonAlphaNumeric = regexp.MustCompile(`[^a-z0-9\-]+`)
 var multiDashes = regexp.MustCompile(`[\-]{2,}`)
 
-// FormatDash takes some string and formats it into simple dash case
+// FormatDash takes some string and formats it into simple dash case that is a valid DNS label
 func FormatDash(value string) string {
+	return FormatDashWithLimit(value, DNSLabelMaxLength)
+}
+
+// FormatDashWithLimit takes some string and formats it into simple dash case of at most limit chars
+func FormatDashWithLimit(value string, limit int) string {
 	result := nonAlphaNumeric.ReplaceAllString(value, "-")
 	result = multiDashes.ReplaceAllString(result, "-")
-	return TrimDashes(result)
+	return Truncate(result, limit)
 }
 
 // TrimDashes cuts the string to 63 chars and trims all slashes
 func TrimDashes(value string) string {
-	if len(value) > 63 {
-		value = value[:62]
+	return Truncate(value, DNSLabelMaxLength)
+}
+
+// Truncate trims all dashes and cuts the string to at most limit chars. When the string needs to be cut a short hash
+// of the full value is appended, so that long values sharing a common prefix still result in distinct names.
+func Truncate(value string, limit int) string {
+	value = strings.Trim(value, "-")
+	if len(value) <= limit {
+		return value
 	}
-	return strings.Trim(value, "-")
+
+	hash := shortHash(value)
+	prefix := strings.TrimRight(value[:limit-hashLength-1], "-")
+
+	return prefix + "-" + hash
 }
 
 // FormatDashFromParts takes several string parts, joins them together and formats them correctly
 func FormatDashFromParts(parts ...string) string {
 	return FormatDash(strings.Join(parts, "-"))
 }
+
+// FormatDashFromPartsWithLimit takes several string parts, joins them together and formats them into at most
+// limit chars
+func FormatDashFromPartsWithLimit(limit int, parts ...string) string {
+	return FormatDashWithLimit(strings.Join(parts, "-"), limit)
+}
+
+func shortHash(value string) string {
+	sum := sha256.Sum256([]byte(value))
+	return hex.EncodeToString(sum[:])[:hashLength]
+}
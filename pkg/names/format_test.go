@@ -1,6 +1,7 @@
 package names
 
 import (
+	"strings"
 	"testing"
 )
 
@@ -54,3 +55,71 @@ func TestFormatDash(t *testing.T) {
 		})
 	}
 }
+
+func TestFormatDash_long(t *testing.T) {
+	prefix := strings.Repeat("a", 62)
+	first := FormatDash(prefix + "-first.example.com")
+	second := FormatDash(prefix + "-second.example.com")
+
+	if len(first) > DNSLabelMaxLength || len(second) > DNSLabelMaxLength {
+		t.Errorf("FormatDash() returned names longer than %d chars: %v, %v", DNSLabelMaxLength, first, second)
+	}
+
+	if first == second {
+		t.Errorf("FormatDash() returned the same name for distinct values: %v", first)
+	}
+
+	if again := FormatDash(prefix + "-first.example.com"); again != first {
+		t.Errorf("FormatDash() is not stable: %v != %v", again, first)
+	}
+
+	exact := strings.Repeat("b", DNSLabelMaxLength)
+	if got := FormatDash(exact); got != exact {
+		t.Errorf("FormatDash() = %v, want %v", got, exact)
+	}
+}
+
+func TestTruncate(t *testing.T) {
+	tests := []struct {
+		name  string
+		give  string
+		limit int
+		want  string
+	}{
+		{
+			name:  "short",
+			give:  "svc-docker-pull-registry",
+			limit: DNSLabelMaxLength,
+			want:  "svc-docker-pull-registry",
+		},
+		{
+			name:  "trim_dashes",
+			give:  "-svc-",
+			limit: DNSLabelMaxLength,
+			want:  "svc",
+		},
+		{
+			name:  "hashed",
+			give:  "abcdefghijklmnopqrstuvwxyz",
+			limit: 20,
+			want:  "abcdefghijk-" + shortHash("abcdefghijklmnopqrstuvwxyz"),
+		},
+		{
+			name:  "no_dash_before_hash",
+			give:  "abcdefghij-lmnopqrstuvwxyz",
+			limit: 20,
+			want:  "abcdefghij-" + shortHash("abcdefghij-lmnopqrstuvwxyz"),
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := Truncate(tt.give, tt.limit)
+			if got != tt.want {
+				t.Errorf("Truncate() = %v, want %v", got, tt.want)
+			}
+			if len(got) > tt.limit {
+				t.Errorf("Truncate() = %v is longer than %d chars", got, tt.limit)
+			}
+		})
+	}
+}
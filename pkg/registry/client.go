@@ -0,0 +1,359 @@
+// Package registry talks to docker registries using the registry HTTP API v2
+package registry
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultTimeout bounds the requests of a single call to the registry, so a hanging registry does not block the caller
+const DefaultTimeout = 30 * time.Second
+
+// manifestMediaTypes are accepted when resolving digests, manifest lists first so multi-arch images resolve to the
+// digest of the list instead of a single platform
+var manifestMediaTypes = []string{
+	"application/vnd.docker.distribution.manifest.list.v2+json",
+	"application/vnd.oci.image.index.v1+json",
+	"application/vnd.docker.distribution.manifest.v2+json",
+	"application/vnd.oci.image.manifest.v1+json",
+}
+
+// Auth holds the credentials used to authenticate against a registry; an empty Auth accesses it anonymously
+type Auth struct {
+	Username string
+	Password string
+}
+
+// Client resolves information about images from their registry
+type Client interface {
+	// Digest returns the digest of the manifest the reference points to
+	Digest(ctx context.Context, ref Reference, auth Auth) (string, error)
+	// Tags returns all tags of the repository of the reference
+	Tags(ctx context.Context, ref Reference, auth Auth) ([]string, error)
+}
+
+// Options configure the registry client
+type Options struct {
+	// HTTPClient is used for all requests, defaults to http.DefaultClient
+	HTTPClient *http.Client
+	// PlainHTTP lists registry hosts that are accessed without TLS
+	PlainHTTP []string
+	// Timeout bounds all requests of a single call, including authentication and pagination, defaults to
+	// DefaultTimeout
+	Timeout time.Duration
+}
+
+// NewClient returns a client for the registry HTTP API v2
+func NewClient(opts Options) Client {
+	if opts.HTTPClient == nil {
+		opts.HTTPClient = http.DefaultClient
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultTimeout
+	}
+
+	return &client{opts: opts}
+}
+
+type client struct {
+	opts Options
+}
+
+func (c *client) Digest(ctx context.Context, ref Reference, auth Auth) (string, error) {
+	if ref.Digest != "" {
+		return ref.Digest, nil
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	u := c.url(ref, fmt.Sprintf("/v2/%s/manifests/%s", ref.Repository, ref.Tag))
+	header := http.Header{"Accept": []string{strings.Join(manifestMediaTypes, ", ")}}
+
+	resp, err := c.do(ctx, http.MethodHead, u, header, ref, auth)
+	if err != nil {
+		return "", err
+	}
+	resp.Body.Close()
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	// not all registries return the digest on HEAD requests, so calculate it from the manifest
+	resp, err = c.do(ctx, http.MethodGet, u, header, ref, auth)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if digest := resp.Header.Get("Docker-Content-Digest"); digest != "" {
+		return digest, nil
+	}
+
+	sum := sha256.New()
+	if _, err := io.Copy(sum, resp.Body); err != nil {
+		return "", fmt.Errorf("failed to read manifest of %s: %v", ref, err)
+	}
+
+	return "sha256:" + hex.EncodeToString(sum.Sum(nil)), nil
+}
+
+func (c *client) Tags(ctx context.Context, ref Reference, auth Auth) ([]string, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.opts.Timeout)
+	defer cancel()
+
+	tags := make([]string, 0)
+	u := c.url(ref, fmt.Sprintf("/v2/%s/tags/list", ref.Repository))
+
+	for u != "" {
+		resp, err := c.do(ctx, http.MethodGet, u, nil, ref, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		page := struct {
+			Tags []string `json:"tags"`
+		}{}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if err != nil {
+			return nil, fmt.Errorf("failed to parse tags of %s: %v", ref.Name(), err)
+		}
+
+		tags = append(tags, page.Tags...)
+
+		u, err = nextPage(u, resp.Header.Get("Link"))
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	return tags, nil
+}
+
+// nextPage returns the url of the next page referenced by a header like `</v2/app/tags/list?n=100&last=b>; rel="next"`
+func nextPage(current, link string) (string, error) {
+	if link == "" {
+		return "", nil
+	}
+
+	start := strings.Index(link, "<")
+	end := strings.Index(link, ">")
+	if start == -1 || end < start || !strings.Contains(link[end:], `rel="next"`) {
+		return "", nil
+	}
+
+	base, err := url.Parse(current)
+	if err != nil {
+		return "", err
+	}
+
+	next, err := base.Parse(link[start+1 : end])
+	if err != nil {
+		return "", fmt.Errorf("invalid link header %q: %v", link, err)
+	}
+
+	return next.String(), nil
+}
+
+func (c *client) url(ref Reference, path string) string {
+	scheme := "https"
+	for _, host := range c.opts.PlainHTTP {
+		if NormalizeHost(host) == ref.Host {
+			scheme = "http"
+		}
+	}
+
+	return fmt.Sprintf("%s://%s%s", scheme, ref.endpoint(), path)
+}
+
+// do runs the request, answering authentication challenges of the registry, and fails on non-2xx responses
+func (c *client) do(ctx context.Context, method, u string, header http.Header, ref Reference, auth Auth) (*http.Response, error) {
+	resp, err := c.request(ctx, method, u, header, "")
+	if err != nil {
+		return nil, err
+	}
+
+	if resp.StatusCode == http.StatusUnauthorized {
+		challenge := resp.Header.Get("WWW-Authenticate")
+		resp.Body.Close()
+
+		authorization, err := c.authorize(ctx, challenge, ref, auth)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err = c.request(ctx, method, u, header, authorization)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		resp.Body.Close()
+		return nil, fmt.Errorf("registry returned %s for %s %s", resp.Status, method, u)
+	}
+
+	return resp, nil
+}
+
+func (c *client) request(ctx context.Context, method, u string, header http.Header, authorization string) (*http.Response, error) {
+	req, err := http.NewRequest(method, u, nil)
+	if err != nil {
+		return nil, err
+	}
+
+	req = req.WithContext(ctx)
+	for k, v := range header {
+		req.Header[k] = v
+	}
+	if authorization != "" {
+		req.Header.Set("Authorization", authorization)
+	}
+
+	resp, err := c.opts.HTTPClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to request %s: %v", u, err)
+	}
+
+	return resp, nil
+}
+
+// authorize returns the value of the authorization header answering the challenge
+func (c *client) authorize(ctx context.Context, challenge string, ref Reference, auth Auth) (string, error) {
+	scheme, params := parseChallenge(challenge)
+
+	switch strings.ToLower(scheme) {
+	case "basic":
+		if auth.Username == "" && auth.Password == "" {
+			return "", fmt.Errorf("registry %s requires credentials", ref.Host)
+		}
+		return basicAuthorization(auth), nil
+
+	case "bearer":
+		token, err := c.fetchToken(ctx, params, ref, auth)
+		if err != nil {
+			return "", err
+		}
+		return "Bearer " + token, nil
+
+	default:
+		return "", fmt.Errorf("registry %s sent unsupported authentication challenge %q", ref.Host, challenge)
+	}
+}
+
+func (c *client) fetchToken(ctx context.Context, params map[string]string, ref Reference, auth Auth) (string, error) {
+	realm, ok := params["realm"]
+	if !ok {
+		return "", fmt.Errorf("registry %s sent bearer challenge without realm", ref.Host)
+	}
+
+	u, err := url.Parse(realm)
+	if err != nil {
+		return "", fmt.Errorf("registry %s sent invalid realm %q: %v", ref.Host, realm, err)
+	}
+
+	query := u.Query()
+	if service, ok := params["service"]; ok {
+		query.Set("service", service)
+	}
+	scope, ok := params["scope"]
+	if !ok {
+		scope = fmt.Sprintf("repository:%s:pull", ref.Repository)
+	}
+	query.Set("scope", scope)
+	u.RawQuery = query.Encode()
+
+	authorization := ""
+	if auth.Username != "" || auth.Password != "" {
+		authorization = basicAuthorization(auth)
+	}
+
+	resp, err := c.request(ctx, http.MethodGet, u.String(), nil, authorization)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("failed to fetch token for %s: %s", ref.Host, resp.Status)
+	}
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", fmt.Errorf("failed to read token for %s: %v", ref.Host, err)
+	}
+
+	token := struct {
+		Token       string `json:"token"`
+		AccessToken string `json:"access_token"`
+	}{}
+	if err := json.Unmarshal(body, &token); err != nil {
+		return "", fmt.Errorf("failed to parse token for %s: %v", ref.Host, err)
+	}
+
+	if token.Token != "" {
+		return token.Token, nil
+	}
+	if token.AccessToken != "" {
+		return token.AccessToken, nil
+	}
+
+	return "", fmt.Errorf("registry %s returned an empty token", ref.Host)
+}
+
+func basicAuthorization(auth Auth) string {
+	req := &http.Request{Header: http.Header{}}
+	req.SetBasicAuth(auth.Username, auth.Password)
+	return req.Header.Get("Authorization")
+}
+
+// parseChallenge splits a WWW-Authenticate header like `Bearer realm="https://auth",service="registry"` into the
+// scheme and its parameters
+func parseChallenge(challenge string) (string, map[string]string) {
+	params := make(map[string]string)
+
+	parts := strings.SplitN(strings.TrimSpace(challenge), " ", 2)
+	if len(parts) < 2 {
+		return parts[0], params
+	}
+
+	for _, param := range splitParams(parts[1]) {
+		kv := strings.SplitN(param, "=", 2)
+		if len(kv) != 2 {
+			continue
+		}
+		params[strings.ToLower(strings.TrimSpace(kv[0]))] = strings.Trim(strings.TrimSpace(kv[1]), `"`)
+	}
+
+	return parts[0], params
+}
+
+// splitParams splits on commas that are not part of a quoted value, scopes may contain commas
+func splitParams(value string) []string {
+	params := make([]string, 0)
+	quoted := false
+	start := 0
+
+	for i, c := range value {
+		switch {
+		case c == '"':
+			quoted = !quoted
+		case c == ',' && !quoted:
+			params = append(params, value[start:i])
+			start = i + 1
+		}
+	}
+
+	return append(params, value[start:])
+}
@@ -0,0 +1,154 @@
+package registry
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testDigest = "sha256:0123456789abcdef0123456789abcdef0123456789abcdef0123456789abcdef"
+
+// newTestRegistry starts a local registry stand-in that requires a bearer token for user:password
+func newTestRegistry(t *testing.T) (*httptest.Server, Reference) {
+	mux := http.NewServeMux()
+	server := httptest.NewTLSServer(mux)
+
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		username, password, ok := r.BasicAuth()
+		if !ok || username != "user" || password != "password" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("scope") != "repository:team/app:pull" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		_, _ = w.Write([]byte(`{"token": "secret-token"}`))
+	})
+
+	mux.HandleFunc("/v2/team/app/manifests/", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:team/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if !strings.HasSuffix(r.URL.Path, "/1.0") {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.Header().Set("Docker-Content-Digest", testDigest)
+	})
+
+	mux.HandleFunc("/v2/team/app/tags/list", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret-token" {
+			w.Header().Set("WWW-Authenticate", `Bearer realm="`+server.URL+`/token",service="test",scope="repository:team/app:pull"`)
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		if r.URL.Query().Get("last") == "" {
+			w.Header().Set("Link", `</v2/team/app/tags/list?n=2&last=1.1>; rel="next"`)
+			_, _ = w.Write([]byte(`{"name": "team/app", "tags": ["1.0", "1.1"]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"name": "team/app", "tags": ["2.0"]}`))
+	})
+
+	ref, err := ParseReference(strings.TrimPrefix(server.URL, "https://") + "/team/app:1.0")
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+
+	return server, ref
+}
+
+func TestClient_Digest(t *testing.T) {
+	server, ref := newTestRegistry(t)
+	defer server.Close()
+
+	c := NewClient(Options{HTTPClient: server.Client()})
+
+	got, err := c.Digest(context.Background(), ref, Auth{Username: "user", Password: "password"})
+	if err != nil {
+		t.Fatalf("Digest() error = %v", err)
+	}
+	if got != testDigest {
+		t.Errorf("Digest() = %v, want %v", got, testDigest)
+	}
+
+	if _, err := c.Digest(context.Background(), ref, Auth{Username: "user", Password: "wrong"}); err == nil {
+		t.Error("Digest() with wrong credentials should fail")
+	}
+
+	ref.Tag = "2.0"
+	if _, err := c.Digest(context.Background(), ref, Auth{Username: "user", Password: "password"}); err == nil {
+		t.Error("Digest() of unknown tag should fail")
+	}
+}
+
+func TestClient_Tags(t *testing.T) {
+	server, ref := newTestRegistry(t)
+	defer server.Close()
+
+	c := NewClient(Options{HTTPClient: server.Client()})
+
+	got, err := c.Tags(context.Background(), ref, Auth{Username: "user", Password: "password"})
+	if err != nil {
+		t.Fatalf("Tags() error = %v", err)
+	}
+	if want := []string{"1.0", "1.1", "2.0"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Tags() = %v, want %v", got, want)
+	}
+}
+
+func TestAuthFromDockerConfig(t *testing.T) {
+	content := []byte(`{"auths": {"https://registry.example.com": {"auth": "dXNlcjpwYXNzd29yZA=="}, "quay.io": {"username": "robot", "password": "token"}}}`)
+
+	tests := []struct {
+		name string
+		host string
+		want Auth
+	}{
+		{name: "auth", host: "registry.example.com", want: Auth{Username: "user", Password: "password"}},
+		{name: "username_password", host: "quay.io", want: Auth{Username: "robot", Password: "token"}},
+		{name: "unknown", host: "docker.io", want: Auth{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := AuthFromDockerConfig(content, tt.host)
+			if err != nil {
+				t.Fatalf("AuthFromDockerConfig() error = %v", err)
+			}
+			if got != tt.want {
+				t.Errorf("AuthFromDockerConfig() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestClient_timeout(t *testing.T) {
+	done := make(chan struct{})
+	server := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-done
+	}))
+	defer server.Close()
+	defer close(done)
+
+	ref, err := ParseReference(strings.TrimPrefix(server.URL, "https://") + "/team/app:1.0")
+	if err != nil {
+		t.Fatalf("failed to parse reference: %v", err)
+	}
+
+	c := NewClient(Options{HTTPClient: server.Client(), Timeout: 50 * time.Millisecond})
+
+	start := time.Now()
+	if _, err := c.Tags(context.Background(), ref, Auth{}); err == nil {
+		t.Error("Tags() of a hanging registry should fail")
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("Tags() returned after %v, want the timeout to apply", elapsed)
+	}
+}
@@ -0,0 +1,48 @@
+package registry
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+type dockerConfigJSON struct {
+	Auths map[string]struct {
+		Auth     string `json:"auth"`
+		Username string `json:"username"`
+		Password string `json:"password"`
+	} `json:"auths"`
+}
+
+// AuthFromDockerConfig returns the credentials for the registry host from the content of a .dockerconfigjson file
+func AuthFromDockerConfig(content []byte, host string) (Auth, error) {
+	cfg := dockerConfigJSON{}
+	if err := json.Unmarshal(content, &cfg); err != nil {
+		return Auth{}, fmt.Errorf("failed to parse docker config: %v", err)
+	}
+
+	for registry, entry := range cfg.Auths {
+		if NormalizeHost(registry) != NormalizeHost(host) {
+			continue
+		}
+
+		if entry.Auth == "" {
+			return Auth{Username: entry.Username, Password: entry.Password}, nil
+		}
+
+		decoded, err := base64.StdEncoding.DecodeString(entry.Auth)
+		if err != nil {
+			return Auth{}, fmt.Errorf("failed to decode auth of registry %s: %v", registry, err)
+		}
+
+		parts := strings.SplitN(string(decoded), ":", 2)
+		if len(parts) != 2 {
+			return Auth{}, fmt.Errorf("invalid auth of registry %s", registry)
+		}
+
+		return Auth{Username: parts[0], Password: parts[1]}, nil
+	}
+
+	return Auth{}, nil
+}
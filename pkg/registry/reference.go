@@ -0,0 +1,146 @@
+package registry
+
+import (
+	"fmt"
+	"strings"
+)
+
+// DockerHub is the canonical host of the default docker registry
+const DockerHub = "docker.io"
+
+// dockerHubEndpoint is the host serving the registry API of docker hub
+const dockerHubEndpoint = "registry-1.docker.io"
+
+var dockerHubAliases = []string{
+	"index.docker.io",
+	"registry-1.docker.io",
+	"registry.hub.docker.com",
+}
+
+// Reference is a parsed image reference
+type Reference struct {
+	Host       string
+	Repository string
+	Tag        string
+	Digest     string
+}
+
+// ParseReference splits an image reference into its parts. Host and repository are normalized the same way the
+// container runtime does it, references without tag and digest point to the latest tag.
+func ParseReference(image string) (Reference, error) {
+	if image == "" {
+		return Reference{}, fmt.Errorf("image reference must not be empty")
+	}
+
+	ref := Reference{Host: Host(image)}
+
+	remainder := image
+	if i := strings.Index(image, "/"); i != -1 && isHost(image[:i]) {
+		remainder = image[i+1:]
+	}
+
+	if i := strings.Index(remainder, "@"); i != -1 {
+		ref.Digest = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+
+	if i := strings.LastIndex(remainder, ":"); i != -1 {
+		ref.Tag = remainder[i+1:]
+		remainder = remainder[:i]
+	}
+
+	if remainder == "" {
+		return Reference{}, fmt.Errorf("image reference %q has no repository", image)
+	}
+
+	if ref.Host == DockerHub && !strings.Contains(remainder, "/") {
+		remainder = "library/" + remainder
+	}
+
+	ref.Repository = remainder
+	if ref.Tag == "" && ref.Digest == "" {
+		ref.Tag = "latest"
+	}
+
+	return ref, nil
+}
+
+// Name returns the reference without tag and digest
+func (r Reference) Name() string {
+	return r.Host + "/" + r.Repository
+}
+
+// String returns the full reference
+func (r Reference) String() string {
+	result := r.Name()
+	if r.Tag != "" {
+		result += ":" + r.Tag
+	}
+	if r.Digest != "" {
+		result += "@" + r.Digest
+	}
+	return result
+}
+
+// ReplaceTag returns the image with its tag replaced and digest removed, keeping the notation of name and host
+func ReplaceTag(image, tag string) string {
+	name := image
+	if i := strings.Index(name, "@"); i != -1 {
+		name = name[:i]
+	}
+
+	// a colon after the last slash separates the tag, any other colon belongs to the port of the host
+	if i := strings.LastIndex(name, ":"); i != -1 && i > strings.LastIndex(name, "/") {
+		name = name[:i]
+	}
+
+	return name + ":" + tag
+}
+
+// endpoint returns the host serving the registry API
+func (r Reference) endpoint() string {
+	if r.Host == DockerHub {
+		return dockerHubEndpoint
+	}
+	return r.Host
+}
+
+// Host returns the registry host of an image reference. References without an explicit registry resolve to
+// docker hub, just like the container runtime does.
+func Host(image string) string {
+	i := strings.Index(image, "/")
+	if i == -1 || !isHost(image[:i]) {
+		return DockerHub
+	}
+
+	return NormalizeHost(image[:i])
+}
+
+func isHost(component string) bool {
+	return strings.ContainsAny(component, ".:") || component == "localhost"
+}
+
+// NormalizeHost strips scheme and path from a registry as written in docker config files and maps all docker hub
+// aliases to a single host, so it can be compared to the result of Host
+func NormalizeHost(registry string) string {
+	host := strings.ToLower(registry)
+	host = strings.TrimPrefix(host, "https://")
+	host = strings.TrimPrefix(host, "http://")
+
+	if i := strings.Index(host, "/"); i != -1 {
+		host = host[:i]
+	}
+
+	for _, alias := range dockerHubAliases {
+		if host == alias {
+			return DockerHub
+		}
+	}
+
+	return host
+}
+
+// MatchesHost returns true when the image is pulled from the given registry
+func MatchesHost(image, registry string) bool {
+	return Host(image) == NormalizeHost(registry)
+}
@@ -0,0 +1,127 @@
+package registry
+
+import "testing"
+
+func TestHost(t *testing.T) {
+	tests := []struct {
+		name string
+		give string
+		want string
+	}{
+		{name: "official", give: "nginx", want: "docker.io"},
+		{name: "official_tag", give: "nginx:1.17", want: "docker.io"},
+		{name: "user", give: "paulbouwer/hello-kubernetes:1.5", want: "docker.io"},
+		{name: "explicit_docker_hub", give: "index.docker.io/library/nginx", want: "docker.io"},
+		{name: "custom", give: "registry.gitlab.com/kubelix/deployer:latest", want: "registry.gitlab.com"},
+		{name: "port", give: "registry.local:5000/app", want: "registry.local:5000"},
+		{name: "localhost", give: "localhost/app", want: "localhost"},
+		{name: "digest", give: "quay.io/app@sha256:abcdef", want: "quay.io"},
+		{name: "uppercase", give: "Registry.Example.com/app", want: "registry.example.com"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Host(tt.give); got != tt.want {
+				t.Errorf("Host() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestNormalizeHost(t *testing.T) {
+	tests := []struct {
+		name string
+		give string
+		want string
+	}{
+		{name: "simple", give: "gitlab.com", want: "gitlab.com"},
+		{name: "scheme", give: "https://quay.io", want: "quay.io"},
+		{name: "docker_hub_v1", give: "https://index.docker.io/v1/", want: "docker.io"},
+		{name: "docker_hub", give: "docker.io", want: "docker.io"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NormalizeHost(tt.give); got != tt.want {
+				t.Errorf("NormalizeHost() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestParseReference(t *testing.T) {
+	tests := []struct {
+		name    string
+		give    string
+		want    Reference
+		wantErr bool
+	}{
+		{
+			name: "official",
+			give: "nginx",
+			want: Reference{Host: "docker.io", Repository: "library/nginx", Tag: "latest"},
+		},
+		{
+			name: "user_tag",
+			give: "paulbouwer/hello-kubernetes:1.5",
+			want: Reference{Host: "docker.io", Repository: "paulbouwer/hello-kubernetes", Tag: "1.5"},
+		},
+		{
+			name: "port",
+			give: "registry.local:5000/team/app:v1",
+			want: Reference{Host: "registry.local:5000", Repository: "team/app", Tag: "v1"},
+		},
+		{
+			name: "port_without_tag",
+			give: "registry.local:5000/team/app",
+			want: Reference{Host: "registry.local:5000", Repository: "team/app", Tag: "latest"},
+		},
+		{
+			name: "digest",
+			give: "quay.io/app@sha256:abcdef",
+			want: Reference{Host: "quay.io", Repository: "app", Digest: "sha256:abcdef"},
+		},
+		{
+			name: "tag_and_digest",
+			give: "quay.io/app:1.0@sha256:abcdef",
+			want: Reference{Host: "quay.io", Repository: "app", Tag: "1.0", Digest: "sha256:abcdef"},
+		},
+		{
+			name:    "empty",
+			give:    "",
+			wantErr: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := ParseReference(tt.give)
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseReference() error = %v, wantErr %v", err, tt.wantErr)
+				return
+			}
+			if got != tt.want {
+				t.Errorf("ParseReference() = %#v, want %#v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestReplaceTag(t *testing.T) {
+	tests := []struct {
+		name string
+		give string
+		tag  string
+		want string
+	}{
+		{name: "official", give: "nginx", tag: "1.17", want: "nginx:1.17"},
+		{name: "tag", give: "paulbouwer/hello-kubernetes:1.5", tag: "1.6", want: "paulbouwer/hello-kubernetes:1.6"},
+		{name: "port", give: "registry.local:5000/app", tag: "v2", want: "registry.local:5000/app:v2"},
+		{name: "port_and_tag", give: "registry.local:5000/app:v1", tag: "v2", want: "registry.local:5000/app:v2"},
+		{name: "digest", give: "quay.io/app:1.0@sha256:abcdef", tag: "1.1", want: "quay.io/app:1.1"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := ReplaceTag(tt.give, tt.tag); got != tt.want {
+				t.Errorf("ReplaceTag() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}